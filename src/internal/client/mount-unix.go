@@ -41,6 +41,9 @@ func fuseMount(mountpoint string, session *session.Session, opt MountOption) err
 			Name:              "wsfs",         // Second column in "df -T" will be shown as "fuse." + Name
 		},
 	}
+	if session.Capabilities().ReadOnly {
+		opts.MountOptions.Options = append(opts.MountOptions.Options, "ro")
+	}
 	if opt.EnableFuseLog {
 		opts.Logger = golanglog.New(os.Stderr, "", 0)
 	} else {
@@ -3,11 +3,15 @@
 package client
 
 import (
+	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"wsfs-core/internal/client/session"
 	"wsfs-core/internal/share/wsfsprotocol"
@@ -20,6 +24,7 @@ import (
 const (
 	sessionRecoveryRetryMaxCount = 30
 	sessionRecoveryRetrySeconds  = 5
+	resumeKeyLength              = 32
 )
 
 type MountOption struct {
@@ -36,59 +41,134 @@ type MountOption struct {
 	FlockMode          session.FlockMode
 	AllowedXAttrPrefix []string
 	DisableXAttrAppend bool
+	Compress           bool // offer permessage-deflate
+	CompressThreshold  int  // bytes; smaller messages are sent uncompressed
+	Conns              int  // connections of the session, if the server allows them
 }
 
-func dial(url, username, password, resumeId, expectedCertHash string) (conn *websocket.Conn, rsp *http.Response, err error) {
-	header := http.Header{}
-	if username != "" {
-		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+// newResumeKey generates the secret a session is bound to. Only this client
+// knows it, so the session can not be resumed by others knowing its id.
+func newResumeKey() (string, error) {
+	key := make([]byte, resumeKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
 	}
-	if resumeId != "" {
-		header.Set("X-Wsfs-Resume", resumeId)
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// sessionDialer dials the connections of a session, following the id the
+// server rotates on each resume.
+type sessionDialer struct {
+	url              string
+	username         string
+	password         string
+	resumeKey        string
+	expectedCertHash string
+	compress         compression
+
+	lock     sync.Mutex
+	resumeId string
+}
+
+func (d *sessionDialer) header() http.Header {
+	header := http.Header{}
+	if d.username != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(d.username+":"+d.password)))
 	}
+	header.Set("X-Wsfs-Resume-Key", d.resumeKey)
+	header.Set(wsfsprotocol.HeaderMaxMsgSize, strconv.Itoa(wsfsprotocol.MaxNegotiableMsgSize))
+	return header
+}
 
-	conn, rsp, err = wsdial(url, header, expectedCertHash)
+func (d *sessionDialer) getResumeId() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.resumeId
+}
+
+func (d *sessionDialer) dial(header http.Header) (conn *websocket.Conn, rsp *http.Response, err error) {
+	conn, rsp, err = wsdial(d.url, header, d.expectedCertHash, d.compress)
 	if err != nil {
 		return
 	}
-	if conn.Subprotocol() != wsfsprotocol.WSSubprotocol {
-		log.Error().Str("Negotiated", conn.Subprotocol()).Str("Want", wsfsprotocol.WSSubprotocol).Msg("Subprotocol mismatch")
+	if !wsfsprotocol.IsSupportedSubprotocol(conn.Subprotocol()) {
+		log.Error().Str("Negotiated", conn.Subprotocol()).Strs("Want", wsfsprotocol.WSSubprotocols).Msg("Subprotocol mismatch")
+		_ = conn.CloseNow()
 		err = errors.New("subprotocol mismatch")
 		return
 	}
 	return
 }
 
-func reDialFunc(url, username, password, resumeId, expectedCertHash string) func() (*websocket.Conn, error) {
-	if resumeId == "" {
-		return func() (*websocket.Conn, error) { return nil, errors.New("server do not support session resume") }
+func (d *sessionDialer) reDial(retryAfter time.Duration) (*websocket.Conn, wsfsprotocol.Capabilities, error) {
+	if d.getResumeId() == "" {
+		return nil, wsfsprotocol.Capabilities{}, errors.New("server do not support session resume")
+	}
+	if retryAfter > 0 {
+		log.Info().Str("RetryAfter", retryAfter.String()).Msg("Waiting for server to come back")
+		time.Sleep(retryAfter)
 	}
-	return func() (*websocket.Conn, error) {
-		for range sessionRecoveryRetryMaxCount {
-			conn, rsp, err := dial(url, username, password, resumeId, expectedCertHash)
-			if err == nil {
-				return conn, nil
+
+	for retries := 0; retries < sessionRecoveryRetryMaxCount; {
+		header := d.header()
+		header.Set("X-Wsfs-Resume", d.getResumeId())
+		conn, rsp, err := d.dial(header)
+		if err == nil {
+			// the server rotates the id on each resume
+			if id := rsp.Header.Get("X-Wsfs-Resume"); id != "" {
+				d.lock.Lock()
+				d.resumeId = id
+				d.lock.Unlock()
 			}
+			return conn, wsfsprotocol.ReadCapabilities(rsp.Header), nil
+		}
 
-			if rsp != nil {
-				switch rsp.StatusCode {
-				case http.StatusUnauthorized:
-					return nil, errors.New("http unauthorized")
-				case http.StatusBadRequest:
-					return nil, errors.New("this session can not be resumed")
-				case http.StatusPreconditionFailed:
-					log.Info().Msg("Waiting for session to be resumable")
-				default:
-					log.Error().Err(err).Msg("Unable to connect to server")
+		if rsp != nil {
+			switch rsp.StatusCode {
+			case http.StatusUnauthorized:
+				return nil, wsfsprotocol.Capabilities{}, errors.New("http unauthorized")
+			case http.StatusBadRequest:
+				return nil, wsfsprotocol.Capabilities{}, errors.New("this session can not be resumed")
+			case http.StatusPreconditionFailed:
+				log.Info().Msg("Waiting for session to be resumable")
+			case http.StatusServiceUnavailable:
+				// the server is shutting down, a wait that does not count
+				// as a retry
+				if seconds, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+					wait := max(time.Duration(seconds)*time.Second, time.Second)
+					log.Info().Str("RetryAfter", wait.String()).Msg("Server going away")
+					time.Sleep(wait)
+					continue
 				}
-			} else {
+				log.Error().Err(err).Msg("Unable to connect to server")
+			default:
 				log.Error().Err(err).Msg("Unable to connect to server")
 			}
-
-			time.Sleep(sessionRecoveryRetrySeconds * time.Second)
+		} else {
+			log.Error().Err(err).Msg("Unable to connect to server")
 		}
-		return nil, errors.New("too many retries")
+
+		retries++
+		time.Sleep(sessionRecoveryRetrySeconds * time.Second)
+	}
+	return nil, wsfsprotocol.Capabilities{}, errors.New("too many retries")
+}
+
+// join adds a connection to the running session. Only a server whose
+// session is still finishing the requests of the last connection of index
+// is worth trying again.
+func (d *sessionDialer) join(index int) (*websocket.Conn, error) {
+	header := d.header()
+	header.Set(wsfsprotocol.HeaderJoin, d.getResumeId())
+	header.Set(wsfsprotocol.HeaderConnIndex, strconv.Itoa(index))
+	conn, rsp, err := d.dial(header)
+	if err == nil {
+		return conn, nil
+	}
+	if rsp != nil && rsp.StatusCode != http.StatusPreconditionFailed {
+		return nil, fmt.Errorf("%w: http status %d", session.ErrJoinRefused, rsp.StatusCode)
 	}
+	return nil, err
 }
 
 func logDialError(rsp *http.Response, err error) {
@@ -113,24 +193,44 @@ func logDialError(rsp *http.Response, err error) {
 }
 
 func Mount(mountpoint, url, expectedCertHash, username, password string, opt MountOption) error {
-	conn, rsp, err := dial(url, username, password, "", expectedCertHash)
+	resumeKey, err := newResumeKey()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to generate resume key")
+		return err
+	}
+
+	dialer := &sessionDialer{
+		url:              url,
+		username:         username,
+		password:         password,
+		resumeKey:        resumeKey,
+		expectedCertHash: expectedCertHash,
+		compress:         compression{enable: opt.Compress, threshold: opt.CompressThreshold},
+	}
+	conn, rsp, err := dialer.dial(dialer.header())
 	if err != nil {
 		logDialError(rsp, err)
 		return err
 	}
 
-	resumeId := rsp.Header.Get("X-Wsfs-Resume")
-	if resumeId == "" {
+	dialer.resumeId = rsp.Header.Get("X-Wsfs-Resume")
+	var join session.JoinFunc
+	if dialer.resumeId == "" {
 		log.Warn().Msg("Server do not support session resume")
+	} else {
+		join = dialer.join
+	}
+	if opt.Compress && !strings.Contains(rsp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		log.Warn().Msg("Server do not support compression")
 	}
 
-	s, err := session.NewSession(reDialFunc(url, username, password, resumeId, expectedCertHash), opt.PingInterval, opt.AllowedXAttrPrefix, !opt.DisableXAttrAppend)
+	s, err := session.NewSession(dialer.reDial, join, opt.Conns, opt.PingInterval, opt.AllowedXAttrPrefix, !opt.DisableXAttrAppend)
 	if err != nil {
 		log.Error().Err(err).Msg("Unable to create session")
 		return err
 	}
 
-	s.Start(conn)
+	s.Start(conn, wsfsprotocol.ReadCapabilities(rsp.Header))
 
 	err = fuseMount(mountpoint, s, opt)
 	if err != nil {
@@ -45,7 +45,9 @@ func (d DirItem) PrefetchReady() <-chan struct{} {
 	return d.childReady
 }
 
-const maxWritePayload int = maxFrameSize - 6 // header(2) + FD(4)
+func (s *Session) maxWritePayload() int {
+	return s.msgSize() - s.headerSize() - 4 // FD(4)
+}
 
 func appendDirItemsFromReader(list []DirItem, r *bytes.Reader) ([]DirItem, error) {
 	for r.Len() > 0 {
@@ -135,7 +137,7 @@ func closeAllRemainingChildReady(list []DirItem, pendingDirs []int) {
 //	  and childReady is closed; for skipped or missing directories only
 //	  childReady is closed.
 func (s *Session) parseReadDirPlus(
-	clientMark uint8,
+	clientMark uint16,
 	rootCh chan<- readDirPlusResult,
 ) {
 	section := sectionRoot
@@ -298,12 +300,13 @@ func (s *Session) CmdReadDirPlus(path string) (list []DirItem, code uint8) {
 		return nil, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdReadDirPlus) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdReadDirPlus)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return nil, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdReadDirPlusStructToWriter(wsfsprotocol.CmdReadDirPlusStruct{Path: path}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdReadDirPlusStructToWriter(wsfsprotocol.CmdReadDirPlusStruct{Path: path}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return nil, wsfsprotocol.ErrorIO
@@ -319,17 +322,21 @@ func (s *Session) CmdReadDirPlus(path string) (list []DirItem, code uint8) {
 }
 
 func (s *Session) CmdOpen(path string, oflag uint32, fmode uint32) (uint32, uint8) {
+	if s.Capabilities().ReadOnly && wsfsprotocol.OpenFlagWrites(oflag) {
+		return 0, wsfsprotocol.ErrorAccessRestricted
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return 0, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdOpen) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdOpen)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdOpenStructToWriter(wsfsprotocol.CmdOpenStruct{Path: path, OFlag: oflag, FMode: fmode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdOpenStructToWriter(wsfsprotocol.CmdOpenStruct{Path: path, OFlag: oflag, FMode: fmode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
@@ -360,12 +367,13 @@ func (s *Session) CmdClose(fd uint32) uint8 {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdClose) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdClose)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdCloseStructToWriter(wsfsprotocol.CmdCloseStruct{FD: fd}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdCloseStructToWriter(wsfsprotocol.CmdCloseStruct{FD: fd}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -384,12 +392,13 @@ func (s *Session) CmdRead(fd uint32, dest []byte) (uint64, uint8) {
 		return 0, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdRead) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdRead)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdReadStructToWriter(wsfsprotocol.CmdReadStruct{FD: fd, Size: uint64(len(dest))}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdReadStructToWriter(wsfsprotocol.CmdReadStruct{FD: fd, Size: uint64(len(dest))}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
@@ -418,12 +427,13 @@ func (s *Session) CmdReadDir(path string) (list []DirItem, code uint8) {
 		return nil, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdReadDir) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdReadDir)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return nil, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdReadDirStructToWriter(wsfsprotocol.CmdReadDirStruct{Path: path}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdReadDirStructToWriter(wsfsprotocol.CmdReadDirStruct{Path: path}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return nil, wsfsprotocol.ErrorIO
@@ -465,12 +475,13 @@ func (s *Session) CmdReadLink(lpath string) (tpath string, code uint8) {
 		return "", wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdReadLink) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdReadLink)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return "", wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdReadLinkStructToWriter(wsfsprotocol.CmdReadLinkStruct{Path: lpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdReadLinkStructToWriter(wsfsprotocol.CmdReadLinkStruct{Path: lpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return "", wsfsprotocol.ErrorIO
@@ -496,18 +507,23 @@ func (s *Session) CmdReadLink(lpath string) (tpath string, code uint8) {
 }
 
 func (s *Session) CmdWrite(fd uint32, data []byte) (written uint64, code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdWrite); refused {
+		return 0, ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return 0, wsfsprotocol.ErrorIO
 	}
 
+	maxWritePayload := s.maxWritePayload()
 	if len(data) <= maxWritePayload {
-		if !s.beginRequest(clientMark, wsfsprotocol.CmdWrite) {
+		c := s.beginRequest(clientMark, wsfsprotocol.CmdWrite)
+		if c == nil {
 			s.releaseClientMark(clientMark)
 			return 0, wsfsprotocol.ErrorIO
 		}
-		err := wsfsprotocol.WriteCmdWriteStructToWriter(wsfsprotocol.CmdWriteStruct{FD: fd, Data: data}, s.writer)
-		s.writeDone(err)
+		err := wsfsprotocol.WriteCmdWriteStructToWriter(wsfsprotocol.CmdWriteStruct{FD: fd, Data: data}, c.writer)
+		c.writeDone(err)
 		if err != nil {
 			s.releaseClientMark(clientMark)
 			return 0, wsfsprotocol.ErrorIO
@@ -564,12 +580,13 @@ func (s *Session) CmdSeek(fd uint32, whence uint8, off int64) (pos uint64, code
 		return 0, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSeek) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSeek)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return
 	}
-	err := wsfsprotocol.WriteCmdSeekStructToWriter(wsfsprotocol.CmdSeekStruct{FD: fd, Whence: whence, Offset: off}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSeekStructToWriter(wsfsprotocol.CmdSeekStruct{FD: fd, Whence: whence, Offset: off}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return
@@ -595,17 +612,21 @@ func (s *Session) CmdSeek(fd uint32, whence uint8, off int64) (pos uint64, code
 }
 
 func (s *Session) CmdAllocate(fd uint32, flag uint32, off uint64, size uint64) uint8 {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdAllocate); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdAllocate) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdAllocate)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdAllocateStructToWriter(wsfsprotocol.CmdAllocateStruct{FD: fd, Flag: flag, Offset: off, Size: size}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdAllocateStructToWriter(wsfsprotocol.CmdAllocateStruct{FD: fd, Flag: flag, Offset: off, Size: size}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -624,12 +645,13 @@ func (s *Session) CmdGetAttr(fpath string) (fi wsfsprotocol.FileInfo, code uint8
 		return fi, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdGetAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdGetAttr)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return
 	}
-	err := wsfsprotocol.WriteCmdGetAttrStructToWriter(wsfsprotocol.CmdGetAttrStruct{Path: fpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdGetAttrStructToWriter(wsfsprotocol.CmdGetAttrStruct{Path: fpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return
@@ -655,17 +677,21 @@ func (s *Session) CmdGetAttr(fpath string) (fi wsfsprotocol.FileInfo, code uint8
 }
 
 func (s *Session) CmdSetAttr(fpath string, flag uint8, fi wsfsprotocol.FileInfo) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdSetAttr); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSetAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSetAttr)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSetAttrStructToWriter(wsfsprotocol.CmdSetAttrStruct{Path: fpath, Flag: flag, FI: fi}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSetAttrStructToWriter(wsfsprotocol.CmdSetAttrStruct{Path: fpath, Flag: flag, FI: fi}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -684,12 +710,13 @@ func (s *Session) CmdSync(fd uint32) (code uint8) {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSync) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSync)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSyncStructToWriter(wsfsprotocol.CmdSyncStruct{FD: fd}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSyncStructToWriter(wsfsprotocol.CmdSyncStruct{FD: fd}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -703,17 +730,21 @@ func (s *Session) CmdSync(fd uint32) (code uint8) {
 }
 
 func (s *Session) CmdMkdir(fpath string, mode uint32) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdMkdir); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdMkdir) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdMkdir)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdMkdirStructToWriter(wsfsprotocol.CmdMkdirStruct{Path: fpath, Mode: mode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdMkdirStructToWriter(wsfsprotocol.CmdMkdirStruct{Path: fpath, Mode: mode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -727,17 +758,21 @@ func (s *Session) CmdMkdir(fpath string, mode uint32) (code uint8) {
 }
 
 func (s *Session) CmdSymLink(target string, fpath string) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdSymLink); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSymLink) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSymLink)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSymLinkStructToWriter(wsfsprotocol.CmdSymLinkStruct{TargetPath: target, FilePath: fpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSymLinkStructToWriter(wsfsprotocol.CmdSymLinkStruct{TargetPath: target, FilePath: fpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -751,17 +786,21 @@ func (s *Session) CmdSymLink(target string, fpath string) (code uint8) {
 }
 
 func (s *Session) CmdRemove(fpath string) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdRemove); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdRemove) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdRemove)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdRemoveStructToWriter(wsfsprotocol.CmdRemoveStruct{Path: fpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdRemoveStructToWriter(wsfsprotocol.CmdRemoveStruct{Path: fpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -775,17 +814,21 @@ func (s *Session) CmdRemove(fpath string) (code uint8) {
 }
 
 func (s *Session) CmdRmDir(fpath string) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdRmDir); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdRmDir) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdRmDir)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdRmDirStructToWriter(wsfsprotocol.CmdRmDirStruct{Path: fpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdRmDirStructToWriter(wsfsprotocol.CmdRmDirStruct{Path: fpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -804,12 +847,13 @@ func (s *Session) CmdFsStat(fpath string) (fsi wsfsprotocol.RspFsStat, code uint
 		return fsi, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdFsStat) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdFsStat)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return
 	}
-	err := wsfsprotocol.WriteCmdFsStatStructToWriter(wsfsprotocol.CmdFsStatStruct{Path: fpath}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdFsStatStructToWriter(wsfsprotocol.CmdFsStatStruct{Path: fpath}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return
@@ -839,12 +883,13 @@ func (s *Session) CmdReadAt(fd uint32, offset uint64, dest []byte) (uint64, uint
 		return 0, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdReadAt) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdReadAt)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdReadAtStructToWriter(wsfsprotocol.CmdReadAtStruct{FD: fd, Offset: offset, Size: uint64(len(dest))}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdReadAtStructToWriter(wsfsprotocol.CmdReadAtStruct{FD: fd, Offset: offset, Size: uint64(len(dest))}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return 0, wsfsprotocol.ErrorIO
@@ -867,21 +912,27 @@ func (s *Session) CmdReadAt(fd uint32, offset uint64, dest []byte) (uint64, uint
 	}
 }
 
-const maxWriteAtPayload int = maxFrameSize - 14 // header(2) + FD(4) + Offset(8)
+func (s *Session) maxWriteAtPayload() int {
+	return s.msgSize() - s.headerSize() - 12 // FD(4) + Offset(8)
+}
 
 func (s *Session) CmdWriteAt(fd uint32, offset uint64, data []byte) (written uint64, code uint8) {
-	if len(data) <= maxWriteAtPayload {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdWriteAt); refused {
+		return 0, ec
+	}
+	if len(data) <= s.maxWriteAtPayload() {
 		clientMark, ok := s.newClientMark()
 		if !ok {
 			return 0, wsfsprotocol.ErrorIO
 		}
 
-		if !s.beginRequest(clientMark, wsfsprotocol.CmdWriteAt) {
+		c := s.beginRequest(clientMark, wsfsprotocol.CmdWriteAt)
+		if c == nil {
 			s.releaseClientMark(clientMark)
 			return 0, wsfsprotocol.ErrorIO
 		}
-		err := wsfsprotocol.WriteCmdWriteAtStructToWriter(wsfsprotocol.CmdWriteAtStruct{FD: fd, Offset: offset, Data: data}, s.writer)
-		s.writeDone(err)
+		err := wsfsprotocol.WriteCmdWriteAtStructToWriter(wsfsprotocol.CmdWriteAtStruct{FD: fd, Offset: offset, Data: data}, c.writer)
+		c.writeDone(err)
 		if err != nil {
 			s.releaseClientMark(clientMark)
 			return 0, wsfsprotocol.ErrorIO
@@ -924,17 +975,21 @@ func (s *Session) CmdWriteAt(fd uint32, offset uint64, data []byte) (written uin
 }
 
 func (s *Session) CmdRename(old string, new string, mode uint32) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdRename); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdRename) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdRename)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdRenameStructToWriter(wsfsprotocol.CmdRenameStruct{OldPath: old, NewPath: new, Flag: mode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdRenameStructToWriter(wsfsprotocol.CmdRenameStruct{OldPath: old, NewPath: new, Flag: mode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -948,6 +1003,9 @@ func (s *Session) CmdRename(old string, new string, mode uint32) (code uint8) {
 }
 
 func (s *Session) CmdCopyFileRange(wfd1 uint32, wfd2 uint32, off1 uint64, off2 uint64, size uint64) (copied uint64, code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdCopyFileRange); refused {
+		return 0, ec
+	}
 	var total uint64
 	for size > 0 {
 		chunk := min(size, wsfsprotocol.MaxCopyFileRangeChunk)
@@ -972,14 +1030,15 @@ func (s *Session) cmdCopyFileRangeOnce(wfd1 uint32, wfd2 uint32, off1 uint64, of
 		return 0, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdCopyFileRange) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdCopyFileRange)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return
 	}
 	err := wsfsprotocol.WriteCmdCopyFileRangeStructToWriter(wsfsprotocol.CmdCopyFileRangeStruct{
 		SrcFD: wfd1, DstFD: wfd2, SrcOffset: off1, DstOffset: off2, Size: size,
-	}, s.writer)
-	s.writeDone(err)
+	}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return
@@ -1005,19 +1064,23 @@ func (s *Session) cmdCopyFileRangeOnce(wfd1 uint32, wfd2 uint32, off1 uint64, of
 }
 
 func (s *Session) CmdCloneFileRange(wfd1 uint32, wfd2 uint32, off1 uint64, off2 uint64, size uint64) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdCloneFileRange); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdCloneFileRange) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdCloneFileRange)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
 	err := wsfsprotocol.WriteCmdCloneFileRangeStructToWriter(wsfsprotocol.CmdCloneFileRangeStruct{
 		SrcFD: wfd1, DstFD: wfd2, SrcOffset: off1, DstOffset: off2, Size: size,
-	}, s.writer)
-	s.writeDone(err)
+	}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -1036,12 +1099,13 @@ func (s *Session) CmdGetFileLock(fd uint32, fileLock wsfsprotocol.FileLockInfo)
 		return out, wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdGetFileLock) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdGetFileLock)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return out, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdGetFileLockStructToWriter(wsfsprotocol.CmdGetFileLockStruct{FD: fd, FileLock: fileLock}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdGetFileLockStructToWriter(wsfsprotocol.CmdGetFileLockStruct{FD: fd, FileLock: fileLock}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return out, wsfsprotocol.ErrorIO
@@ -1071,12 +1135,13 @@ func (s *Session) CmdSetFileLock(fd uint32, fileLock wsfsprotocol.FileLockInfo)
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSetFileLock) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSetFileLock)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSetFileLockStructToWriter(wsfsprotocol.CmdSetFileLockStruct{FD: fd, FileLock: fileLock}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSetFileLockStructToWriter(wsfsprotocol.CmdSetFileLockStruct{FD: fd, FileLock: fileLock}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -1095,12 +1160,13 @@ func (s *Session) CmdSetFileLockWait(fd uint32, fileLock wsfsprotocol.FileLockIn
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSetFileLockWait) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSetFileLockWait)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSetFileLockWaitStructToWriter(wsfsprotocol.CmdSetFileLockWaitStruct{FD: fd, FileLock: fileLock}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSetFileLockWaitStructToWriter(wsfsprotocol.CmdSetFileLockWaitStruct{FD: fd, FileLock: fileLock}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -1114,17 +1180,21 @@ func (s *Session) CmdSetFileLockWait(fd uint32, fileLock wsfsprotocol.FileLockIn
 }
 
 func (s *Session) CmdSetAttrByFD(wfd uint32, flag uint8, fi wsfsprotocol.FileInfo) (code uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdSetAttrByFD); refused {
+		return ec
+	}
 	clientMark, ok := s.newClientMark()
 	if !ok {
 		return wsfsprotocol.ErrorIO
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSetAttrByFD) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSetAttrByFD)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSetAttrByFDStructToWriter(wsfsprotocol.CmdSetAttrByFDStruct{FD: wfd, Flag: flag, FI: fi}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSetAttrByFDStructToWriter(wsfsprotocol.CmdSetAttrByFDStruct{FD: wfd, Flag: flag, FI: fi}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return wsfsprotocol.ErrorIO
@@ -1138,6 +1208,9 @@ func (s *Session) CmdSetAttrByFD(wfd uint32, flag uint8, fi wsfsprotocol.FileInf
 }
 
 func (s *Session) CmdSetXAttr(path string, key string, value []byte, mode uint32) uint8 {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdSetXAttr); refused {
+		return ec
+	}
 	if code := s.validateXAttr(key, mode, true); code != wsfsprotocol.ErrorOK {
 		return code
 	}
@@ -1179,11 +1252,12 @@ func (s *Session) CmdGetXAttr(path string, key string, mode uint32) ([]byte, uin
 	}
 	defer s.releaseClientMark(clientMark)
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdGetXAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdGetXAttr)
+	if c == nil {
 		return nil, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdGetXAttrStructToWriter(wsfsprotocol.CmdGetXAttrStruct{Path: path, Key: key, Mode: mode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdGetXAttrStructToWriter(wsfsprotocol.CmdGetXAttrStruct{Path: path, Key: key, Mode: mode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		return nil, wsfsprotocol.ErrorIO
 	}
@@ -1200,11 +1274,12 @@ func (s *Session) CmdListXAttr(path string, mode uint32) ([]byte, uint8) {
 	}
 	defer s.releaseClientMark(clientMark)
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdListXAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdListXAttr)
+	if c == nil {
 		return nil, wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdListXAttrStructToWriter(wsfsprotocol.CmdListXAttrStruct{Path: path, Mode: mode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdListXAttrStructToWriter(wsfsprotocol.CmdListXAttrStruct{Path: path, Mode: mode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		return nil, wsfsprotocol.ErrorIO
 	}
@@ -1220,6 +1295,9 @@ func (s *Session) CmdListXAttr(path string, mode uint32) ([]byte, uint8) {
 }
 
 func (s *Session) CmdRemoveXAttr(path string, key string, mode uint32) uint8 {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdRemoveXAttr); refused {
+		return ec
+	}
 	if code := s.validateXAttr(key, mode, false); code != wsfsprotocol.ErrorOK {
 		return code
 	}
@@ -1229,11 +1307,12 @@ func (s *Session) CmdRemoveXAttr(path string, key string, mode uint32) uint8 {
 	}
 	defer s.releaseClientMark(clientMark)
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdRemoveXAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdRemoveXAttr)
+	if c == nil {
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdRemoveXAttrStructToWriter(wsfsprotocol.CmdRemoveXAttrStruct{Path: path, Key: key, Mode: mode}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdRemoveXAttrStructToWriter(wsfsprotocol.CmdRemoveXAttrStruct{Path: path, Key: key, Mode: mode}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		return wsfsprotocol.ErrorIO
 	}
@@ -1250,11 +1329,12 @@ func (s *Session) sendSetXAttr(path string, key string, value []byte, mode uint3
 	}
 	defer s.releaseClientMark(clientMark)
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdSetXAttr) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdSetXAttr)
+	if c == nil {
 		return wsfsprotocol.ErrorIO
 	}
-	err := wsfsprotocol.WriteCmdSetXAttrStructToWriter(wsfsprotocol.CmdSetXAttrStruct{Path: path, Flag: mode, Key: key, Value: value}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteCmdSetXAttrStructToWriter(wsfsprotocol.CmdSetXAttrStruct{Path: path, Flag: mode, Key: key, Value: value}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		return wsfsprotocol.ErrorIO
 	}
@@ -1264,7 +1344,7 @@ func (s *Session) sendSetXAttr(path string, key string, value []byte, mode uint3
 	return code
 }
 
-func (s *Session) readXAttrData(clientMark uint8) ([]byte, uint8) {
+func (s *Session) readXAttrData(clientMark uint16) ([]byte, uint8) {
 	var data []byte
 	for {
 		rsp := <-s.responses[clientMark]
@@ -1324,16 +1404,16 @@ func (s *Session) restoreXAttr(path string, key string, oldValue []byte, oldExis
 }
 
 func (s *Session) setXAttrFits(path string, key string, value []byte, mode uint32) bool {
-	return 2+wsfsprotocol.GetCmdSetXAttrStructRequiredSize(wsfsprotocol.CmdSetXAttrStruct{
+	return s.headerSize()+wsfsprotocol.GetCmdSetXAttrStructRequiredSize(wsfsprotocol.CmdSetXAttrStruct{
 		Path: path, Flag: mode, Key: key, Value: value,
-	}) <= wsfsprotocol.MaxCommandLength
+	}) <= s.msgSize()
 }
 
 func (s *Session) maxXAttrChunkSize(path string, key string, mode uint32) int {
 	if !s.setXAttrFits(path, key, nil, mode) {
 		return 0
 	}
-	low, high := 0, wsfsprotocol.MaxCommandLength
+	low, high := 0, s.msgSize()
 	for low < high {
 		mid := low + (high-low+1)/2
 		if s.setXAttrFits(path, key, make([]byte, mid), mode) {
@@ -1358,7 +1438,7 @@ func (s *Session) validateXAttr(key string, mode uint32, isSet bool) uint8 {
 	} else if mode&^wsfsprotocol.XATTR_NOFOLLOW != 0 {
 		return wsfsprotocol.ErrorInvalid
 	}
-	for _, prefix := range s.allowedXAttrPrefix {
+	for _, prefix := range *s.xattrPrefixes.Load() {
 		if strings.HasPrefix(key, prefix) {
 			return wsfsprotocol.ErrorOK
 		}
@@ -1374,7 +1454,7 @@ func (s *Session) filterXAttrList(data []byte) ([]byte, bool) {
 			return nil, false
 		}
 		key := string(data[:end])
-		for _, prefix := range s.allowedXAttrPrefix {
+		for _, prefix := range *s.xattrPrefixes.Load() {
 			if strings.HasPrefix(key, prefix) {
 				filtered = append(filtered, data[:end+1]...)
 				break
@@ -0,0 +1,270 @@
+package session
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CompoundStep is a step of CmdCompound: a command of
+// wsfsprotocol.CompoundStepCommands and its request struct.
+type CompoundStep struct {
+	Cmd uint8
+	Req any
+}
+
+func writeCompoundStep(step CompoundStep, w *util.Buffer) error {
+	if _, err := w.Write([]byte{step.Cmd}); err != nil {
+		return err
+	}
+	switch req := step.Req.(type) {
+	case wsfsprotocol.CmdOpenStruct:
+		return wsfsprotocol.WriteCmdOpenStructToWriter(req, w)
+	case wsfsprotocol.CmdCloseStruct:
+		return wsfsprotocol.WriteCmdCloseStructToWriter(req, w)
+	case wsfsprotocol.CmdGetAttrStruct:
+		return wsfsprotocol.WriteCmdGetAttrStructToWriter(req, w)
+	case wsfsprotocol.CmdMkdirStruct:
+		return wsfsprotocol.WriteCmdMkdirStructToWriter(req, w)
+	case wsfsprotocol.CmdReadAtStruct:
+		return wsfsprotocol.WriteCmdReadAtStructToWriter(req, w)
+	case wsfsprotocol.CmdGetAttrByFDStruct:
+		return wsfsprotocol.WriteCmdGetAttrByFDStructToWriter(req, w)
+	}
+	return wsfsprotocol.ErrBadCompound
+}
+
+// CmdCompound runs steps on the server in one round trip. It returns the
+// results of the steps run, up to the first failed one, whose error code is
+// returned. The result bodies are copies.
+func (s *Session) CmdCompound(steps []CompoundStep) ([]wsfsprotocol.CompoundResult, uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdCompound); refused {
+		return nil, ec
+	}
+	if len(steps) == 0 || len(steps) > wsfsprotocol.MaxCompoundSteps {
+		return nil, wsfsprotocol.ErrorInvalid
+	}
+	msgSize := s.msgSize()
+	stepsBuf := bufPool.Get(msgSize)
+	defer bufPool.Put(stepsBuf)
+	for _, step := range steps {
+		if err := writeCompoundStep(step, stepsBuf); err != nil {
+			return nil, wsfsprotocol.ErrorTooLong
+		}
+	}
+	if stepsBuf.Written() > msgSize-s.headerSize() {
+		return nil, wsfsprotocol.ErrorTooLong
+	}
+
+	clientMark, ok := s.newClientMark()
+	if !ok {
+		return nil, wsfsprotocol.ErrorIO
+	}
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdCompound)
+	if c == nil {
+		s.releaseClientMark(clientMark)
+		return nil, wsfsprotocol.ErrorIO
+	}
+	err := wsfsprotocol.WriteCmdCompoundStructToWriter(wsfsprotocol.CmdCompoundStruct{Steps: stepsBuf.Bytes[:stepsBuf.Written()]}, c.writer)
+	c.writeDone(err)
+	if err != nil {
+		s.releaseClientMark(clientMark)
+		return nil, wsfsprotocol.ErrorIO
+	}
+
+	rspBuf := <-s.responses[clientMark]
+	s.releaseClientMark(clientMark)
+	defer bufPool.Put(rspBuf)
+	code := rspBuf.Bytes[1]
+	results, err := wsfsprotocol.ReadCompoundResults(rspBuf.Bytes[2:rspBuf.Written()])
+	if err != nil || len(results) == 0 {
+		// an error of the command itself, not of a step
+		if code == wsfsprotocol.ErrorOK {
+			log.Error().Err(err).Msg("Failed to decode CmdCompound response")
+			return nil, wsfsprotocol.ErrorIO
+		}
+		return nil, code
+	}
+	for i := range results {
+		results[i].Body = bytes.Clone(results[i].Body)
+	}
+	return results, code
+}
+
+// ReadSmallFile opens, reads up to size bytes from the start of, and closes
+// a file in one round trip. The data may be cut to fit in a message, so a
+// file of size bytes or more may have been read partly.
+func (s *Session) ReadSmallFile(fpath string, size int) ([]byte, uint8) {
+	results, code := s.CmdCompound([]CompoundStep{
+		{wsfsprotocol.CmdOpen, wsfsprotocol.CmdOpenStruct{Path: fpath, OFlag: wsfsprotocol.O_RDONLY}},
+		{wsfsprotocol.CmdReadAt, wsfsprotocol.CmdReadAtStruct{FD: wsfsprotocol.CompoundFD(0), Size: uint64(size)}},
+		{wsfsprotocol.CmdClose, wsfsprotocol.CmdCloseStruct{FD: wsfsprotocol.CompoundFD(0)}},
+	})
+	if code == wsfsprotocol.ErrorNotSupport && results == nil {
+		return s.readSmallFileBySteps(fpath, size)
+	}
+	if code != wsfsprotocol.ErrorOK {
+		if len(results) == 2 {
+			// opened, but not read
+			if fd, ok := compoundOpenedFD(results[0]); ok {
+				s.CmdClose(fd)
+			}
+		}
+		return nil, code
+	}
+	return results[1].Body, code
+}
+
+func (s *Session) readSmallFileBySteps(fpath string, size int) ([]byte, uint8) {
+	fd, code := s.CmdOpen(fpath, wsfsprotocol.O_RDONLY, 0)
+	if code != wsfsprotocol.ErrorOK {
+		return nil, code
+	}
+	data := make([]byte, size)
+	n, code := s.CmdReadAt(fd, 0, data)
+	s.CmdClose(fd)
+	if code != wsfsprotocol.ErrorOK {
+		return nil, code
+	}
+	return data[:n], code
+}
+
+func compoundOpenedFD(result wsfsprotocol.CompoundResult) (uint32, bool) {
+	var rsp wsfsprotocol.RspOpen
+	if wsfsprotocol.ReadRspOpenFromReader(&rsp, bytes.NewReader(result.Body)) != nil {
+		return 0, false
+	}
+	return rsp.FD, true
+}
+
+// CmdOpenGetAttr opens a file and gets its attributes in one round trip.
+// The attributes are those of the opened file, even if the path is replaced
+// in between.
+func (s *Session) CmdOpenGetAttr(fpath string, oflag uint32, fmode uint32) (uint32, wsfsprotocol.FileInfo, uint8) {
+	if s.Capabilities().ReadOnly && wsfsprotocol.OpenFlagWrites(oflag) {
+		return 0, wsfsprotocol.FileInfo{}, wsfsprotocol.ErrorAccessRestricted
+	}
+	results, code := s.CmdCompound([]CompoundStep{
+		{wsfsprotocol.CmdOpen, wsfsprotocol.CmdOpenStruct{Path: fpath, OFlag: oflag, FMode: fmode}},
+		{wsfsprotocol.CmdGetAttrByFD, wsfsprotocol.CmdGetAttrByFDStruct{FD: wsfsprotocol.CompoundFD(0)}},
+	})
+	if code == wsfsprotocol.ErrorNotSupport && results == nil {
+		fd, code := s.CmdOpen(fpath, oflag, fmode)
+		if code != wsfsprotocol.ErrorOK {
+			return 0, wsfsprotocol.FileInfo{}, code
+		}
+		fi, code := s.CmdGetAttr(fpath)
+		if code != wsfsprotocol.ErrorOK {
+			s.CmdClose(fd)
+		}
+		return fd, fi, code
+	}
+	if len(results) == 0 || results[0].Code != wsfsprotocol.ErrorOK {
+		return 0, wsfsprotocol.FileInfo{}, code
+	}
+	fd, ok := compoundOpenedFD(results[0])
+	if !ok {
+		return 0, wsfsprotocol.FileInfo{}, wsfsprotocol.ErrorIO
+	}
+	if code != wsfsprotocol.ErrorOK {
+		s.CmdClose(fd)
+		return 0, wsfsprotocol.FileInfo{}, code
+	}
+	var rsp wsfsprotocol.RspGetAttr
+	if wsfsprotocol.ReadRspGetAttrFromReader(&rsp, bytes.NewReader(results[1].Body)) != nil {
+		s.CmdClose(fd)
+		return 0, wsfsprotocol.FileInfo{}, wsfsprotocol.ErrorIO
+	}
+	return fd, rsp.FI, code
+}
+
+// runEachStep runs steps in as few round trips as it can, resuming after a
+// failed step. It returns the code and body of each step.
+func (s *Session) runEachStep(steps []CompoundStep) ([]wsfsprotocol.CompoundResult, uint8) {
+	all := make([]wsfsprotocol.CompoundResult, 0, len(steps))
+	for len(all) < len(steps) {
+		batch := steps[len(all):]
+		if len(batch) > wsfsprotocol.MaxCompoundSteps {
+			batch = batch[:wsfsprotocol.MaxCompoundSteps]
+		}
+		results, code := s.CmdCompound(batch)
+		if len(results) == 0 {
+			return nil, code
+		}
+		all = append(all, results...)
+	}
+	return all, wsfsprotocol.ErrorOK
+}
+
+// CmdGetAttrs gets the attributes of paths, in one round trip unless some
+// of them fail. A failed path gets its error code.
+func (s *Session) CmdGetAttrs(fpaths []string) ([]wsfsprotocol.FileInfo, []uint8) {
+	fis := make([]wsfsprotocol.FileInfo, len(fpaths))
+	codes := make([]uint8, len(fpaths))
+	steps := make([]CompoundStep, len(fpaths))
+	for i, fpath := range fpaths {
+		steps[i] = CompoundStep{wsfsprotocol.CmdGetAttr, wsfsprotocol.CmdGetAttrStruct{Path: fpath}}
+	}
+	results, code := s.runEachStep(steps)
+	if results == nil {
+		for i, fpath := range fpaths {
+			if code == wsfsprotocol.ErrorNotSupport {
+				fis[i], codes[i] = s.CmdGetAttr(fpath)
+			} else {
+				codes[i] = code
+			}
+		}
+		return fis, codes
+	}
+	for i, result := range results {
+		codes[i] = result.Code
+		if result.Code != wsfsprotocol.ErrorOK {
+			continue
+		}
+		var rsp wsfsprotocol.RspGetAttr
+		if wsfsprotocol.ReadRspGetAttrFromReader(&rsp, bytes.NewReader(result.Body)) != nil {
+			codes[i] = wsfsprotocol.ErrorIO
+			continue
+		}
+		fis[i] = rsp.FI
+	}
+	return fis, codes
+}
+
+// CmdMkdirAll makes a directory and its missing parents, like mkdir -p.
+func (s *Session) CmdMkdirAll(fpath string, mode uint32) uint8 {
+	var steps []CompoundStep
+	dir := "/"
+	for name := range strings.SplitSeq(strings.Trim(path.Clean(fpath), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir = path.Join(dir, name)
+		steps = append(steps, CompoundStep{wsfsprotocol.CmdMkdir, wsfsprotocol.CmdMkdirStruct{Path: dir, Mode: mode}})
+	}
+	if len(steps) == 0 {
+		return wsfsprotocol.ErrorOK
+	}
+
+	results, code := s.runEachStep(steps)
+	if results == nil {
+		if code != wsfsprotocol.ErrorNotSupport {
+			return code
+		}
+		results = make([]wsfsprotocol.CompoundResult, len(steps))
+		for i, step := range steps {
+			req := step.Req.(wsfsprotocol.CmdMkdirStruct)
+			results[i].Code = s.CmdMkdir(req.Path, req.Mode)
+		}
+	}
+	for _, result := range results {
+		if result.Code != wsfsprotocol.ErrorOK && result.Code != wsfsprotocol.ErrorExists {
+			return result.Code
+		}
+	}
+	return wsfsprotocol.ErrorOK
+}
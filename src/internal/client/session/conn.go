@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wsfs-core/internal/share/wsfsprotocol"
+
+	"github.com/coder/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// JoinFunc connects again to add the connection of index to the session. It
+// returns ErrJoinRefused when trying again is useless.
+type JoinFunc func(index int) (*websocket.Conn, error)
+
+var ErrJoinRefused = errors.New("session: connection join refused")
+
+const (
+	joinRetryMaxCount = 30
+	joinRetryInterval = time.Second
+)
+
+// bulkCommands move file data. With several connections, they are spread
+// over the connections but the first, which is left to the other commands
+// so that they do not wait behind the data.
+var bulkCommands = []uint8{
+	wsfsprotocol.CmdRead, wsfsprotocol.CmdReadAt, wsfsprotocol.CmdWrite,
+	wsfsprotocol.CmdWriteAt, wsfsprotocol.CmdWriteStreamOpen,
+	wsfsprotocol.CmdReadStreamOpen,
+}
+
+// conn is one of the connections of a session. All the requests of a client
+// mark go on the connection its first request went on, since the server
+// keeps the streams of each connection apart.
+type conn struct {
+	index int
+	epoch uint64 // of the session when connected
+
+	ws        *websocket.Conn // nil once stopped
+	writer    io.WriteCloser
+	writeLock sync.Mutex
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	err       atomic.Pointer[error] // the first one
+}
+
+// setErr keeps the first error of the connection.
+func (c *conn) setErr(err error) {
+	c.err.CompareAndSwap(nil, &err)
+}
+
+// loadErr returns the first error of the connection, nil if none.
+func (c *conn) loadErr() error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (c *conn) close() {
+	c.writeLock.Lock()
+	ws := c.ws
+	c.writeLock.Unlock()
+	if ws == nil {
+		return
+	}
+	if err := ws.Close(websocket.StatusNormalClosure, ""); err != nil {
+		c.setErr(err)
+		c.ctxCancel()
+	}
+}
+
+func (c *conn) writeDone(err error) {
+	if c.writer != nil {
+		closeErr := c.writer.Close()
+		if err == nil {
+			err = closeErr
+		}
+		c.writer = nil
+	}
+	if err != nil {
+		c.setErr(err)
+		c.ws = nil
+		c.ctxCancel()
+	}
+	c.writeLock.Unlock()
+}
+
+// addConn must be called with lifecycleLock held.
+func (s *Session) addConn(index int, epoch uint64, ws *websocket.Conn) {
+	ws.SetReadLimit(int64(s.msgSize()))
+	c := &conn{index: index, epoch: epoch, ws: ws}
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	s.conns[index].Store(c)
+	go s.readLoop(c, ws)
+	if s.pingInterval > 0 {
+		go s.pingLoop(c, ws)
+	}
+}
+
+// runningConns returns the connections not stopped, the first first. It
+// must be called with lifecycleLock held.
+func (s *Session) runningConns() []*conn {
+	var conns []*conn
+	for i := range s.conns {
+		if c := s.conns[i].Load(); c != nil {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// joinConn connects the connection of index, as long as the session runs on
+// the connections of epoch.
+func (s *Session) joinConn(index int, epoch uint64) {
+	for range joinRetryMaxCount {
+		if s.epoch.Load() != epoch {
+			return
+		}
+		ws, err := s.join(index)
+		if err == nil {
+			s.lifecycleLock.Lock()
+			// a resume joins the connections before it runs again
+			running := s.state == sessionStateRunning || s.state == sessionStateRecovering
+			joined := running && s.epoch.Load() == epoch && s.conns[index].Load() == nil
+			if joined {
+				s.addConn(index, epoch, ws)
+			}
+			s.lifecycleLock.Unlock()
+			if !joined {
+				_ = ws.Close(websocket.StatusNormalClosure, "")
+				return
+			}
+			log.Info().Int("Conn", index).Msg("Connection joined")
+			return
+		}
+		if errors.Is(err, ErrJoinRefused) {
+			log.Warn().Err(err).Int("Conn", index).Msg("Connection not joined")
+			return
+		}
+		log.Info().Err(err).Int("Conn", index).Msg("Waiting for connection to be joinable")
+		time.Sleep(joinRetryInterval)
+	}
+	log.Warn().Int("Conn", index).Msg("Connection not joined, too many retries")
+}
+
+// connOf returns the connection of the requests of clientMark, choosing one
+// for cmd on its first request. It is nil if none is running.
+func (s *Session) connOf(clientMark uint16, cmd uint8) *conn {
+	if c := s.markConns[clientMark].Load(); c != nil {
+		return c
+	}
+	c := s.pickConn(cmd)
+	if c != nil {
+		s.markConns[clientMark].Store(c)
+	}
+	return c
+}
+
+func (s *Session) pickConn(cmd uint8) *conn {
+	count := int(s.connCount.Load())
+	if count > 1 && slices.Contains(bulkCommands, cmd) {
+		start := int(s.nextBulk.Add(1))
+		for i := range count - 1 {
+			if c := s.conns[1+(start+i)%(count-1)].Load(); c != nil {
+				return c
+			}
+		}
+	}
+	for i := range count {
+		if c := s.conns[i].Load(); c != nil {
+			return c
+		}
+	}
+	return nil
+}
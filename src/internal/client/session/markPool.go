@@ -0,0 +1,84 @@
+package session
+
+import (
+	"sync"
+	"wsfs-core/internal/share/wsfsprotocol"
+)
+
+// maxClientMarks is the requests a session may have in flight on a
+// connection with 2 byte client marks.
+const maxClientMarks = 4096
+
+// markPool hands out free client marks, waiting while none is free. On a
+// connection with 1 byte client marks, only the marks below 256 are handed
+// out.
+type markPool struct {
+	lock   sync.Mutex
+	cond   sync.Cond
+	narrow []uint16 // free marks below 256
+	wide   []uint16 // free marks from 256 on
+	limit  int      // marks usable on the connection
+	busy   [maxClientMarks]bool
+}
+
+func (p *markPool) init() {
+	p.cond.L = &p.lock
+	p.limit = wsfsprotocol.MaxNarrowClientMarks
+	for mark := maxClientMarks - 1; mark >= 0; mark-- {
+		if mark < wsfsprotocol.MaxNarrowClientMarks {
+			p.narrow = append(p.narrow, uint16(mark))
+		} else {
+			p.wide = append(p.wide, uint16(mark))
+		}
+	}
+}
+
+func (p *markPool) setLimit(limit int) {
+	p.lock.Lock()
+	p.limit = limit
+	p.cond.Broadcast()
+	p.lock.Unlock()
+}
+
+func (p *markPool) get() uint16 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for {
+		var mark uint16
+		if n := len(p.narrow); n > 0 {
+			mark, p.narrow = p.narrow[n-1], p.narrow[:n-1]
+		} else if n := len(p.wide); n > 0 && p.limit > wsfsprotocol.MaxNarrowClientMarks {
+			mark, p.wide = p.wide[n-1], p.wide[:n-1]
+		} else {
+			p.cond.Wait()
+			continue
+		}
+		p.busy[mark] = true
+		return mark
+	}
+}
+
+func (p *markPool) put(mark uint16) {
+	p.lock.Lock()
+	p.busy[mark] = false
+	if mark < wsfsprotocol.MaxNarrowClientMarks {
+		p.narrow = append(p.narrow, mark)
+	} else {
+		p.wide = append(p.wide, mark)
+	}
+	p.cond.Signal()
+	p.lock.Unlock()
+}
+
+// inUse returns the marks handed out and not put back.
+func (p *markPool) inUse() []uint16 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var marks []uint16
+	for mark, busy := range p.busy {
+		if busy {
+			marks = append(marks, uint16(mark))
+		}
+	}
+	return marks
+}
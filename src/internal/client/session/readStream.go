@@ -0,0 +1,221 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+)
+
+var errReadStreamIO = errors.New("read stream io error")
+
+// ReadStream receives the data of a file pushed by the server. The server
+// sends at most window bytes ahead of what was read, so the responses are
+// queued here rather than blocking the read loop.
+type ReadStream struct {
+	session    *Session
+	clientMark uint16
+	window     uint32
+	unacked    uint32 // bytes read since the last credit
+	offset     uint64 // of the next byte to read
+
+	lock    sync.Mutex
+	queue   []*util.Buffer
+	arrived chan struct{}
+
+	current *util.Buffer // being read, with its header skipped
+	pos     int
+	ended   bool  // final response read
+	code    uint8 // of the final response
+}
+
+// OpenReadStream asks the server to push the file from offset on. The
+// stream must be closed.
+func (s *Session) OpenReadStream(fd uint32, offset uint64, window uint32) (*ReadStream, uint8) {
+	if ec, refused := s.commandRefused(wsfsprotocol.CmdReadStreamOpen); refused {
+		return nil, ec
+	}
+	clientMark, ok := s.newClientMark()
+	if !ok {
+		return nil, wsfsprotocol.ErrorIO
+	}
+	rs := &ReadStream{
+		session:    s,
+		clientMark: clientMark,
+		window:     window,
+		offset:     offset,
+		arrived:    make(chan struct{}, 1),
+	}
+	s.readStreams[clientMark].Store(rs)
+
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdReadStreamOpen)
+	if c == nil {
+		s.readStreams[clientMark].Store(nil)
+		s.releaseClientMark(clientMark)
+		return nil, wsfsprotocol.ErrorIO
+	}
+	err := wsfsprotocol.WriteCmdReadStreamOpenStructToWriter(wsfsprotocol.CmdReadStreamOpenStruct{
+		FD:     fd,
+		Offset: offset,
+		Window: window,
+	}, c.writer)
+	c.writeDone(err)
+	if err != nil {
+		s.readStreams[clientMark].Store(nil)
+		s.releaseClientMark(clientMark)
+		return nil, wsfsprotocol.ErrorIO
+	}
+	return rs, wsfsprotocol.ErrorOK
+}
+
+// deliver passes a response to the request of its client mark.
+func (s *Session) deliver(clientMark uint16, buf *util.Buffer) {
+	if rs := s.readStreams[clientMark].Load(); rs != nil {
+		rs.push(buf)
+		return
+	}
+	s.responses[clientMark] <- buf
+}
+
+func (rs *ReadStream) push(buf *util.Buffer) {
+	rs.lock.Lock()
+	rs.queue = append(rs.queue, buf)
+	rs.lock.Unlock()
+
+	select {
+	case rs.arrived <- struct{}{}:
+	default:
+	}
+}
+
+func (rs *ReadStream) pop() *util.Buffer {
+	for {
+		rs.lock.Lock()
+		if len(rs.queue) > 0 {
+			buf := rs.queue[0]
+			rs.queue[0] = nil
+			rs.queue = rs.queue[1:]
+			rs.lock.Unlock()
+			return buf
+		}
+		rs.lock.Unlock()
+		<-rs.arrived
+	}
+}
+
+// Offset returns the offset of the next byte Read returns.
+func (rs *ReadStream) Offset() uint64 {
+	return rs.offset
+}
+
+// Ended reports whether all the data of the stream was read.
+func (rs *ReadStream) Ended() bool {
+	return rs.ended && rs.current == nil
+}
+
+// Read fills dest, unless the stream ends first. At the end of the file it
+// returns 0 and ErrorOK.
+func (rs *ReadStream) Read(dest []byte) (int, uint8) {
+	var n int
+	for n < len(dest) {
+		if rs.current == nil {
+			if rs.ended {
+				break
+			}
+			if err := rs.ack(); err != nil {
+				rs.offset += uint64(n)
+				return n, wsfsprotocol.ErrorIO
+			}
+			buf := rs.pop()
+			if buf.Bytes[1] != wsfsprotocol.ErrorPartialResponse {
+				rs.ended = true
+				rs.code = buf.Bytes[1]
+				if rs.code != wsfsprotocol.ErrorOK {
+					bufPool.Put(buf)
+					break
+				}
+			}
+			rs.current, rs.pos = buf, 2
+		}
+
+		copied := copy(dest[n:], rs.current.Bytes[rs.pos:rs.current.Written()])
+		n += copied
+		rs.pos += copied
+		rs.unacked += uint32(copied)
+		if rs.pos == rs.current.Written() {
+			bufPool.Put(rs.current)
+			rs.current = nil
+		}
+	}
+	rs.offset += uint64(n)
+
+	if n == 0 && rs.ended && rs.code != wsfsprotocol.ErrorOK {
+		return 0, rs.code
+	}
+	if err := rs.ack(); err != nil {
+		return n, wsfsprotocol.ErrorIO
+	}
+	return n, wsfsprotocol.ErrorOK
+}
+
+// ack gives back the credit of the data read, once it is half the window.
+func (rs *ReadStream) ack() error {
+	if rs.ended || rs.unacked < rs.window/2 {
+		return nil
+	}
+	if err := rs.sendCredit(rs.unacked, false); err != nil {
+		return err
+	}
+	rs.unacked = 0
+	return nil
+}
+
+func (rs *ReadStream) sendCredit(credit uint32, cancel bool) error {
+	s := rs.session
+	c := s.beginRequest(rs.clientMark, wsfsprotocol.CmdReadStreamCredit)
+	if c == nil {
+		return errReadStreamIO
+	}
+	var cancelFlag uint8
+	if cancel {
+		cancelFlag = 1
+	}
+	err := wsfsprotocol.WriteCmdReadStreamCreditStructToWriter(wsfsprotocol.CmdReadStreamCreditStruct{
+		Credit: credit,
+		Cancel: cancelFlag,
+	}, c.writer)
+	c.writeDone(err)
+	return err
+}
+
+// Close cancels the stream, and waits for its final response before the
+// client mark is reused. On a connection failure, the final response is the
+// error of the session.
+func (rs *ReadStream) Close() {
+	if rs.current != nil {
+		bufPool.Put(rs.current)
+		rs.current = nil
+	}
+	if !rs.ended {
+		_ = rs.sendCredit(0, true)
+		for {
+			buf := rs.pop()
+			final := buf.Bytes[1] != wsfsprotocol.ErrorPartialResponse
+			bufPool.Put(buf)
+			if final {
+				break
+			}
+		}
+		rs.ended = true
+	}
+
+	s := rs.session
+	s.readStreams[rs.clientMark].Store(nil)
+	rs.lock.Lock()
+	for _, buf := range rs.queue {
+		bufPool.Put(buf)
+	}
+	rs.queue = nil
+	rs.lock.Unlock()
+	s.releaseClientMark(rs.clientMark)
+}
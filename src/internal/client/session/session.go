@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"io"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,12 +17,11 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-type ReDialFunc func() (*websocket.Conn, error)
+// ReDialFunc connects again to resume the session. retryAfter is non-zero
+// when the server went away and asked to wait before resuming.
+type ReDialFunc func(retryAfter time.Duration) (*websocket.Conn, wsfsprotocol.Capabilities, error)
 
-const (
-	maxFrameSize = wsfsprotocol.MaxMsgSize
-	pingTimeout  = 10 * time.Second
-)
+const pingTimeout = 10 * time.Second
 
 type sessionState uint8
 
@@ -30,52 +32,62 @@ const (
 	sessionStateClosed
 )
 
-var (
-	bufPool = sync.Pool{
-		New: func() any {
-			return util.NewBuffer(maxFrameSize)
-		},
-	}
-)
+var bufPool = util.NewBufferPool(wsfsprotocol.MaxMsgSize, wsfsprotocol.MaxNegotiableMsgSize)
 
 type Session struct {
 	exitErr error
 	exitWg  sync.WaitGroup
 	reDial  ReDialFunc
+	join    JoinFunc
 
 	pingInterval       time.Duration
 	allowedXAttrPrefix []string
 	autoXAttrAppend    bool
 	exitOnce           sync.Once
 
+	// advertised by the server on the last connection
+	caps          atomic.Pointer[wsfsprotocol.Capabilities]
+	xattrPrefixes atomic.Pointer[[]string] // allowed by both sides
+	maxMsgSize    atomic.Int64             // negotiated by the connection
+
 	lifecycleLock sync.Mutex
 	lifecycleCond *sync.Cond
 	state         sessionState
 	activeReqs    int
 
-	conn      *websocket.Conn
-	writer    io.WriteCloser
-	writeLock sync.Mutex
-
-	connCtx       context.Context
-	connCtxCancel context.CancelFunc
-	connErr       error
-	connErrLock   sync.Mutex
-
-	responses [256]chan *util.Buffer
-	lastMark  atomic.Uint32
-	marks     [256]sync.Mutex
+	// running connections by index, the first of which is the one resumed
+	wantConns int
+	conns     [wsfsprotocol.MaxSessionConns]atomic.Pointer[conn]
+	connCount atomic.Int32  // connections wanted and allowed by the server
+	epoch     atomic.Uint64 // bumped on each resume, ending older joins
+	nextBulk  atomic.Uint32
+
+	markSize  atomic.Int32 // bytes of a client mark on the connections
+	marks     markPool
+	markConns [maxClientMarks]atomic.Pointer[conn]
+	responses [maxClientMarks]chan *util.Buffer
+	// responses of the marks with a read stream go to the stream instead
+	readStreams [maxClientMarks]atomic.Pointer[ReadStream]
 }
 
-func NewSession(reDial ReDialFunc, pingInterval time.Duration, allowedXAttrPrefix []string, autoXAttrAppend bool) (*Session, error) {
+// NewSession returns a session running on up to conns connections, if the
+// server allows it; join adds the connections but the first.
+func NewSession(reDial ReDialFunc, join JoinFunc, conns int, pingInterval time.Duration, allowedXAttrPrefix []string, autoXAttrAppend bool) (*Session, error) {
+	if join == nil {
+		conns = 1
+	}
 	s := &Session{
 		reDial:             reDial,
+		join:               join,
+		wantConns:          min(max(conns, 1), wsfsprotocol.MaxSessionConns),
 		pingInterval:       pingInterval,
 		allowedXAttrPrefix: normalizeXAttrPrefixes(allowedXAttrPrefix),
 		autoXAttrAppend:    autoXAttrAppend,
 		state:              sessionStateRunning,
 	}
 	s.lifecycleCond = sync.NewCond(&s.lifecycleLock)
+	s.marks.init()
+	s.markSize.Store(1)
 	for i := range s.responses {
 		s.responses[i] = make(chan *util.Buffer, 1)
 	}
@@ -105,7 +117,7 @@ func (s *Session) exit(err error) {
 	})
 }
 
-func (s *Session) newClientMark() (uint8, bool) {
+func (s *Session) newClientMark() (uint16, bool) {
 	s.lifecycleLock.Lock()
 	if s.state == sessionStateClosing || s.state == sessionStateClosed {
 		s.lifecycleLock.Unlock()
@@ -114,18 +126,12 @@ func (s *Session) newClientMark() (uint8, bool) {
 	s.activeReqs += 1
 	s.lifecycleLock.Unlock()
 
-	var v uint8
-	for {
-		v = uint8(s.lastMark.Add(1))
-		if s.marks[v].TryLock() {
-			break
-		}
-	}
-	return v, true
+	return s.marks.get(), true
 }
 
-func (s *Session) releaseClientMark(clientMark uint8) {
-	s.marks[clientMark].Unlock()
+func (s *Session) releaseClientMark(clientMark uint16) {
+	s.markConns[clientMark].Store(nil)
+	s.marks.put(clientMark)
 
 	s.lifecycleLock.Lock()
 	s.activeReqs -= 1
@@ -133,14 +139,14 @@ func (s *Session) releaseClientMark(clientMark uint8) {
 	s.lifecycleLock.Unlock()
 }
 
-func (s *Session) Start(conn *websocket.Conn) {
+func (s *Session) Start(conn *websocket.Conn, caps wsfsprotocol.Capabilities) {
 	s.exitErr = nil
 	s.exitWg.Add(1)
 	s.lifecycleLock.Lock()
 	s.state = sessionStateRunning
 	s.lifecycleCond.Broadcast()
 	s.lifecycleLock.Unlock()
-	s.takeConn(conn)
+	s.takeConn(conn, caps)
 }
 
 func (s *Session) Wait() error {
@@ -148,42 +154,121 @@ func (s *Session) Wait() error {
 	return s.exitErr
 }
 
-func (s *Session) takeConn(conn *websocket.Conn) {
-	conn.SetReadLimit(int64(maxFrameSize))
-	s.conn = conn
-	s.connCtx, s.connCtxCancel = context.WithCancel(context.Background())
-	go s.readLoop(conn)
-	if s.pingInterval > 0 {
-		go s.pingLoop(conn, s.connCtx)
+// Capabilities returns what the server advertised on the last connection.
+func (s *Session) Capabilities() wsfsprotocol.Capabilities {
+	return *s.caps.Load()
+}
+
+// commandRefused returns the error of a command the server does not
+// advertise, without sending it.
+func (s *Session) commandRefused(cmd uint8) (uint8, bool) {
+	caps := s.caps.Load()
+	if caps.HasCommand(cmd) {
+		return wsfsprotocol.ErrorOK, false
+	}
+	if caps.ReadOnly && slices.Contains(wsfsprotocol.MutatingCommands, cmd) {
+		return wsfsprotocol.ErrorAccessRestricted, true
+	}
+	return wsfsprotocol.ErrorNotSupport, true
+}
+
+func (s *Session) msgSize() int {
+	return int(s.maxMsgSize.Load())
+}
+
+// headerSize is the client mark and command of a request.
+func (s *Session) headerSize() int {
+	return int(s.markSize.Load()) + 1
+}
+
+func (s *Session) setCapabilities(caps wsfsprotocol.Capabilities) {
+	if old := s.caps.Load(); old == nil || !reflect.DeepEqual(*old, caps) {
+		log.Info().
+			Str("ServerVersion", caps.ServerVersion).
+			Int("MaxMsgSize", caps.MaxMsgSize).
+			Int("Commands", len(caps.Commands)).
+			Strs("XAttrPrefix", caps.AllowedXAttrPrefix).
+			Bool("ReadOnly", caps.ReadOnly).
+			Strs("Extensions", caps.Extensions).
+			Msg("Server capabilities")
 	}
+	s.caps.Store(&caps)
+	s.maxMsgSize.Store(int64(caps.MaxMsgSize))
+
+	prefixes := s.allowedXAttrPrefix
+	if caps.Advertised {
+		prefixes = intersectXAttrPrefixes(s.allowedXAttrPrefix, caps.AllowedXAttrPrefix)
+	}
+	s.xattrPrefixes.Store(&prefixes)
 }
 
-func (s *Session) pingLoop(conn *websocket.Conn, ctx context.Context) {
+// intersectXAttrPrefixes returns the prefixes of keys allowed by both lists.
+func intersectXAttrPrefixes(a, b []string) []string {
+	var prefixes []string
+	for _, pa := range a {
+		for _, pb := range b {
+			switch {
+			case strings.HasPrefix(pa, pb):
+				prefixes = append(prefixes, pa)
+			case strings.HasPrefix(pb, pa):
+				prefixes = append(prefixes, pb)
+			}
+		}
+	}
+	return prefixes
+}
+
+// takeConn runs the session on a new or resumed first connection, and joins
+// the others.
+func (s *Session) takeConn(ws *websocket.Conn, caps wsfsprotocol.Capabilities) {
+	s.setCapabilities(caps)
+	markSize := wsfsprotocol.ClientMarkSize(ws.Subprotocol())
+	s.markSize.Store(int32(markSize))
+	if markSize == 1 {
+		s.marks.setLimit(wsfsprotocol.MaxNarrowClientMarks)
+	} else {
+		s.marks.setLimit(maxClientMarks)
+	}
+	count := min(s.wantConns, caps.MaxConns)
+	s.connCount.Store(int32(count))
+	if s.wantConns > count {
+		log.Warn().Int("Want", s.wantConns).Int("Allowed", caps.MaxConns).Msg("Server allows fewer connections")
+	}
+
+	s.lifecycleLock.Lock()
+	epoch := s.epoch.Add(1)
+	s.addConn(0, epoch, ws)
+	s.lifecycleLock.Unlock()
+	for index := 1; index < count; index++ {
+		go s.joinConn(index, epoch)
+	}
+}
+
+func (s *Session) pingLoop(c *conn, ws *websocket.Conn) {
 	ticker := time.NewTicker(s.pingInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ctx.Done():
+		case <-c.ctx.Done():
 			return
 		case <-ticker.C:
 		}
 
-		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
-		err := conn.Ping(pingCtx)
+		pingCtx, cancel := context.WithTimeout(c.ctx, pingTimeout)
+		err := ws.Ping(pingCtx)
 		cancel()
 		if err == nil {
 			continue
 		}
 
-		if s.connErrLock.TryLock() {
-			s.connErr = err
-		}
-		s.connCtxCancel()
+		c.setErr(err)
+		c.ctxCancel()
 		return
 	}
 }
 
+// Close closes the connections once no request is active, the first last.
 func (s *Session) Close() error {
 	s.lifecycleLock.Lock()
 	for {
@@ -200,66 +285,91 @@ func (s *Session) Close() error {
 			}
 			s.state = sessionStateClosing
 			s.lifecycleCond.Broadcast()
-			conn := s.conn
+			conns := s.runningConns()
 			s.lifecycleLock.Unlock()
 
-			if conn == nil {
+			if len(conns) == 0 {
 				s.exit(nil)
 				return s.Wait()
 			}
 
-			if err := conn.Close(websocket.StatusNormalClosure, ""); err != nil {
-				if s.connErrLock.TryLock() {
-					s.connErr = err
-				}
-				s.connCtxCancel()
+			for i := len(conns) - 1; i >= 0; i-- {
+				conns[i].close()
 			}
 			return s.Wait()
 		}
 	}
 }
 
-func (s *Session) stopConn() {
+// stopConn stops a connection whose read loop ended. The requests in flight
+// on it fail, and it is joined again while other connections run; when none
+// is left, the session is closed or resumed.
+func (s *Session) stopConn(c *conn) {
+	c.ctxCancel()
+
+	c.writeLock.Lock()
+	ws := c.ws
+	c.ws = nil
+	c.writeLock.Unlock()
+
 	s.lifecycleLock.Lock()
+	s.conns[c.index].CompareAndSwap(c, nil)
+	last := len(s.runningConns()) == 0
 	gracefulClose := s.state == sessionStateClosing
-	if gracefulClose {
-		s.lifecycleCond.Broadcast()
-	} else if s.state != sessionStateClosed {
-		s.state = sessionStateRecovering
-		s.lifecycleCond.Broadcast()
+	if last {
+		if gracefulClose {
+			s.lifecycleCond.Broadcast()
+		} else if s.state != sessionStateClosed {
+			s.state = sessionStateRecovering
+			s.lifecycleCond.Broadcast()
+			// joins of before would join the resumed session
+			s.epoch.Add(1)
+		}
 	}
 	s.lifecycleLock.Unlock()
 
-	s.connCtxCancel()
-
-	s.writeLock.Lock()
-	conn := s.conn
-	s.conn = nil
-	s.writeLock.Unlock()
-	if conn != nil && !gracefulClose {
-		_ = conn.CloseNow()
+	if ws != nil && !gracefulClose {
+		_ = ws.CloseNow()
 	}
 
-	if cs := websocket.CloseStatus(s.connErr); cs != -1 {
-		log.Info().Int("CloseStatus", int(cs)).Msg("Disconnected")
+	connErr := c.loadErr()
+	if cs := websocket.CloseStatus(connErr); cs != -1 {
+		log.Info().Int("Conn", c.index).Int("CloseStatus", int(cs)).Msg("Disconnected")
 	} else {
-		log.Error().Err(s.connErr).Msg("Failed to read/write message")
+		log.Error().Int("Conn", c.index).Err(connErr).Msg("Failed to read/write message")
+	}
+
+	if !last {
+		if gracefulClose {
+			return
+		}
+		s.notifyConnMarks(c, "Connection lost")
+		go s.joinConn(c.index, c.epoch)
+		return
+	}
+
+	// a server going away asks to wait before resuming
+	var retryAfter time.Duration
+	var closeErr websocket.CloseError
+	if errors.As(connErr, &closeErr) && closeErr.Code == websocket.StatusGoingAway {
+		if d, ok := wsfsprotocol.ParseGoingAwayReason(closeErr.Reason); ok {
+			log.Warn().Str("RetryAfter", d.String()).Msg("Server going away")
+			retryAfter = d
+		}
 	}
-	s.connErrLock.Unlock()
 
 	if gracefulClose {
 		s.notifyAllMarksClosed()
-		if websocket.CloseStatus(s.connErr) == websocket.StatusNormalClosure || websocket.CloseStatus(s.connErr) == websocket.StatusGoingAway {
+		if websocket.CloseStatus(connErr) == websocket.StatusNormalClosure || websocket.CloseStatus(connErr) == websocket.StatusGoingAway {
 			s.exit(nil)
 		} else {
-			s.exit(s.connErr)
+			s.exit(connErr)
 		}
 		return
 	}
 
 	s.notifyAllMarksError()
-
-	s.errorMode()
+	s.errorMode(retryAfter)
 }
 
 func (s *Session) notifyAllMarksError() {
@@ -271,113 +381,129 @@ func (s *Session) notifyAllMarksClosed() {
 }
 
 func (s *Session) notifyAllMarksWithDesc(desc string) {
-	if len(desc) > wsfsprotocol.MaxErrorDescLength {
-		desc = desc[:wsfsprotocol.MaxErrorDescLength]
+	for _, mark := range s.marks.inUse() {
+		s.notifyMarkWithDesc(mark, desc)
 	}
-	for i := range 256 {
-		if s.marks[i].TryLock() {
-			s.marks[i].Unlock()
-			continue
+}
+
+// notifyConnMarks fails the requests in flight on a connection.
+func (s *Session) notifyConnMarks(c *conn, desc string) {
+	for _, mark := range s.marks.inUse() {
+		if s.markConns[mark].Load() == c {
+			s.notifyMarkWithDesc(mark, desc)
 		}
+	}
+}
 
-		buf := bufPool.Get().(*util.Buffer)
+func (s *Session) notifyMarkWithDesc(mark uint16, desc string) {
+	if len(desc) > wsfsprotocol.MaxErrorDescLength {
+		desc = desc[:wsfsprotocol.MaxErrorDescLength]
+	}
+	buf := bufPool.Get(wsfsprotocol.MaxResponseLength)
+	buf.Write([]byte{uint8(mark), wsfsprotocol.ErrorIO})
+	if err := wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: desc}, buf); err != nil {
 		buf.Reset()
-		buf.Write([]byte{uint8(i), wsfsprotocol.ErrorIO})
-		if err := wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: desc}, buf); err != nil {
-			buf.Reset()
-			buf.Write([]byte{uint8(i), wsfsprotocol.ErrorIO})
-			_ = wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: "bad synthetic error response"}, buf)
-		}
-		s.responses[i] <- buf
+		buf.Write([]byte{uint8(mark), wsfsprotocol.ErrorIO})
+		_ = wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: "bad synthetic error response"}, buf)
 	}
+	s.deliver(mark, buf)
 }
 
-func (s *Session) requireWrite() (ok bool) {
-	s.writeLock.Lock()
+// requireWrite opens the message of a request of clientMark on its
+// connection. The mark must fit in the client marks of the connection; a
+// mark taken before the session resumed with 1 byte marks may not.
+func (s *Session) requireWrite(clientMark uint16, cmd uint8) *conn {
+	if s.markSize.Load() == 1 && clientMark >= wsfsprotocol.MaxNarrowClientMarks {
+		return nil
+	}
+	c := s.connOf(clientMark, cmd)
+	if c == nil {
+		return nil
+	}
 
-	if s.conn == nil {
-		s.writeLock.Unlock()
-		return false
+	c.writeLock.Lock()
+	if c.ws == nil {
+		c.writeLock.Unlock()
+		return nil
 	}
 
 	var err error
-	s.writer, err = s.conn.Writer(s.connCtx, websocket.MessageBinary)
+	c.writer, err = c.ws.Writer(c.ctx, websocket.MessageBinary)
 	if err != nil {
-		if s.connErrLock.TryLock() {
-			s.connErr = err
-		}
-		s.conn = nil
-		s.connCtxCancel()
-		s.writeLock.Unlock()
-		return false
+		c.setErr(err)
+		c.ws = nil
+		c.ctxCancel()
+		c.writeLock.Unlock()
+		return nil
 	}
 
-	return true
+	return c
 }
 
-func (s *Session) writeDone(err error) {
-	if s.writer != nil {
-		closeErr := s.writer.Close()
-		if err == nil {
-			err = closeErr
-		}
-		s.writer = nil
-	}
-	if err != nil {
-		if s.connErrLock.TryLock() {
-			s.connErr = err
-		}
-		s.conn = nil
-		s.connCtxCancel()
+// beginRequest opens a request, whose body is written to the writer of the
+// returned connection before calling its writeDone. It returns nil if no
+// connection can take it.
+func (s *Session) beginRequest(clientMark uint16, cmd uint8) *conn {
+	c := s.requireWrite(clientMark, cmd)
+	if c == nil {
+		return nil
 	}
-	s.writeLock.Unlock()
-}
-
-func (s *Session) beginRequest(clientMark uint8, cmd uint8) (ok bool) {
-	if !s.requireWrite() {
-		return false
+	var err error
+	if s.markSize.Load() == 1 {
+		_, err = c.writer.Write([]byte{uint8(clientMark), cmd})
+	} else {
+		_, err = c.writer.Write([]byte{uint8(clientMark), uint8(clientMark >> 8), cmd})
 	}
-	_, err := s.writer.Write([]byte{clientMark, cmd})
 	if err != nil {
-		s.writeDone(err)
-		return false
+		c.writeDone(err)
+		return nil
 	}
-	return true
+	return c
 }
 
-func (s *Session) readLoop(conn *websocket.Conn) {
+func (s *Session) readLoop(c *conn, ws *websocket.Conn) {
 	defer func() {
 		if err := util.RecoverValue(recover()); err != nil {
 			log.Error().Err(err).Msg("Read loop panic")
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 		}
-		s.stopConn()
+		s.stopConn(c)
 	}()
 
+	markSize := int(s.markSize.Load())
 	for {
-		msgType, reader, err := conn.Reader(s.connCtx)
+		msgType, reader, err := ws.Reader(c.ctx)
 
 		if err != nil {
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 			return
 		}
 		if msgType != websocket.MessageBinary {
 			log.Warn().Msg("Message type is not binary")
 		}
 
-		buf := bufPool.Get().(*util.Buffer)
-		buf.Reset()
+		// a response is queued with the low byte of its client mark, so
+		// that its error code is at 1 whatever the size of the marks
+		var mark [2]byte
+		_, err = io.ReadFull(reader, mark[:markSize])
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Error().Msg("Bad message, too short")
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read message")
+			c.setErr(err)
+			return
+		}
+		clientMark := uint16(mark[0]) | uint16(mark[1])<<8
+
+		buf := bufPool.Get(s.msgSize())
+		buf.Write(mark[:1])
 		_, err = io.Copy(buf, reader)
 		if err != nil {
 			bufPool.Put(buf)
 			log.Error().Err(err).Msg("Failed to read message")
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 			return
 		}
 
@@ -388,12 +514,16 @@ func (s *Session) readLoop(conn *websocket.Conn) {
 		}
 		//log.Debug().Uint8("Cm", buf.Bytes[0]).Uint8("Ec", buf.Bytes[1]).Msg("Recived response")
 
-		clientMark := buf.Bytes[0]
-		s.responses[clientMark] <- buf
+		if clientMark >= maxClientMarks {
+			bufPool.Put(buf)
+			log.Error().Uint16("ClientMark", clientMark).Msg("Bad message, unknown client mark")
+			continue
+		}
+		s.deliver(clientMark, buf)
 	}
 }
 
-func (s *Session) errorMode() {
+func (s *Session) errorMode(retryAfter time.Duration) {
 	log.Warn().Msg("Error mode activated")
 
 	if s.reDial == nil {
@@ -403,14 +533,14 @@ func (s *Session) errorMode() {
 	}
 
 	log.Info().Msg("Try recovery session")
-	conn, err := s.reDial()
+	conn, caps, err := s.reDial(retryAfter)
 	if err != nil {
 		s.exit(err)
 		return
 	}
 	log.Info().Msg("Reconnected to server")
 
-	s.takeConn(conn)
+	s.takeConn(conn, caps)
 	s.lifecycleLock.Lock()
 	if s.state == sessionStateRecovering {
 		s.state = sessionStateRunning
@@ -8,14 +8,17 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const (
-	maxWriteStreamOpenPayload int = maxFrameSize - 14 // header(2) + FD(4) + Offset(8)
-	maxWriteStreamDataPayload int = maxFrameSize - 3  // header(2) + IsEnd(1)
-)
+func (s *Session) maxWriteStreamOpenPayload() int {
+	return s.msgSize() - s.headerSize() - 12 // FD(4) + Offset(8)
+}
+
+func (s *Session) maxWriteStreamDataPayload() int {
+	return s.msgSize() - s.headerSize() - 1 // IsEnd(1)
+}
 
 type WriteStream struct {
 	session      *Session
-	clientMark   uint8
+	clientMark   uint16
 	writeErrCode uint8
 	writeErrDesc string
 	closed       bool
@@ -33,12 +36,14 @@ func (s *Session) OpenWriteStream(fd uint32, offset uint64, first []byte) (*Writ
 
 	firstChunk := first
 	rest := []byte(nil)
+	maxWriteStreamOpenPayload := s.maxWriteStreamOpenPayload()
 	if len(first) > maxWriteStreamOpenPayload {
 		firstChunk = first[:maxWriteStreamOpenPayload]
 		rest = first[maxWriteStreamOpenPayload:]
 	}
 
-	if !s.beginRequest(clientMark, wsfsprotocol.CmdWriteStreamOpen) {
+	c := s.beginRequest(clientMark, wsfsprotocol.CmdWriteStreamOpen)
+	if c == nil {
 		s.releaseClientMark(clientMark)
 		return nil, errWriteStreamIO
 	}
@@ -46,8 +51,8 @@ func (s *Session) OpenWriteStream(fd uint32, offset uint64, first []byte) (*Writ
 		FD:     fd,
 		Offset: offset,
 		Data:   firstChunk,
-	}, s.writer)
-	s.writeDone(err)
+	}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		s.releaseClientMark(clientMark)
 		return nil, errWriteStreamIO
@@ -69,20 +74,22 @@ func (ws *WriteStream) Write(data []byte) error {
 		return errWriteStreamClosed
 	}
 
+	maxWriteStreamDataPayload := ws.session.maxWriteStreamDataPayload()
 	for len(data) > 0 {
 		chunk := data
 		if len(chunk) > maxWriteStreamDataPayload {
 			chunk = data[:maxWriteStreamDataPayload]
 		}
 
-		if !ws.session.beginRequest(ws.clientMark, wsfsprotocol.CmdWriteStreamData) {
+		c := ws.session.beginRequest(ws.clientMark, wsfsprotocol.CmdWriteStreamData)
+		if c == nil {
 			return errWriteStreamIO
 		}
 		err := wsfsprotocol.WriteCmdWriteStreamDataStructToWriter(wsfsprotocol.CmdWriteStreamDataStruct{
 			Data:  chunk,
 			IsEnd: 0,
-		}, ws.session.writer)
-		ws.session.writeDone(err)
+		}, c.writer)
+		c.writeDone(err)
 		if err != nil {
 			return errWriteStreamIO
 		}
@@ -102,6 +109,7 @@ func (ws *WriteStream) Close(last []byte) (written uint64, code uint8, desc stri
 		return 0, wsfsprotocol.ErrorInvalid, "write stream already closed"
 	}
 
+	maxWriteStreamDataPayload := ws.session.maxWriteStreamDataPayload()
 	if len(last) > maxWriteStreamDataPayload {
 		if err := ws.Write(last[:len(last)-maxWriteStreamDataPayload]); err != nil {
 			ws.closed = true
@@ -112,15 +120,16 @@ func (ws *WriteStream) Close(last []byte) (written uint64, code uint8, desc stri
 	}
 	ws.closed = true
 
-	if !ws.session.beginRequest(ws.clientMark, wsfsprotocol.CmdWriteStreamData) {
+	c := ws.session.beginRequest(ws.clientMark, wsfsprotocol.CmdWriteStreamData)
+	if c == nil {
 		ws.session.releaseClientMark(ws.clientMark)
 		return 0, wsfsprotocol.ErrorUnknown, "session error mode"
 	}
 	err := wsfsprotocol.WriteCmdWriteStreamDataStructToWriter(wsfsprotocol.CmdWriteStreamDataStruct{
 		Data:  last,
 		IsEnd: 1,
-	}, ws.session.writer)
-	ws.session.writeDone(err)
+	}, c.writer)
+	c.writeDone(err)
 	if err != nil {
 		ws.session.releaseClientMark(ws.clientMark)
 		return 0, wsfsprotocol.ErrorUnknown, err.Error()
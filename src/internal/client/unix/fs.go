@@ -4,6 +4,7 @@ package unix
 
 import (
 	"hash/maphash"
+	"sync"
 	"time"
 	"wsfs-core/internal/client/session"
 	"wsfs-core/internal/util"
@@ -25,6 +26,8 @@ type fileSystem struct {
 
 	structTimeout   time.Duration
 	negativeTimeout time.Duration
+
+	seqReaders sync.Map // fd to *seqReader
 }
 
 func NewFS(sesseion *session.Session, fsIds util.FsIds,
@@ -293,15 +293,17 @@ func (n *fsNode) Create(ctx context.Context, name string, flags uint32, mode uin
 		return nil, nil, 0, syscall.ENOTSUP
 	}
 
-	fd, code := n.fsdata.session.CmdOpen(p, wsfsFlag|wsfsprotocol.O_CREAT, mode)
+	fd, fi, code := n.fsdata.session.CmdOpenGetAttr(p, wsfsFlag|wsfsprotocol.O_CREAT, mode)
 	if code != wsfsprotocol.ErrorOK {
 		return nil, nil, 0, errnoFromCode(code)
 	}
+	attrFromFileInfo(p, &out.Attr, &fi, &n.fsdata.fsIds)
 
-	in, err := n.Lookup(ctx, name, out)
-	if err != syscall.Errno(0) {
+	in := n.NewInode(ctx, n.fsdata.NewNode(), idFromStat(&out.Attr))
+	if in == nil {
+		log.Error().Str("Path", p).Msg("NewInode returned nil in Create")
 		n.fsdata.session.CmdClose(fd)
-		return nil, nil, 0, err
+		return nil, nil, 0, syscall.EIO
 	}
 
 	return in, fd, 0, 0
@@ -426,6 +428,9 @@ func (n *fsNode) Read(_ context.Context, f fusefs.FileHandle, dest []byte, off i
 		return fuse.ReadResultData(data[off:]), fusefs.OK
 	}
 
+	if readed, ok := n.fsdata.readSequential(f.(uint32), dest, off); ok {
+		return fuse.ReadResultData(dest[:readed]), fusefs.OK
+	}
 	readed, code := n.fsdata.session.CmdReadAt(f.(uint32), uint64(off), dest)
 	if code != wsfsprotocol.ErrorOK {
 		return nil, errnoFromCode(code)
@@ -442,6 +447,7 @@ func (n *fsNode) Write(_ context.Context, f fusefs.FileHandle, data []byte, off
 	}
 	wipeDataCache(&n.dataCache)
 	wipeAttrCache(&n.attrCache)
+	n.fsdata.resetSeqReader(f.(uint32))
 
 	count, code := n.fsdata.session.CmdWriteAt(f.(uint32), uint64(off), data)
 	if code != wsfsprotocol.ErrorOK {
@@ -466,6 +472,7 @@ func (n *fsNode) Release(_ context.Context, f fusefs.FileHandle) syscall.Errno {
 		return fusefs.OK
 	}
 
+	n.fsdata.dropSeqReader(f.(uint32))
 	_ = n.fsdata.session.CmdClose(f.(uint32)) // ignore error
 	return fusefs.OK
 }
@@ -559,6 +566,7 @@ func (n *fsNode) Setattr(ctx context.Context, f fusefs.FileHandle, in *fuse.SetA
 
 	var code uint8
 	if fd, ok := f.(uint32); ok {
+		n.fsdata.resetSeqReader(fd)
 		code = n.fsdata.session.CmdSetAttrByFD(fd, flag, fi)
 	} else {
 		code = n.fsdata.session.CmdSetAttr(n.path(), flag, fi)
@@ -0,0 +1,108 @@
+//go:build unix
+
+package unix
+
+import (
+	"sync"
+	"wsfs-core/internal/client/session"
+	"wsfs-core/internal/share/wsfsprotocol"
+)
+
+const (
+	// reads in a row at the offset following the previous one before a
+	// read stream is opened, and reads elsewhere before it is closed
+	seqReadThreshold = 2
+	seqReadWindow    = 4 << 20 // bytes
+)
+
+// seqReader detects the sequential reads of a file handle, and serves them
+// from a read stream pushed by the server instead of one request each.
+type seqReader struct {
+	lock   sync.Mutex
+	next   int64 // offset following the last read
+	hits   int   // sequential reads in a row
+	misses int   // reads beside the open stream in a row
+	stream *session.ReadStream
+}
+
+func (r *fileSystem) seqReader(fd uint32) *seqReader {
+	v, _ := r.seqReaders.LoadOrStore(fd, &seqReader{})
+	return v.(*seqReader)
+}
+
+// readSequential reads from the read stream of fd if the reads are
+// sequential. It returns false if the read must be done on its own.
+func (r *fileSystem) readSequential(fd uint32, dest []byte, off int64) (int, bool) {
+	sr := r.seqReader(fd)
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+
+	if sr.stream != nil && uint64(off) != sr.stream.Offset() {
+		sr.misses++
+		if sr.misses >= seqReadThreshold {
+			sr.closeStream()
+		}
+		sr.next = off + int64(len(dest))
+		return 0, false
+	}
+	sr.misses = 0
+
+	if sr.stream == nil {
+		if off == sr.next {
+			sr.hits++
+		} else {
+			sr.hits = 0
+		}
+		sr.next = off + int64(len(dest))
+		if sr.hits < seqReadThreshold {
+			return 0, false
+		}
+		stream, code := r.session.OpenReadStream(fd, uint64(off), seqReadWindow)
+		if code != wsfsprotocol.ErrorOK {
+			sr.hits = 0
+			return 0, false
+		}
+		sr.stream = stream
+	}
+
+	n, code := sr.stream.Read(dest)
+	if code != wsfsprotocol.ErrorOK {
+		sr.closeStream()
+		return 0, false
+	}
+	sr.next = off + int64(n)
+	if sr.stream.Ended() {
+		// the file may grow, read it again on its own
+		sr.closeStream()
+	}
+	return n, true
+}
+
+func (sr *seqReader) closeStream() {
+	if sr.stream != nil {
+		sr.stream.Close()
+		sr.stream = nil
+	}
+	sr.hits = 0
+	sr.misses = 0
+}
+
+// resetSeqReader closes the read stream of fd, whose data may be stale
+// after a change through the handle.
+func (r *fileSystem) resetSeqReader(fd uint32) {
+	if v, ok := r.seqReaders.Load(fd); ok {
+		sr := v.(*seqReader)
+		sr.lock.Lock()
+		sr.closeStream()
+		sr.lock.Unlock()
+	}
+}
+
+func (r *fileSystem) dropSeqReader(fd uint32) {
+	if v, ok := r.seqReaders.LoadAndDelete(fd); ok {
+		sr := v.(*seqReader)
+		sr.lock.Lock()
+		sr.closeStream()
+		sr.lock.Unlock()
+	}
+}
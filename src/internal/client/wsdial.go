@@ -2,10 +2,7 @@ package client
 
 import (
 	"context"
-	"crypto/sha256"
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -13,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
 
 	"github.com/coder/websocket"
 	"github.com/rs/zerolog/log"
@@ -27,11 +25,6 @@ func (e *certHashMismatchError) Error() string {
 	return fmt.Sprintf("unmatched cert hash: expected %s, got %s", e.Expected, e.Actual)
 }
 
-func x509CertHash(cert *x509.Certificate) string {
-	hash := sha256.Sum256(cert.Raw)
-	return "SHA256:" + hex.EncodeToString(hash[:])
-}
-
 func unixSocketUrl(urlStr string) (isSocket bool, socketPath, httpUrl string, err error) {
 	parsedUrl, err := url.Parse(urlStr)
 	if err != nil {
@@ -84,7 +77,7 @@ func tlsConfig(expectedCertHash string) *tls.Config {
 		InsecureSkipVerify: true,
 		VerifyConnection: func(cs tls.ConnectionState) error {
 			if len(cs.PeerCertificates) != 0 {
-				actualHash := x509CertHash(cs.PeerCertificates[0])
+				actualHash := util.X509CertHash(cs.PeerCertificates[0])
 				if actualHash == expectedCertHash {
 					return nil
 				}
@@ -97,13 +90,13 @@ func tlsConfig(expectedCertHash string) *tls.Config {
 
 func logServerCertHash(rsp *http.Response, err error) {
 	if rsp != nil && rsp.TLS != nil && len(rsp.TLS.PeerCertificates) != 0 {
-		log.Warn().Str("Hash", x509CertHash(rsp.TLS.PeerCertificates[0])).Msg("Server cert received")
+		log.Warn().Str("Hash", util.X509CertHash(rsp.TLS.PeerCertificates[0])).Msg("Server cert received")
 		return
 	}
 
 	var verificationErr *tls.CertificateVerificationError
 	if errors.As(err, &verificationErr) && len(verificationErr.UnverifiedCertificates) != 0 {
-		log.Warn().Str("Hash", x509CertHash(verificationErr.UnverifiedCertificates[0])).Msg("Server cert received")
+		log.Warn().Str("Hash", util.X509CertHash(verificationErr.UnverifiedCertificates[0])).Msg("Server cert received")
 		return
 	}
 
@@ -113,7 +106,13 @@ func logServerCertHash(rsp *http.Response, err error) {
 	}
 }
 
-func wsdial(urlStr string, requestHeader http.Header, expectedCertHash string) (*websocket.Conn, *http.Response, error) {
+// compression is the permessage-deflate offer of a connection.
+type compression struct {
+	enable    bool
+	threshold int // bytes; smaller messages are sent uncompressed
+}
+
+func wsdial(urlStr string, requestHeader http.Header, expectedCertHash string, compress compression) (*websocket.Conn, *http.Response, error) {
 	isSocket, socketPath, httpUrl, err := unixSocketUrl(urlStr)
 	if err != nil {
 		return nil, nil, err
@@ -129,7 +128,12 @@ func wsdial(urlStr string, requestHeader http.Header, expectedCertHash string) (
 	transport.TLSClientConfig = tlsConfig(expectedCertHash)
 
 	dialOpt := httpDialOptions(&http.Client{Transport: transport}, requestHeader)
-	dialOpt.Subprotocols = []string{wsfsprotocol.WSSubprotocol}
+	dialOpt.Subprotocols = wsfsprotocol.WSSubprotocols
+	if compress.enable {
+		// the server may still ask for no context takeover
+		dialOpt.CompressionMode = websocket.CompressionContextTakeover
+		dialOpt.CompressionThreshold = compress.threshold
+	}
 
 	conn, rsp, err := websocket.Dial(context.Background(), httpUrl, dialOpt)
 	logServerCertHash(rsp, err)
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"wsfs-core/internal/cmd/control"
 	"wsfs-core/internal/cmd/exit"
 	"wsfs-core/internal/cmd/hash"
 	quickserve "wsfs-core/internal/cmd/quick-serve"
@@ -28,7 +29,9 @@ func ExitCode(err error) int {
 
 func init() {
 	rootCmd.AddCommand(serve.ServeCmd)
+	rootCmd.AddCommand(serve.CheckConfigCmd)
 	rootCmd.AddCommand(quickserve.QuickServeCmd)
 	rootCmd.AddCommand(version.VersionCmd)
 	rootCmd.AddCommand(hash.HashCmd)
+	rootCmd.AddCommand(control.ControlCmd)
 }
@@ -0,0 +1,127 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	cmdexit "wsfs-core/internal/cmd/exit"
+	"wsfs-core/internal/server/control"
+	"wsfs-core/internal/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	socketPath string
+	jsonOutput bool
+
+	traceUser     string
+	traceSession  string
+	traceDuration time.Duration
+)
+
+var ControlCmd = &cobra.Command{
+	Use:   "control <reload|status|sessions|trace|drain|shutdown>",
+	Short: "Control a running server through its control socket",
+	Long: `Control a running server through its control socket
+  reload    reload the config and report the result
+  status    print the server status
+  sessions  list WSFS sessions
+  trace     log the commands of a session (--session) or of the sessions
+            of a user (--user) to the trace file, for a while (--duration)
+  drain     stop accepting connections, keeping established WSFS connections
+  shutdown  shut the server down`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{control.CommandReload, control.CommandStatus, control.CommandSessions, control.CommandTrace, control.CommandDrain, control.CommandShutdown},
+	RunE: func(_ *cobra.Command, args []string) error {
+		req := control.Request{Command: args[0]}
+		if args[0] == control.CommandTrace {
+			req.Trace = &control.TraceRequest{
+				Username: traceUser,
+				Id:       traceSession,
+				Duration: int(traceDuration / time.Second),
+			}
+		}
+		rsp, err := control.CallRequest(socketPath, req)
+		if err != nil {
+			return cmdexit.New(1, fmt.Errorf("%s failed: %w", args[0], err))
+		}
+
+		if jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return cmdexit.New(2, enc.Encode(rsp))
+		}
+		if rsp.Status != nil {
+			printStatus(rsp.Status)
+		}
+		if args[0] == control.CommandSessions {
+			printSessions(rsp.Sessions)
+		}
+		if rsp.Trace != nil {
+			fmt.Printf("Tracing %d running sessions until %s\n", rsp.Trace.Sessions, rsp.Trace.Until.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+func printStatus(status *control.Status) {
+	table := util.NewTable()
+	table.AddRow("Pid:", status.Pid)
+	table.AddRow("Version:", status.Version)
+	table.AddRow("Started:", status.StartTime.Format(time.RFC3339))
+	table.AddRow("Draining:", status.Draining)
+	table.AddRow("Handed off:", status.HandedOff)
+	table.AddRow("Sessions:", status.Sessions)
+	if len(status.VirtualHosts) != 0 {
+		table.AddRow("Virtual hosts:", strings.Join(status.VirtualHosts, ", "))
+	}
+	table.Print(os.Stdout)
+
+	fmt.Printf("Listeners:\n")
+	table = util.NewTable().WithLeftPadding(2)
+	for _, l := range status.Listeners {
+		var flags []string
+		if l.TLS {
+			flags = append(flags, "tls")
+		}
+		if len(l.Frontends) != 0 {
+			flags = append(flags, strings.Join(l.Frontends, ","))
+		}
+		table.AddRow(l.Network, l.Address, strings.Join(flags, " "))
+	}
+	table.Print(os.Stdout)
+}
+
+func printSessions(sessions []control.Session) {
+	if len(sessions) == 0 {
+		fmt.Printf("No session\n")
+		return
+	}
+
+	table := util.NewTable()
+	table.AddRow("ID", "USER", "VHOST", "STATE", "FILES", "FROM")
+	for _, s := range sessions {
+		state := "hibernated"
+		if s.Connected {
+			state = "connected"
+		}
+		vhost := s.VirtualHost
+		if vhost == "" {
+			vhost = "-"
+		}
+		table.AddRow(s.Id, s.Username, vhost, state, s.OpenFiles, s.RemoteAddr)
+	}
+	table.Print(os.Stdout)
+}
+
+func init() {
+	ControlCmd.Flags().StringVarP(&socketPath, "socket", "s", "", "Path to the control socket of the server")
+	ControlCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the response as JSON")
+	ControlCmd.Flags().StringVar(&traceUser, "user", "", "Trace the sessions of this user")
+	ControlCmd.Flags().StringVar(&traceSession, "session", "", "Trace the session of this id")
+	ControlCmd.Flags().DurationVar(&traceDuration, "duration", 0, "How long the trace lasts; 0 means the Trace.Duration of the config")
+	_ = ControlCmd.MarkFlagRequired("socket")
+}
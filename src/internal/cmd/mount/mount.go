@@ -15,6 +15,7 @@ import (
 	cmdexit "wsfs-core/internal/cmd/exit"
 	cmdflags "wsfs-core/internal/cmd/flags"
 	cmdpassword "wsfs-core/internal/cmd/password"
+	"wsfs-core/internal/share/wsfsprotocol"
 	"wsfs-core/internal/util"
 	"wsfs-core/version"
 
@@ -42,6 +43,9 @@ var (
 	flockMode          clientSession.FlockMode
 	xattrPrefixes      []string
 	disableXAttrAppend bool
+	compress           bool
+	compressThreshold  int
+	conns              int
 )
 
 var MountCmd = &cobra.Command{
@@ -55,6 +59,12 @@ var MountCmd = &cobra.Command{
 		if pingInterval != 0 && pingInterval < 10 {
 			return cmdexit.New(2, errors.New("bad ping interval: must be 0 or at least 10 seconds"))
 		}
+		if compressThreshold < 0 {
+			return cmdexit.New(2, errors.New("bad compress threshold: can not be negative"))
+		}
+		if conns < 1 || conns > wsfsprotocol.MaxSessionConns {
+			return cmdexit.New(2, fmt.Errorf("bad conns: must be from 1 to %d", wsfsprotocol.MaxSessionConns))
+		}
 
 		fsIds, err := resolveFsIds(c)
 		if err != nil {
@@ -116,6 +126,9 @@ var MountCmd = &cobra.Command{
 			FlockMode:          flockMode,
 			AllowedXAttrPrefix: xattrPrefixes,
 			DisableXAttrAppend: disableXAttrAppend,
+			Compress:           compress,
+			CompressThreshold:  compressThreshold,
+			Conns:              conns,
 		}
 		if logLevel == zerolog.TraceLevel {
 			opts.EnableFuseLog = true
@@ -164,6 +177,9 @@ func init() {
 	cmdflags.AddPasswordFlag(MountCmd.Flags(), &passwordSource)
 	MountCmd.Flags().StringArrayVar(&xattrPrefixes, "xattr-prefix", nil, "Allow xattr names with this prefix; may be repeated")
 	MountCmd.Flags().BoolVar(&disableXAttrAppend, "disable-xattr-append", false, "Return ERANGE instead of splitting oversized xattr writes")
+	MountCmd.Flags().BoolVar(&compress, "compress", false, "Compress messages with permessage-deflate, if the server allows it")
+	MountCmd.Flags().IntVar(&compressThreshold, "compress-threshold", 512, "Messages smaller than this many bytes are sent uncompressed")
+	MountCmd.Flags().IntVar(&conns, "conns", 1, "WebSocket connections of the session, if the server allows them; bulk data is spread over them")
 	MountCmd.Flags().VarP(
 		enumflag.New(&flockMode, "MODE", map[clientSession.FlockMode][]string{
 			clientSession.FlockModeOFD:         {"ofd"},
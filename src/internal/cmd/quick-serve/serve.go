@@ -1,10 +1,13 @@
 package quickserve
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -34,6 +37,8 @@ var (
 	noLogColor     bool
 	jsonLog        bool
 	passwordSource string
+	tlsSelfSigned  bool
+	tlsPersistDir  string
 	logLevel       zerolog.Level = zerolog.InfoLevel
 )
 
@@ -72,12 +77,13 @@ func configIDs(config *serverConfig.Server, c *cobra.Command) {
 
 func parseArg(config *serverConfig.Server, c *cobra.Command, args string) error {
 	arg := strings.TrimSpace(args)
+	listener := &config.Listeners[0]
 
 	if _, err := strconv.ParseUint(arg, 10, 16); err == nil {
 		if c.Flags().Changed("password") {
 			return fmt.Errorf("resolve password failed: %w", cmdpassword.ErrMissingUsername)
 		}
-		config.Listener.Address = ":" + arg
+		listener.Address = ":" + arg
 	} else {
 		if ok, _ := regexp.MatchString(`.*:?\/\/`, arg); !ok {
 			arg = "//" + arg
@@ -90,9 +96,9 @@ func parseArg(config *serverConfig.Server, c *cobra.Command, args string) error
 
 		switch strings.ToLower(parsedUrl.Scheme) {
 		case "http", "wsfs", "tcp", "":
-			config.Listener.Network = "tcp"
+			listener.Network = "tcp"
 		case "unix":
-			config.Listener.Network = "unix"
+			listener.Network = "unix"
 		default:
 			return fmt.Errorf("unsupported listen network: %q", parsedUrl.Scheme)
 		}
@@ -120,7 +126,7 @@ func parseArg(config *serverConfig.Server, c *cobra.Command, args string) error
 		}
 
 		if parsedUrl.Scheme == "unix" {
-			config.Listener.Address = parsedUrl.Path
+			listener.Address = parsedUrl.Path
 		} else {
 			hostname := parsedUrl.Hostname()
 			if strings.Contains(hostname, ":") {
@@ -132,12 +138,64 @@ func parseArg(config *serverConfig.Server, c *cobra.Command, args string) error
 			} else {
 				hostname += ":20001"
 			}
-			config.Listener.Address = hostname
+			listener.Address = hostname
 		}
 	}
 	return nil
 }
 
+func configSelfSigned(config *serverConfig.Server, c *cobra.Command) error {
+	if !tlsSelfSigned {
+		if c.Flags().Changed("tls-self-signed-dir") {
+			return errors.New("--tls-self-signed-dir needs --tls-self-signed")
+		}
+		return nil
+	}
+
+	listener := &config.Listeners[0]
+	listener.TLS = serverConfig.TLS{Enable: true, SelfSigned: true}
+	if tlsPersistDir != "" {
+		if err := os.MkdirAll(tlsPersistDir, 0700); err != nil {
+			return fmt.Errorf("unable to create self-signed key pair dir: %w", err)
+		}
+		listener.TLS.CertFile = filepath.Join(tlsPersistDir, "cert.pem")
+		listener.TLS.KeyFile = filepath.Join(tlsPersistDir, "key.pem")
+	}
+
+	hash, err := server.SelfSignedCertHash(*listener)
+	if err != nil {
+		return fmt.Errorf("unable to prepare self-signed key pair: %w", err)
+	}
+	fmt.Fprintln(os.Stdout, "Certificate hash: "+hash)
+	fmt.Fprintln(os.Stdout, "Mount with: wsfs mount --cert-hash "+hash+" "+mountURL(config)+" /path/to/mountpoint")
+	return nil
+}
+
+// mountURL guesses the URL that a client on another host uses.
+func mountURL(config *serverConfig.Server) string {
+	listener := config.Listeners[0]
+	userinfo := ""
+	if len(config.Users) != 0 {
+		userinfo = url.User(config.Users[0].Name).String() + "@"
+	}
+
+	if listener.Network == "unix" {
+		return "wsfss+unix://" + userinfo + "localhost" + listener.Address + "/./"
+	}
+
+	host, port, err := net.SplitHostPort(listener.Address)
+	if err != nil {
+		return "wsfss://" + userinfo + listener.Address + "/"
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+		if hostname, err := os.Hostname(); err == nil {
+			host = hostname
+		}
+	}
+	return "wsfss://" + userinfo + net.JoinHostPort(host, port) + "/"
+}
+
 var QuickServeCmd = &cobra.Command{
 	Use:   "quick-serve [address]",
 	Short: "Serve a Websocket Filesystem in just one command",
@@ -145,12 +203,14 @@ var QuickServeCmd = &cobra.Command{
   wsfs quick-serve username@:20001
   wsfs quick-serve username:password@:20001
   wsfs quick-serve http://username:password@[fe80::12:34]:20001
-  wsfs quick-serve unix://username:password@/run/unix.sock`,
+  wsfs quick-serve unix://username:password@/run/unix.sock
+  wsfs quick-serve --tls-self-signed username@:20001`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: func(c *cobra.Command, args []string) error {
 		util.SetupZerolog(noLogTime, noLogColor, jsonLog, logLevel)
 
 		config := serverConfig.Default
+		config.Listeners = []serverConfig.Listener{serverConfig.DefaultListener}
 
 		if err := configStorage(&config, c); err != nil {
 			return cmdexit.New(1, err)
@@ -165,6 +225,10 @@ var QuickServeCmd = &cobra.Command{
 			return cmdexit.New(2, fmt.Errorf("resolve password failed: %w", cmdpassword.ErrMissingUsername))
 		}
 
+		if err := configSelfSigned(&config, c); err != nil {
+			return cmdexit.New(1, err)
+		}
+
 		if len(config.Users) == 0 {
 			fmt.Fprintln(os.Stdout, "Warning: anonymous mode")
 			config.Anonymous.Enable = true
@@ -203,4 +267,6 @@ func init() {
 	cmdflags.AddFsIDFlags(QuickServeCmd.Flags(), &uid, &gid, &otherUid, &otherGid)
 	QuickServeCmd.Flags().StringVarP(&storage, "storage", "s", "", "Storage path")
 	cmdflags.AddPasswordFlag(QuickServeCmd.Flags(), &passwordSource)
+	QuickServeCmd.Flags().BoolVar(&tlsSelfSigned, "tls-self-signed", false, "Serve TLS with a generated self-signed ECDSA certificate")
+	QuickServeCmd.Flags().StringVar(&tlsPersistDir, "tls-self-signed-dir", "", "Directory to keep the self-signed key pair in and reuse it from; not persisted if empty")
 }
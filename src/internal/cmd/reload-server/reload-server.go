@@ -7,12 +7,16 @@ import (
 	"os"
 	"syscall"
 	cmdexit "wsfs-core/internal/cmd/exit"
+	"wsfs-core/internal/server/control"
 
 	"github.com/shirou/gopsutil/v3/process"
 	"github.com/spf13/cobra"
 )
 
-var serverPid int32
+var (
+	serverPid  int32
+	socketPath string
+)
 
 func findProcess() (int32, error) {
 	ps, err := process.Processes()
@@ -48,6 +52,15 @@ var ReloadConfigCmd = &cobra.Command{
 	RunE: func(c *cobra.Command, _ []string) error {
 		var err error
 
+		if socketPath != "" {
+			_, err = control.Call(socketPath, control.CommandReload)
+			if err != nil {
+				return cmdexit.New(1, fmt.Errorf("reload failed: %w", err))
+			}
+			fmt.Println("Reloaded")
+			return nil
+		}
+
 		if !c.Flags().Changed("pid") {
 			serverPid, err = findProcess()
 
@@ -75,4 +88,6 @@ var ReloadConfigCmd = &cobra.Command{
 
 func init() {
 	ReloadConfigCmd.Flags().Int32VarP(&serverPid, "pid", "p", 0, "Server pid")
+	ReloadConfigCmd.Flags().StringVarP(&socketPath, "socket", "s", "", "Reload through the control socket and wait for the result")
+	ReloadConfigCmd.MarkFlagsMutuallyExclusive("pid", "socket")
 }
@@ -0,0 +1,110 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	cmdexit "wsfs-core/internal/cmd/exit"
+	"wsfs-core/internal/server"
+	serverConfig "wsfs-core/internal/server/config"
+	"wsfs-core/internal/server/control"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkConfigPath string
+	againstRunning  bool
+	controlSocket   string
+)
+
+var ErrConfigInvalid = errors.New("config has errors")
+
+var CheckConfigCmd = &cobra.Command{
+	Use:   "check-config",
+	Short: "Check a server config file without starting a server",
+	Long: `Check a server config file without starting a server
+All errors and warnings are reported with their positions.
+With --against-running, the sections that a reload would change are listed.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		config, err := findAndDecodeConfig(checkConfigPath)
+		if err != nil {
+			var problem serverConfig.Problem
+			if checkConfigPath != internalDefaultConfigPath {
+				problem = serverConfig.DecodeProblem(checkConfigPath, errors.Unwrap(err))
+			} else {
+				problem = serverConfig.Problem{Err: err}
+			}
+			fmt.Fprintln(os.Stderr, problem.String())
+			return cmdexit.New(1, ErrConfigInvalid)
+		}
+
+		problems := server.CheckConfig(config)
+		for _, problem := range problems.List {
+			fmt.Fprintln(os.Stderr, problem.String())
+		}
+		if problems.HasError() {
+			return cmdexit.New(1, ErrConfigInvalid)
+		}
+		fmt.Println("Config OK")
+
+		if againstRunning {
+			socket := controlSocket
+			if socket == "" {
+				socket = config.Control.Socket
+			}
+			if socket == "" {
+				return cmdexit.New(2, errors.New("no control socket given or configured"))
+			}
+			rsp, err := control.Call(socket, control.CommandConfig)
+			if err != nil {
+				return cmdexit.New(2, fmt.Errorf("unable to get running config: %w", err))
+			}
+			printConfigDiff(rsp.ConfigSections, serverConfig.SectionDigests(config))
+		}
+		return nil
+	},
+}
+
+func printConfigDiff(running, checked map[string]string) {
+	var names []string
+	for name := range running {
+		names = append(names, name)
+	}
+	for name := range checked {
+		if _, ok := running[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	changed := false
+	for _, name := range names {
+		runningDigest, inRunning := running[name]
+		checkedDigest, inChecked := checked[name]
+		switch {
+		case !inRunning:
+			fmt.Printf("+ %s\n", name)
+		case !inChecked:
+			fmt.Printf("- %s\n", name)
+		case runningDigest != checkedDigest && name == "Control":
+			fmt.Printf("~ %s (takes effect on restart)\n", name)
+		case runningDigest != checkedDigest:
+			fmt.Printf("~ %s\n", name)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		fmt.Println("No change against the running server")
+	}
+}
+
+func init() {
+	CheckConfigCmd.Flags().StringVarP(&checkConfigPath, "config", "c", internalDefaultConfigPath, "Path to config file")
+	CheckConfigCmd.Flags().BoolVar(&againstRunning, "against-running", false, "List the sections that would change on reload of the running server")
+	CheckConfigCmd.Flags().StringVarP(&controlSocket, "socket", "s", "", "Control socket of the running server; defaults to Control.Socket of the config")
+}
@@ -20,6 +20,7 @@ var (
 	noLogTime                 bool
 	noLogColor                bool
 	jsonLog                   bool
+	upgrade                   bool
 	insecureSessionIdMathRand bool
 	logLevel                  zerolog.Level = zerolog.InfoLevel
 )
@@ -31,6 +32,12 @@ var ServeCmd = &cobra.Command{
 	RunE: func(c *cobra.Command, _ []string) error {
 		util.SetupZerolog(noLogTime, noLogColor, jsonLog, logLevel)
 
+		if upgrade {
+			if err := server.AcceptUpgrade(); err != nil {
+				return cmdexit.New(2, fmt.Errorf("accept upgrade failed: %w", err))
+			}
+		}
+
 		config, err := findAndDecodeConfig(configPath)
 		if err != nil {
 			return cmdexit.New(2, err)
@@ -59,6 +66,7 @@ var ServeCmd = &cobra.Command{
 			Sighup:  hub.IssueReload,
 			Sigint:  hub.IssueShutdown,
 			Sigterm: hub.IssueShutdown,
+			Sigusr2: hub.IssueUpgrade,
 			OnHandlerPanic: func(obj any) {
 				log.Error().Any("Error", obj).Msg("Panic during signal handling")
 			},
@@ -80,5 +88,6 @@ func init() {
 
 	ServeCmd.Flags().StringVarP(&configPath, "config", "c", internalDefaultConfigPath, "Path to config file")
 	cmdflags.AddLoggingFlags(ServeCmd.Flags(), &logLevel, &noLogTime, &noLogColor, &jsonLog)
+	ServeCmd.Flags().BoolVar(&upgrade, "upgrade", false, "Take over the listener handed off by a server being upgraded (set by the SIGUSR2 handoff)")
 	ServeCmd.Flags().BoolVar(&insecureSessionIdMathRand, "insecure-session-id-math-rand", false, "Use math/rand for WSFS session resume IDs instead of crypto/rand; insecure and easier to predict")
 }
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+const certWatchPeriod = 1 * time.Minute
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// certStore holds the key pairs of a TLS listener. The files are polled and
+// the key pairs are swapped as a whole when any of them changed.
+type certStore struct {
+	inMemory *tls.Certificate // self-signed key pair that is not persisted
+	pairs    []config.TLSCertificate
+	certs    atomic.Pointer[[]tls.Certificate]
+	stamps   []fileStamp
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCertStore(c config.Listener) (*certStore, error) {
+	s := &certStore{done: make(chan struct{})}
+
+	if c.TLS.SelfSigned {
+		cert, err := SelfSignedCertificate(c)
+		if err != nil {
+			return nil, err
+		}
+		if c.TLS.CertFile == "" && c.TLS.KeyFile == "" {
+			s.inMemory = cert
+			log.Warn().Str("Hash", util.X509CertHash(cert.Leaf)).Msg("TLS certificate self-signed")
+		}
+	}
+	if s.inMemory == nil {
+		s.pairs = append(s.pairs, config.TLSCertificate{CertFile: c.TLS.CertFile, KeyFile: c.TLS.KeyFile})
+	}
+	s.pairs = append(s.pairs, c.TLS.Certificates...)
+
+	log.Info().Msg("Loading TLS key pair")
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if len(s.pairs) != 0 {
+		go s.watch()
+	}
+	return s, nil
+}
+
+func (s *certStore) tlsConfig() *tls.Config {
+	return &tls.Config{GetCertificate: s.getCertificate}
+}
+
+// getCertificate selects a key pair by SNI, or the first one if none matches.
+func (s *certStore) getCertificate(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certs.Load()
+	if len(certs) > 1 {
+		for i := range certs {
+			if chi.SupportsCertificate(&certs[i]) == nil {
+				return &certs[i], nil
+			}
+		}
+	}
+	return &certs[0], nil
+}
+
+// stop ends the polling of the files. It may be called more than once.
+func (s *certStore) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+func (s *certStore) fileStamps() ([]fileStamp, error) {
+	stamps := make([]fileStamp, 0, 2*len(s.pairs))
+	for _, pair := range s.pairs {
+		for _, path := range []string{pair.CertFile, pair.KeyFile} {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			stamps = append(stamps, fileStamp{modTime: fi.ModTime(), size: fi.Size()})
+		}
+	}
+	return stamps, nil
+}
+
+func (s *certStore) load() error {
+	stamps, err := s.fileStamps()
+	if err != nil {
+		return err
+	}
+
+	certs := make([]tls.Certificate, 0, len(s.pairs)+1)
+	if s.inMemory != nil {
+		certs = append(certs, *s.inMemory)
+	}
+	for _, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return err
+		}
+		log.Warn().Str("File", pair.CertFile).Strs("Names", cert.Leaf.DNSNames).
+			Str("Hash", util.X509CertHash(cert.Leaf)).Msg("TLS certificate loaded")
+		certs = append(certs, cert)
+	}
+
+	s.certs.Store(&certs)
+	s.stamps = stamps
+	return nil
+}
+
+func (s *certStore) changed() bool {
+	stamps, err := s.fileStamps()
+	if err != nil {
+		// files may be in the middle of replacing
+		return false
+	}
+	for i := range stamps {
+		if !stamps[i].modTime.Equal(s.stamps[i].modTime) || stamps[i].size != s.stamps[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *certStore) watch() {
+	ticker := time.NewTicker(certWatchPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !s.changed() {
+			continue
+		}
+		log.Info().Msg("TLS key pair changed, reloading")
+		if err := s.load(); err != nil {
+			// keep the old key pairs and retry on next tick
+			log.Error().Err(err).Msg("Unable to reload TLS key pair")
+		}
+	}
+}
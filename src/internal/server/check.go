@@ -0,0 +1,214 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/server/webdav"
+	"wsfs-core/internal/server/wsfs"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+)
+
+// CheckConfig runs the validation done by starting a server, without
+// listening, and reports all problems found rather than the first one.
+func CheckConfig(c config.Server) *config.Problems {
+	p := config.NewProblems(&c)
+	if err := p.UnknownKeys(); err != nil {
+		p.Error("", err)
+	}
+
+	checkListeners(p, c.Listeners)
+	if c.Control.Socket != "" {
+		_, err := parseSocketPermission(config.Listener{
+			SocketMode:  c.Control.SocketMode,
+			SocketUser:  c.Control.SocketUser,
+			SocketGroup: c.Control.SocketGroup,
+		})
+		if err != nil {
+			p.Error("Control", err)
+		}
+	}
+
+	if c.Shutdown.Timeout < 0 || c.Shutdown.RetryAfter < 0 {
+		p.Error("Shutdown", ErrBadShutdown)
+	}
+	if c.SessionStateFile != "" {
+		if _, err := os.Stat(filepath.Dir(c.SessionStateFile)); err != nil {
+			p.Warn("SessionStateFile", err)
+		}
+	}
+	if c.Trace.Duration < 0 || c.Trace.MaxData < 0 {
+		p.Error("Trace", wsfs.ErrBadTrace)
+	}
+	if c.Trace.File != "" {
+		if _, err := os.Stat(filepath.Dir(c.Trace.File)); err != nil {
+			p.Error("Trace", err)
+		}
+	}
+
+	checkServer(p, "", c, nil)
+	for i := range c.VirtualHosts {
+		checkServer(p, fmt.Sprintf("VirtualHosts[%d].", i), c.VirtualHosts[i].Server, &c)
+	}
+	return p
+}
+
+func checkListeners(p *config.Problems, listeners []config.Listener) {
+	for i, l := range listeners {
+		key := fmt.Sprintf("Listeners[%d]", i)
+		if err := checkListenerDuplicate(listeners[:i], l); err != nil {
+			p.Error(key, err)
+		}
+		if _, err := parseListenerOption(l); err != nil {
+			p.Error(key, err)
+		}
+
+		if !l.TLS.Enable {
+			continue
+		}
+		if l.TLS.SelfSigned {
+			if l.TLS.CertFile != "" || l.TLS.KeyFile != "" {
+				_, certErr := os.Stat(l.TLS.CertFile)
+				_, keyErr := os.Stat(l.TLS.KeyFile)
+				if os.IsNotExist(certErr) != os.IsNotExist(keyErr) {
+					p.Error(key+".TLS", ErrSelfSignedPartial)
+				}
+			}
+		} else if _, err := tls.LoadX509KeyPair(l.TLS.CertFile, l.TLS.KeyFile); err != nil {
+			p.Error(key+".TLS.CertFile", err)
+		}
+		for j, pair := range l.TLS.Certificates {
+			if _, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile); err != nil {
+				p.Error(fmt.Sprintf("%s.TLS.Certificates[%d]", key, j), err)
+			}
+		}
+	}
+}
+
+// checkServer checks the sections of a config. For a virtual host, sections
+// inherited unchanged from the top level config are not checked again.
+func checkServer(p *config.Problems, prefix string, c config.Server, top *config.Server) {
+	if top == nil || c.BasePath != top.BasePath {
+		if _, err := normalizeBasePath(c.BasePath); err != nil {
+			p.Error(prefix+"BasePath", err)
+		}
+	}
+	if top == nil || !reflect.DeepEqual(c.AllowCIDRs, top.AllowCIDRs) || !reflect.DeepEqual(c.DenyCIDRs, top.DenyCIDRs) {
+		if _, err := util.NewAddressRules(c.AllowCIDRs, c.DenyCIDRs); err != nil {
+			p.Error(prefix+"AllowCIDRs", err)
+		}
+	}
+
+	storagesInherited := top != nil && reflect.DeepEqual(c.Storages, top.Storages)
+	storagesOK := true
+	for i, st := range c.Storages {
+		for _, other := range c.Storages[:i] {
+			if other.Id == st.Id {
+				storagesOK = false
+			}
+		}
+	}
+
+	if !storagesInherited {
+		for i, st := range c.Storages {
+			key := fmt.Sprintf("%sStorages[%d]", prefix, i)
+			for _, other := range c.Storages[:i] {
+				if other.Id == st.Id {
+					p.Error(key+".Id", fmt.Errorf("storage id %q repeated", st.Id))
+				}
+			}
+			if fi, err := os.Stat(st.Path); err != nil {
+				p.Warn(key+".Path", err)
+			} else if !fi.IsDir() {
+				p.Warn(key+".Path", errors.New("not a directory"))
+			}
+		}
+	}
+
+	// user errors would be hidden by storage errors
+	if storagesOK && (top == nil || !storagesInherited || !reflect.DeepEqual(c.Users, top.Users)) {
+		peerUids := map[uint32]string{}
+		for i, us := range c.Users {
+			key := fmt.Sprintf("%sUsers[%d]", prefix, i)
+			for _, other := range c.Users[:i] {
+				if other.Name == us.Name {
+					p.Error(key+".Name", fmt.Errorf("user %q repeated", us.Name))
+				}
+			}
+
+			single := c
+			single.Users = []config.User{us}
+			single.Anonymous.Enable = false
+			if _, _, err := storage.NewUsers(single, anonymousUsername); err != nil {
+				p.Error(key, err)
+			}
+
+			for _, uid := range us.PeerUids {
+				if name, ok := peerUids[uid]; ok {
+					p.Error(key+".PeerUids", fmt.Errorf("peer uid %d used by both user %q and %q", uid, name, us.Name))
+				}
+				peerUids[uid] = us.Name
+			}
+		}
+	}
+
+	if c.Anonymous.Enable && storagesOK &&
+		(top == nil || !storagesInherited || c.Anonymous != top.Anonymous) {
+		single := c
+		single.Users = nil
+		if _, anonymous, err := storage.NewUsers(single, anonymousUsername); err != nil {
+			p.Error(prefix+"Anonymous.Storage", err)
+		} else if !anonymous.ReadOnly {
+			p.Warn(prefix+"Anonymous.ReadOnly", errors.New("anyone can write to the storage without login"))
+		}
+	}
+
+	if c.Webdav.Webui.Enable && !c.Webdav.Enable {
+		p.Error(prefix+"Webdav.Webui.Enable", errors.New("webui enabled but webdav disabled"))
+	}
+	if c.Webdav.CORS.Enable && c.Webdav.CORS.AllowCredentials && slices.Contains(c.Webdav.CORS.AllowedOrigins, "*") {
+		p.Error(prefix+"Webdav.CORS.AllowCredentials", webdav.ErrCORSAnyOriginCredentials)
+	}
+	if c.Webdav.Webui.CustomResources != "" {
+		if _, err := os.Stat(c.Webdav.Webui.CustomResources); err != nil {
+			p.Warn(prefix+"Webdav.Webui.CustomResources", err)
+		}
+	}
+
+	if c.WSFS.Enable {
+		if top == nil || !reflect.DeepEqual(c.FsIds, top.FsIds) {
+			if _, err := c.FsIds.Resolve(); err != nil {
+				p.Error(prefix+"FsIds", err)
+			}
+		}
+	}
+	if c.WSFS.Enable && (top == nil || !reflect.DeepEqual(c.WSFS, top.WSFS)) {
+		if c.WSFS.InsecureSessionIdMathRand {
+			p.Warn(prefix+"WSFS.InsecureSessionIdMathRand", errors.New("session resume ids are predictable"))
+		}
+		if c.WSFS.EnableLink {
+			p.Warn(prefix+"WSFS.EnableLink", errors.New("hard links are not recommended; link counts are not reported"))
+		}
+		if n := c.WSFS.MaxMsgSize; n != wsfsprotocol.ClampMsgSize(n) {
+			p.Warn(prefix+"WSFS.MaxMsgSize", fmt.Errorf("out of range, %d is used", wsfsprotocol.ClampMsgSize(n)))
+		}
+		if n := c.WSFS.MaxSessionConns; n < 1 || n > wsfsprotocol.MaxSessionConns {
+			p.Warn(prefix+"WSFS.MaxSessionConns", fmt.Errorf("out of range, %d is used", min(max(n, 1), wsfsprotocol.MaxSessionConns)))
+		}
+	}
+
+	// anything missed above
+	if !p.HasError() {
+		if _, err := NewServer(c, nil); err != nil {
+			p.Error(strings.TrimSuffix(prefix, "."), err)
+		}
+	}
+}
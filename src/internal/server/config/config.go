@@ -2,22 +2,39 @@ package config
 
 import "wsfs-core/internal/util"
 
-type TLS struct {
-	Enable   bool
+type TLSCertificate struct {
 	CertFile string
 	KeyFile  string
 }
 
+type TLS struct {
+	Enable       bool
+	SelfSigned   bool // generate a key pair, persisted to CertFile and KeyFile if set
+	CertFile     string
+	KeyFile      string
+	Certificates []TLSCertificate // extra key pairs, selected by SNI
+}
+
 type Webui struct {
 	Enable          bool
 	ShowDirSize     bool
 	CustomResources string
 }
 
+type CORS struct {
+	Enable           bool
+	AllowedOrigins   []string // "*" means any origin
+	AllowedMethods   []string // empty means all WebDAV methods
+	AllowedHeaders   []string // empty means the headers used by WebDAV
+	AllowCredentials bool
+	MaxAge           int // seconds
+}
+
 type Webdav struct {
 	Enable                 bool
 	AllowPropfindInfDepth  bool
 	EnableContentTypeProbe bool
+	CORS                   CORS
 	Webui                  Webui
 }
 
@@ -26,25 +43,80 @@ type WSFS struct {
 	InsecureSessionIdMathRand bool
 	EnableLink                bool
 	AllowedXAttrPrefix        []string
+	SessionLifetime           int  // seconds a disconnected session is kept
+	RequireResumeKey          bool // refuse sessions not bound to a client secret
+	MaxMsgSize                int  // bytes; the largest message size negotiated with clients
+	MaxSessionConns           int  // connections a session may run on at once
+
+	Compression          string // permessage-deflate: "off", "on" or "context-takeover"
+	CompressionThreshold int    // bytes; smaller messages are sent uncompressed
+}
+
+type ProxyProtocol struct {
+	Enable       bool
+	TrustedCIDRs []string
 }
 
 type Listener struct {
-	Address string
-	Network string
-	TLS     TLS
+	Address       string
+	Network       string
+	TLS           TLS
+	ProxyProtocol ProxyProtocol
+	Frontends     []string // "wsfs", "webdav" and "webui"; empty means all
+
+	// unix only
+	SocketMode  string // octal, e.g. "0660"; empty means decided by umask
+	SocketUser  string // name or uid
+	SocketGroup string // name or gid
+	PeerAuth    bool   // authenticate users by the uid of the connecting process
+}
+
+type Control struct {
+	Socket      string // unix socket path; empty disables the control socket
+	SocketMode  string // octal; empty means "0600"
+	SocketUser  string // name or uid
+	SocketGroup string // name or gid
+}
+
+type Shutdown struct {
+	Timeout    int // seconds to wait for requests and sessions; 0 means no limit
+	RetryAfter int // seconds WSFS clients wait before resuming their sessions
+}
+
+// Trace logs the commands of some WSFS sessions for debugging. Traces
+// named here start when the config is loaded, or when they first appear in a
+// reloaded config.
+type Trace struct {
+	File     string   // commands are logged here; empty disables tracing
+	Duration int      // seconds a trace lasts
+	MaxData  int      // bytes of data payloads logged
+	Users    []string // trace the sessions of these users
+	Sessions []string // trace these session ids
 }
 
 type Server struct {
-	filePath     string // internal, path to this config file
-	Listener     Listener
-	Webdav       Webdav
-	WSFS         WSFS
-	Storages     []Storage
-	Anonymous    AnonymousUser
-	Users        []User
-	RealIpHeader string
-	ServerHeader string
-	FsIds        util.OptionalFsIds
+	filePath         string    // internal, path to this config file
+	includedFiles    []string  // internal, files matched by Include
+	raw              *Server   // internal, this config before interpolation
+	Include          []string  // globs of files merged into this one, relative to it
+	Listener         *Listener // deprecated, use Listeners
+	Listeners        []Listener
+	Webdav           Webdav
+	WSFS             WSFS
+	Storages         []Storage
+	Anonymous        AnonymousUser
+	Users            []User
+	RealIpHeader     string
+	ServerHeader     string
+	BasePath         string // URL prefix stripped from requests, e.g. "/files"
+	AllowCIDRs       []string
+	DenyCIDRs        []string
+	FsIds            util.OptionalFsIds
+	Control          Control
+	Shutdown         Shutdown
+	SessionStateFile string        // WSFS sessions are saved here on shutdown; empty disables
+	Trace            Trace         // WSFS command tracing, top level only
+	VirtualHosts     []VirtualHost `toml:"-"` // decoded by decodeVirtualHosts
 }
 
 type User struct {
@@ -52,6 +124,11 @@ type User struct {
 	SecretHash string
 	ReadOnly   bool
 	Storage    string
+	AllowCIDRs []string
+	DenyCIDRs  []string
+	PeerUids   []uint32 // unix uids logged in as this user by PeerAuth listeners
+
+	SessionLifetime int // seconds; 0 means WSFS.SessionLifetime
 }
 
 type AnonymousUser struct {
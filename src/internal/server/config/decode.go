@@ -2,29 +2,159 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"path/filepath"
+	"slices"
 
 	"github.com/BurntSushi/toml"
 )
 
-var ErrReDecodeDefaultConfig = errors.New("can not redecode default config")
+var (
+	ErrReDecodeDefaultConfig = errors.New("can not redecode default config")
+	ErrListenerAndListeners  = errors.New("both Listener and Listeners are set")
+	ErrNestedInclude         = errors.New("included files can not include others")
+)
+
+// IncludeError is an error in a file included by the config file.
+type IncludeError struct {
+	File string
+	Err  error
+}
 
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("included file %s: %v", e.File, e.Err)
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
+
+// Decode decodes the config file at path and the files it includes, then
+// applies environment overrides and interpolation.
 func Decode(config *Server, path string) error {
+	// listeners and arrays of tables are never merged with the previous ones
+	config.Listener = nil
+	config.Listeners = nil
+	config.VirtualHosts = nil
+	config.Storages = nil
+	config.Users = nil
+	config.Include = nil
+
+	var err error
+	config.filePath, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = toml.DecodeFile(path, config)
+	if err != nil {
+		return err
+	}
+
+	config.includedFiles, err = expandIncludes(config.filePath, config.Include)
+	if err != nil {
+		return err
+	}
+	for _, included := range config.includedFiles {
+		if err = decodeIncluded(config, included); err != nil {
+			return &IncludeError{File: included, Err: err}
+		}
+	}
+
+	if config.Listener != nil {
+		if len(config.Listeners) != 0 {
+			return ErrListenerAndListeners
+		}
+		config.Listeners = []Listener{*config.Listener}
+		config.Listener = nil
+	}
+	if len(config.Listeners) == 0 {
+		config.Listeners = []Listener{DefaultListener}
+	}
+	for i := range config.Listeners {
+		if config.Listeners[i].Network == "" {
+			config.Listeners[i].Network = DefaultListener.Network
+		}
+	}
+
+	if err = applyEnvOverrides(config); err != nil {
+		return err
+	}
+	// virtual hosts inherit the overridden, but not yet interpolated, values
+	if err = decodeVirtualHosts(config, config.Files()); err != nil {
+		return err
+	}
+	raw := config.inheritable()
+	config.raw = &raw
+	return interpolate(config)
+}
+
+// expandIncludes resolves the globs relative to the directory of the
+// including file. Files matched by a glob are taken in lexical order.
+func expandIncludes(path string, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad include pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if match != path && !slices.Contains(files, match) {
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// decodeIncluded merges an included file. Keys in tables override the
+// previous ones, while arrays of tables are appended.
+func decodeIncluded(config *Server, path string) error {
+	listeners, storages, users := config.Listeners, config.Storages, config.Users
+	include := config.Include
+	config.Listeners, config.Storages, config.Users = nil, nil, nil
+	config.Include = nil
+
 	_, err := toml.DecodeFile(path, config)
 	if err != nil {
 		return err
 	}
+	if len(config.Include) != 0 {
+		return ErrNestedInclude
+	}
 
-	config.filePath, err = filepath.Abs(path)
-	return err
+	config.Include = include
+
+	config.Listeners = append(listeners, config.Listeners...)
+	config.Storages = append(storages, config.Storages...)
+	config.Users = append(users, config.Users...)
+	return nil
 }
 
+// Files returns the config file and the files it includes, in decoding order.
+func (c *Server) Files() []string {
+	if c.filePath == "" {
+		return nil
+	}
+	return append([]string{c.filePath}, c.includedFiles...)
+}
+
+// ReDecode decodes the config file again over the old config, so keys
+// removed from the file keep their old values. Those are taken before
+// interpolation, so that they are not expanded twice.
 func ReDecode(old *Server) (new Server, err error) {
 	if old.filePath == Default.filePath {
 		err = ErrReDecodeDefaultConfig
 		return
 	}
 
-	new = *old
+	if old.raw != nil {
+		new = old.raw.inheritable()
+	} else {
+		new = *old
+	}
 	return new, Decode(&new, old.filePath)
 }
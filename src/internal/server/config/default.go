@@ -1,16 +1,18 @@
 package config
 
-var Default = Server{
-	filePath: "",
-	Listener: Listener{
-		Address: ":20001",
-		Network: "tcp",
-		TLS: TLS{
-			Enable:   false,
-			CertFile: "/srv/ssl/cert",
-			KeyFile:  "/srv/ssl/key",
-		},
+var DefaultListener = Listener{
+	Address: ":20001",
+	Network: "tcp",
+	TLS: TLS{
+		Enable:   false,
+		CertFile: "/srv/ssl/cert",
+		KeyFile:  "/srv/ssl/key",
 	},
+}
+
+var Default = Server{
+	filePath:  "",
+	Listeners: []Listener{DefaultListener},
 	Webdav: Webdav{
 		Enable:                 true,
 		EnableContentTypeProbe: false,
@@ -23,11 +25,24 @@ var Default = Server{
 	WSFS: WSFS{
 		Enable:                    true,
 		InsecureSessionIdMathRand: false,
+		SessionLifetime:           15 * 60,
+		MaxMsgSize:                1 << 20,
+		MaxSessionConns:           4,
+		Compression:               "on",
+		CompressionThreshold:      512,
 	},
 	Anonymous: AnonymousUser{
 		Enable:   false,
 		ReadOnly: true,
 	},
+	Shutdown: Shutdown{
+		Timeout:    30,
+		RetryAfter: 5,
+	},
+	Trace: Trace{
+		Duration: 10 * 60,
+		MaxData:  32,
+	},
 	Users:    []User{},
 	Storages: []Storage{},
 }
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+)
+
+// SectionDigests hashes each top level section of a config, each listener
+// and each virtual host, so that two configs can be compared without
+// exposing their content.
+func SectionDigests(c Server) map[string]string {
+	digests := make(map[string]string)
+	add := func(name string, v any) {
+		data, _ := json.Marshal(v)
+		sum := sha256.Sum256(data)
+		digests[name] = hex.EncodeToString(sum[:])
+	}
+
+	rv := reflect.ValueOf(c)
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		switch {
+		case !field.IsExported(), field.Name == "Listener":
+		case field.Name == "Listeners":
+			for _, l := range c.Listeners {
+				add("Listeners."+l.Network+" "+l.Address, l)
+			}
+		case field.Name == "VirtualHosts":
+			for _, v := range c.VirtualHosts {
+				// listeners are shared, and compared above
+				vc := v.Server
+				vc.Listeners = nil
+				add("VirtualHosts."+v.Key(), vc)
+			}
+		default:
+			add(field.Name, rv.Field(i).Interface())
+		}
+	}
+	return digests
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const envPrefix = "WSFS_"
+
+// envName returns the environment variable overriding a top level key,
+// e.g. "WSFS_REAL_IP_HEADER" for RealIpHeader.
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1]) &&
+				// keep the plural "s" of an acronym, as in AllowCIDRs
+				!(runes[i+1] == 's' && i+2 == len(runes))
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// applyEnvOverrides sets the top level keys of kind string, bool, integer
+// and list of strings from WSFS_* environment variables. Lists are comma
+// separated. Other WSFS_* variables are ignored.
+func applyEnvOverrides(config *Server) error {
+	rv := reflect.ValueOf(config).Elem()
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		if !field.IsExported() || field.Tag.Get("toml") == "-" || field.Name == "Include" {
+			continue
+		}
+		name := envName(field.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(rv.Field(i), value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	}
+	// tables can not be set from the environment
+	return nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import "testing"
+
+func TestEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"RealIpHeader":     "WSFS_REAL_IP_HEADER",
+		"BasePath":         "WSFS_BASE_PATH",
+		"AllowCIDRs":       "WSFS_ALLOW_CIDRS",
+		"DenyCIDRs":        "WSFS_DENY_CIDRS",
+		"FsIds":            "WSFS_FS_IDS",
+		"SessionStateFile": "WSFS_SESSION_STATE_FILE",
+		"WSFS":             "WSFS_WSFS",
+		"TLSCertificate":   "WSFS_TLS_CERTIFICATE",
+		"Webdav":           "WSFS_WEBDAV",
+	} {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrUnterminatedReference = errors.New("unterminated ${ reference")
+	ErrUndefinedVariable     = errors.New("undefined environment variable")
+)
+
+// interpolate replaces references in all string values of the config:
+// "${NAME}" by the environment variable NAME and "${file:PATH}" by the
+// content of the file at PATH, without its trailing newline. "$${" is a
+// literal "${".
+//
+// Virtual hosts are decoded before, from the raw values, and their arrays
+// inherited from the top level config are shared with it, so each value is
+// expanded exactly once.
+func interpolate(config *Server) error {
+	if err := interpolateValue(reflect.ValueOf(config).Elem(), ""); err != nil {
+		return err
+	}
+
+	top := reflect.ValueOf(config).Elem()
+	for i := range config.VirtualHosts {
+		vhost := &config.VirtualHosts[i]
+		v := reflect.ValueOf(&vhost.Server).Elem()
+		t := v.Type()
+		for j := range t.NumField() {
+			field := t.Field(j)
+			if !field.IsExported() || field.Tag.Get("toml") == "-" {
+				continue
+			}
+			if field.Type.Kind() == reflect.Slice && v.Field(j).Len() != 0 &&
+				v.Field(j).Pointer() == top.Field(j).Pointer() {
+				continue
+			}
+			key := fmt.Sprintf("VirtualHosts.%s.%s", vhost.Key(), field.Name)
+			if err := interpolateValue(v.Field(j), key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func interpolateValue(v reflect.Value, key string) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expandReferences(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		v.SetString(s)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return interpolateValue(v.Elem(), key)
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := interpolateValue(v.Index(i), fmt.Sprintf("%s[%d]", key, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("toml") == "-" {
+				continue
+			}
+			if err := interpolateValue(v.Field(i), joinKey(key, field.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expandReferences(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", ErrUnterminatedReference
+		}
+		value, err := resolveReference(s[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+func resolveReference(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+	}
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUndefinedVariable, ref)
+	}
+	return value, nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandReferences(t *testing.T) {
+	t.Setenv("WSFS_TEST_NAME", "value")
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"lf":    "secret\n",
+		"crlf":  "secret\r\n",
+		"none":  "secret",
+		"lines": "a\nb\n\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{in: "plain", want: "plain"},
+		{in: "$NAME {x}", want: "$NAME {x}"},
+		{in: "${WSFS_TEST_NAME}", want: "value"},
+		{in: "a-${WSFS_TEST_NAME}-${WSFS_TEST_NAME}-b", want: "a-value-value-b"},
+		{in: "$${WSFS_TEST_NAME}", want: "${WSFS_TEST_NAME}"},
+		{in: "$$${WSFS_TEST_NAME}", want: "$${WSFS_TEST_NAME}"},
+		{in: "$${WSFS_TEST_NAME}-${WSFS_TEST_NAME}", want: "${WSFS_TEST_NAME}-value"},
+		{in: "${file:" + filepath.Join(dir, "lf") + "}", want: "secret"},
+		{in: "${file:" + filepath.Join(dir, "crlf") + "}", want: "secret"},
+		{in: "${file:" + filepath.Join(dir, "none") + "}", want: "secret"},
+		{in: "${file:" + filepath.Join(dir, "lines") + "}", want: "a\nb\n"},
+		{in: "${WSFS_TEST_UNSET}", err: ErrUndefinedVariable},
+		{in: "${WSFS_TEST_NAME", err: ErrUnterminatedReference},
+		{in: "${file:" + filepath.Join(dir, "missing") + "}", err: os.ErrNotExist},
+	} {
+		got, err := expandReferences(tc.in)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expandReferences(%q) error = %v, want %v", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("expandReferences(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+// TestReDecodeKeepsEscapes redecodes a config whose escaped reference is
+// kept from the old config; it must not be expanded by the second decode.
+func TestReDecodeKeepsEscapes(t *testing.T) {
+	t.Setenv("WSFS_TEST_NAME", "value")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("ServerHeader = \"$${WSFS_TEST_NAME}\"\nRealIpHeader = \"${WSFS_TEST_NAME}\"\nAllowCIDRs = [\"$${WSFS_TEST_NAME}\"]\n")
+	var config Server
+	if err := Decode(&config, path); err != nil {
+		t.Fatal(err)
+	}
+	// the keys are removed, so their old values are kept
+	write("BasePath = \"/files\"\n")
+	for range 2 {
+		next, err := ReDecode(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next.ServerHeader != "${WSFS_TEST_NAME}" || next.RealIpHeader != "value" ||
+			len(next.AllowCIDRs) != 1 || next.AllowCIDRs[0] != "${WSFS_TEST_NAME}" {
+			t.Errorf("redecoded ServerHeader %q, RealIpHeader %q, AllowCIDRs %q", next.ServerHeader, next.RealIpHeader, next.AllowCIDRs)
+		}
+		config = next
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keyLines maps key paths such as "Users[1].Storage" to the line where they
+// are set. Only table headers, array of tables headers and plain keys are
+// recognized, which covers the usual layout of a config file.
+type keyLines map[string]int
+
+func scanKeyLines(path string) (keyLines, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := keyLines{}
+	arrays := map[string]int{} // array path to the number of its tables
+	canonical := func(name string) string {
+		var out string
+		for _, part := range strings.Split(name, ".") {
+			out = joinKey(out, strings.Trim(strings.TrimSpace(part), `"'`))
+			if n, ok := arrays[out]; ok {
+				out += fmt.Sprintf("[%d]", n-1)
+			}
+		}
+		return out
+	}
+
+	var table string
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case strings.HasPrefix(line, "[["):
+			name, _, _ := strings.Cut(line[2:], "]]")
+			table = canonicalParent(canonical, name) + lastPart(name)
+			arrays[table]++
+			table += fmt.Sprintf("[%d]", arrays[table]-1)
+		case line[0] == '[':
+			name, _, _ := strings.Cut(line[1:], "]")
+			table = canonical(name)
+		default:
+			key, _, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key = joinKey(table, strings.Trim(strings.TrimSpace(key), `"'`))
+			if _, exists := lines[key]; !exists {
+				lines[key] = lineNo
+			}
+			continue
+		}
+		if _, exists := lines[table]; !exists {
+			lines[table] = lineNo
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// canonicalParent resolves all but the last part of a dotted table name.
+func canonicalParent(canonical func(string) string, name string) string {
+	i := strings.LastIndex(name, ".")
+	if i == -1 {
+		return ""
+	}
+	return canonical(name[:i]) + "."
+}
+
+func lastPart(name string) string {
+	i := strings.LastIndex(name, ".")
+	return strings.Trim(strings.TrimSpace(name[i+1:]), `"'`)
+}
+
+func joinKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// line returns the line of key, or of its closest parent that is found.
+func (l keyLines) line(key string) int {
+	for key != "" {
+		if line, ok := l[key]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i == -1 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Problem is an error or a warning found by checking a config file.
+type Problem struct {
+	File    string
+	Line    int    // 0 if unknown
+	Key     string // e.g. "Users[1].Storage"; empty if not about a key
+	Warning bool
+	Err     error
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		if p.Line != 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+		}
+		b.WriteString(": ")
+	}
+	if p.Warning {
+		b.WriteString("warning: ")
+	} else {
+		b.WriteString("error: ")
+	}
+	if p.Key != "" {
+		b.WriteString(p.Key + ": ")
+	}
+	b.WriteString(p.Err.Error())
+	return b.String()
+}
+
+// Problems collects the problems of a decoded config, located by key.
+type Problems struct {
+	file  string
+	files []string // file and included files
+	lines keyLines
+	List  []Problem
+}
+
+func NewProblems(c *Server) *Problems {
+	p := &Problems{file: c.filePath, files: c.Files()}
+	if c.filePath != "" {
+		// without the lines, problems are reported without positions
+		p.lines, _ = scanKeyLines(c.filePath)
+	}
+	return p
+}
+
+func (p *Problems) add(key string, warning bool, err error) {
+	p.List = append(p.List, Problem{
+		File:    p.file,
+		Line:    p.lines.line(key),
+		Key:     key,
+		Warning: warning,
+		Err:     err,
+	})
+}
+
+func (p *Problems) Error(key string, err error) {
+	p.add(key, false, err)
+}
+
+func (p *Problems) Warn(key string, err error) {
+	p.add(key, true, err)
+}
+
+func (p *Problems) HasError() bool {
+	for _, problem := range p.List {
+		if !problem.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeProblem locates an error returned by Decode.
+func DecodeProblem(path string, err error) Problem {
+	problem := Problem{File: path, Err: err}
+	var includeErr *IncludeError
+	if errors.As(err, &includeErr) {
+		problem.File = includeErr.File
+		problem.Err = includeErr.Err
+		err = includeErr.Err
+	}
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		problem.Line = parseErr.Position.Line
+		problem.Key = parseErr.LastKey
+		problem.Err = errors.New(parseErr.Message)
+	}
+	return problem
+}
+
+// UnknownKeys reports keys in the config file and the files it includes that
+// are not used, which are usually typos. Decode ignores them.
+func (p *Problems) UnknownKeys() error {
+	for _, file := range p.files {
+		if err := p.unknownKeysIn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Problems) unknownKeysIn(file string) error {
+	var raw struct {
+		Server
+		VirtualHosts []toml.Primitive
+	}
+	md, err := toml.DecodeFile(file, &raw)
+	if err != nil {
+		return err
+	}
+	for _, prim := range raw.VirtualHosts {
+		var vhost struct {
+			Server
+			Hosts []string
+		}
+		if err = md.PrimitiveDecode(prim, &vhost); err != nil {
+			return err
+		}
+	}
+
+	lines := p.lines
+	if file != p.file {
+		lines, _ = scanKeyLines(file)
+	}
+	for _, key := range md.Undecoded() {
+		name := key.String()
+		p.List = append(p.List, Problem{
+			File:    file,
+			Line:    lines.line(name),
+			Key:     name,
+			Warning: true,
+			Err:     errors.New("unknown key"),
+		})
+	}
+	return nil
+}
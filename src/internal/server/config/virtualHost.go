@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	ErrVirtualHostNoHosts    = errors.New("virtual host without Hosts")
+	ErrVirtualHostListeners  = errors.New("listeners can not be set in a virtual host")
+	ErrVirtualHostsRecursive = errors.New("virtual hosts can not be nested")
+)
+
+// VirtualHost is a `[[VirtualHosts]]` block. Keys set in the block override
+// the top level config: tables such as Webdav are merged key by key, arrays
+// such as Users and Storages are replaced as a whole.
+type VirtualHost struct {
+	Hosts  []string // matched against the Host header, port ignored
+	Server Server   // the resolved config of this virtual host
+}
+
+// Key identifies a virtual host across reloads, whatever the order of its
+// Hosts.
+func (v *VirtualHost) Key() string {
+	return strings.Join(slices.Sorted(slices.Values(v.Hosts)), ",")
+}
+
+// NormalizeHost lowercases a Host header and strips the port.
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// inheritable copies the config, so that decoding a virtual host block into
+// it does not write into the top level config.
+func (c *Server) inheritable() Server {
+	v := *c
+	v.raw = nil
+	v.Include = nil
+	v.includedFiles = nil
+	v.Listener = nil
+	v.Listeners = nil
+	v.VirtualHosts = nil
+	v.Storages = nil
+	v.Users = nil
+	v.AllowCIDRs = slices.Clone(c.AllowCIDRs)
+	v.DenyCIDRs = slices.Clone(c.DenyCIDRs)
+	v.Webdav.CORS.AllowedOrigins = slices.Clone(c.Webdav.CORS.AllowedOrigins)
+	v.Webdav.CORS.AllowedMethods = slices.Clone(c.Webdav.CORS.AllowedMethods)
+	v.Webdav.CORS.AllowedHeaders = slices.Clone(c.Webdav.CORS.AllowedHeaders)
+	v.WSFS.AllowedXAttrPrefix = slices.Clone(c.WSFS.AllowedXAttrPrefix)
+	v.Trace.Users = slices.Clone(c.Trace.Users)
+	v.Trace.Sessions = slices.Clone(c.Trace.Sessions)
+	return v
+}
+
+func decodeVirtualHosts(config *Server, paths []string) error {
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if err := decodeVirtualHostsIn(config, path, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeVirtualHostsIn(config *Server, path string, seen map[string]bool) error {
+	var raw struct {
+		VirtualHosts []toml.Primitive
+	}
+	md, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		return err
+	}
+
+	for _, prim := range raw.VirtualHosts {
+		var hosts struct {
+			Hosts        []string
+			VirtualHosts []toml.Primitive
+		}
+		if err = md.PrimitiveDecode(prim, &hosts); err != nil {
+			return err
+		}
+		if len(hosts.Hosts) == 0 {
+			return fmt.Errorf("virtual host #%d: %w", len(config.VirtualHosts)+1, ErrVirtualHostNoHosts)
+		}
+		if len(hosts.VirtualHosts) != 0 {
+			return fmt.Errorf("virtual host %q: %w", hosts.Hosts[0], ErrVirtualHostsRecursive)
+		}
+
+		vhost := VirtualHost{Server: config.inheritable()}
+		for _, host := range hosts.Hosts {
+			host = NormalizeHost(host)
+			if seen[host] {
+				return fmt.Errorf("duplicate virtual host: %q", host)
+			}
+			seen[host] = true
+			vhost.Hosts = append(vhost.Hosts, host)
+		}
+
+		if err = md.PrimitiveDecode(prim, &vhost.Server); err != nil {
+			return fmt.Errorf("virtual host %q: %w", vhost.Key(), err)
+		}
+		if vhost.Server.Listener != nil || len(vhost.Server.Listeners) != 0 {
+			return fmt.Errorf("virtual host %q: %w", vhost.Key(), ErrVirtualHostListeners)
+		}
+		vhost.Server.Listeners = config.Listeners
+		if vhost.Server.Storages == nil {
+			vhost.Server.Storages = config.Storages
+		}
+		if vhost.Server.Users == nil {
+			vhost.Server.Users = config.Users
+		}
+
+		config.VirtualHosts = append(config.VirtualHosts, vhost)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/server/control"
+	"wsfs-core/version"
+
+	"github.com/rs/zerolog/log"
+)
+
+const defaultControlSocketMode = "0600"
+
+var ErrBadTraceRequest = errors.New("trace needs either a user or a session id")
+
+func (h *Hub) startControl(c config.Control) error {
+	lc := config.Listener{
+		Network:     "unix",
+		Address:     c.Socket,
+		SocketMode:  c.SocketMode,
+		SocketUser:  c.SocketUser,
+		SocketGroup: c.SocketGroup,
+	}
+	if lc.SocketMode == "" {
+		lc.SocketMode = defaultControlSocketMode
+	}
+
+	// a stale sock file, or the one of an old process being upgraded, is
+	// replaced
+	listener, _, err := listen(lc)
+	if err != nil {
+		return fmt.Errorf("listen on control socket %q: %w", c.Socket, err)
+	}
+	h.control = listener
+	h.controlPath = c.Socket
+	log.Warn().Str("Addr", c.Socket).Msg("Control socket listening")
+
+	go control.Serve(listener, h.handleControl)
+	return nil
+}
+
+// stopControl keeps the sock file once handed off, since the new process has
+// taken over its path.
+func (h *Hub) stopControl() {
+	if h.control == nil {
+		return
+	}
+	h.control.Close()
+	if !h.handedOff.Load() {
+		cleanListen(config.Listener{Network: "unix", Address: h.controlPath})
+	}
+}
+
+func (h *Hub) handleControl(req control.Request) (rsp control.Response) {
+	var err error
+	switch req.Command {
+	case control.CommandReload:
+		err = h.Reload()
+	case control.CommandStatus:
+		rsp.Status = h.status()
+	case control.CommandSessions:
+		rsp.Sessions = h.sessions()
+	case control.CommandConfig:
+		if inst := h.inst.Load(); inst != nil {
+			rsp.ConfigSections = config.SectionDigests(inst.config)
+		}
+	case control.CommandTrace:
+		rsp.Trace, err = h.trace(req.Trace)
+	case control.CommandDrain:
+		err = h.Drain()
+	case control.CommandShutdown:
+		// respond before the process exits
+		go h.IssueShutdown()
+	default:
+		err = fmt.Errorf("%w: %q", control.ErrUnknownCommand, req.Command)
+	}
+
+	if err != nil {
+		rsp.Error = err.Error()
+	}
+	return
+}
+
+// Drain stops accepting connections and waits for in-flight requests, while
+// established WSFS connections keep being served. The process stays until
+// it is shut down.
+func (h *Hub) Drain() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.handedOff.Load() {
+		return ErrHandedOff
+	}
+	if h.draining.Swap(true) {
+		return ErrDraining
+	}
+	log.Warn().Msg("Draining")
+
+	h.listenersLock.Lock()
+	listeners := h.listeners
+	h.listeners = nil
+	h.listenersLock.Unlock()
+
+	h.stopListeners(listeners)
+	log.Warn().Msg("Drained")
+	return nil
+}
+
+// trace starts tracing the commands of a session, or of the sessions of a
+// user, in all virtual hosts.
+func (h *Hub) trace(req *control.TraceRequest) (*control.TraceResult, error) {
+	if req == nil || (req.Username == "") == (req.Id == "") {
+		return nil, ErrBadTraceRequest
+	}
+	until, err := h.tracer.Start(req.Username, req.Id, time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &control.TraceResult{Until: until, Sessions: h.applyTrace()}, nil
+}
+
+// applyTrace starts the traces on running sessions, and returns how many
+// sessions are traced.
+func (h *Hub) applyTrace() int {
+	h.registriesLock.Lock()
+	defer h.registriesLock.Unlock()
+	traced := 0
+	for _, registry := range h.wsfsRegistries {
+		traced += registry.ApplyTrace()
+	}
+	return traced
+}
+
+func (h *Hub) status() *control.Status {
+	status := &control.Status{
+		Pid:       os.Getpid(),
+		Version:   version.Version,
+		StartTime: h.startTime,
+		Draining:  h.draining.Load(),
+		HandedOff: h.handedOff.Load(),
+		Sessions:  len(h.sessions()),
+	}
+
+	h.listenersLock.Lock()
+	for _, l := range h.listeners {
+		status.Listeners = append(status.Listeners, control.Listener{
+			Network:   l.config.Network,
+			Address:   l.config.Address,
+			TLS:       l.config.TLS.Enable,
+			Frontends: l.config.Frontends,
+		})
+	}
+	h.listenersLock.Unlock()
+
+	if inst := h.inst.Load(); inst != nil {
+		for host := range inst.vhosts {
+			status.VirtualHosts = append(status.VirtualHosts, host)
+		}
+		slices.Sort(status.VirtualHosts)
+	}
+	return status
+}
+
+func (h *Hub) sessions() []control.Session {
+	h.registriesLock.Lock()
+	defer h.registriesLock.Unlock()
+
+	var sessions []control.Session
+	for key, registry := range h.wsfsRegistries {
+		for _, info := range registry.Sessions() {
+			sessions = append(sessions, control.Session{
+				VirtualHost: key,
+				Id:          info.Id,
+				Username:    info.Username,
+				RemoteAddr:  info.RemoteAddr,
+				Connected:   info.Connected,
+				OpenFiles:   info.OpenFiles,
+			})
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,145 @@
+// Package control implements the control socket of a running server. A
+// client sends one JSON request per connection and reads one JSON response.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CommandReload   = "reload"
+	CommandStatus   = "status"
+	CommandSessions = "sessions"
+	CommandDrain    = "drain"
+	CommandShutdown = "shutdown"
+	CommandConfig   = "config"
+	CommandTrace    = "trace"
+)
+
+const requestTimeout = 10 * time.Second
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+type Request struct {
+	Command string
+	Trace   *TraceRequest `json:",omitempty"`
+}
+
+// TraceRequest names either a user, whose sessions are traced, or a session
+// id.
+type TraceRequest struct {
+	Username string `json:",omitempty"`
+	Id       string `json:",omitempty"`
+	Duration int    `json:",omitempty"` // seconds; 0 means the configured duration
+}
+
+type Response struct {
+	Error    string       `json:",omitempty"`
+	Status   *Status      `json:",omitempty"`
+	Sessions []Session    `json:",omitempty"`
+	Trace    *TraceResult `json:",omitempty"`
+
+	// digests of the running config, see config.SectionDigests
+	ConfigSections map[string]string `json:",omitempty"`
+}
+
+type TraceResult struct {
+	Until    time.Time
+	Sessions int // running sessions traced
+}
+
+type Status struct {
+	Pid          int
+	Version      string
+	StartTime    time.Time
+	Draining     bool
+	HandedOff    bool
+	Listeners    []Listener
+	VirtualHosts []string
+	Sessions     int
+}
+
+type Listener struct {
+	Network   string
+	Address   string
+	TLS       bool
+	Frontends []string
+}
+
+type Session struct {
+	VirtualHost string // empty for the top level config
+	Id          string
+	Username    string
+	RemoteAddr  string
+	Connected   bool
+	OpenFiles   int
+}
+
+// Serve handles connections until the listener is closed. Connections are
+// served concurrently, so that a shutdown can be sent while a drain waits;
+// the handler serializes reloads itself.
+func Serve(listener net.Listener, handle func(Request) Response) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Msg("Control socket stopped")
+			}
+			return
+		}
+		go serveConn(conn, handle)
+	}
+}
+
+func serveConn(conn net.Conn, handle func(Request) Response) {
+	defer conn.Close()
+
+	var req Request
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		log.Warn().Err(err).Msg("Bad control request")
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	log.Info().Str("Command", req.Command).Msg("Control request")
+	rsp := handle(req)
+	_ = conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewEncoder(conn).Encode(rsp); err != nil {
+		log.Warn().Err(err).Msg("Unable to write control response")
+	}
+}
+
+// Call sends a command to the control socket at path and waits for the
+// result. A failed command is returned as an error.
+func Call(path string, command string) (Response, error) {
+	return CallRequest(path, Request{Command: command})
+}
+
+// CallRequest is Call for commands taking arguments.
+func CallRequest(path string, req Request) (Response, error) {
+	var rsp Response
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return rsp, err
+	}
+	defer conn.Close()
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return rsp, err
+	}
+	if err = json.NewDecoder(conn).Decode(&rsp); err != nil {
+		return rsp, err
+	}
+	if rsp.Error != "" {
+		return rsp, errors.New(rsp.Error)
+	}
+	return rsp, nil
+}
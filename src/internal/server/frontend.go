@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type frontendSet uint8
+
+const (
+	frontendWSFS frontendSet = 1 << iota
+	frontendWebdav
+	frontendWebui
+
+	frontendAll = frontendWSFS | frontendWebdav | frontendWebui
+)
+
+var frontendNames = map[string]frontendSet{
+	"wsfs":   frontendWSFS,
+	"webdav": frontendWebdav,
+	"webui":  frontendWebui,
+}
+
+var ErrWebuiWithoutWebdav = errors.New("webui frontend enabled but webdav frontend disabled")
+
+// empty names means all frontends
+func parseFrontends(names []string) (frontendSet, error) {
+	if len(names) == 0 {
+		return frontendAll, nil
+	}
+
+	var set frontendSet
+	for _, name := range names {
+		f, ok := frontendNames[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown frontend: %q", name)
+		}
+		set |= f
+	}
+
+	if set.has(frontendWebui) && !set.has(frontendWebdav) {
+		return 0, ErrWebuiWithoutWebdav
+	}
+	return set, nil
+}
+
+func (s frontendSet) has(f frontendSet) bool {
+	return s&f != 0
+}
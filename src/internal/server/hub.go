@@ -3,18 +3,65 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"wsfs-core/internal/server/config"
 	"wsfs-core/internal/server/wsfs"
+	"wsfs-core/internal/util"
 
 	"github.com/rs/zerolog/log"
 )
 
+var (
+	ErrHandedOff = errors.New("listeners have been handed off")
+	ErrDraining  = errors.New("server is draining")
+
+	ErrBadShutdown = errors.New("shutdown timeout and retry after can not be negative")
+)
+
 type instance struct {
+	config config.Server
 	server *Server
+	vhosts map[string]*Server // by normalized host name
+
+	// applied to the hub by commitInstance
+	trace       *wsfs.TraceConfig
+	registries  map[string]*wsfs.SessionRegistry // by virtual host key
+	wsfsConfigs map[string]config.WSFS
+	created     map[string]bool // registries not in the hub yet
+}
+
+func (inst *instance) serverFor(host string) *Server {
+	if server, ok := inst.vhosts[config.NormalizeHost(host)]; ok {
+		return server
+	}
+	return inst.server
+}
+
+type hubListener struct {
+	hub        *Hub
+	config     config.Listener
+	listener   net.Listener
+	certs      *certStore
+	httpServer *http.Server
+
+	// options below can be changed by reload without listening again
+	frontends    atomic.Uint32
+	proxyTrusted atomic.Pointer[[]netip.Prefix]
+	peerAuth     atomic.Bool
+}
+
+type listenerOptions struct {
+	frontends    frontendSet
+	proxyTrusted []netip.Prefix // nil if PROXY protocol is disabled
+	peerAuth     bool
 }
 
 type Hub struct {
@@ -22,76 +69,247 @@ type Hub struct {
 
 	inst atomic.Pointer[instance]
 
-	listenerConfig config.Listener
-	listener       net.Listener
-	httpServer     *http.Server
-	exitErrorChan  chan error
+	listeners     []*hubListener
+	listenersLock sync.Mutex
+	exitErrorChan chan error
 
 	lock            sync.Mutex
 	reloadReentrant atomic.Bool
-	wsfsRegistry    atomic.Pointer[wsfs.SessionRegistry]
+	handedOff       atomic.Bool
+	draining        atomic.Bool
+	startTime       time.Time
+	control         net.Listener
+	controlPath     string
+
+	// by virtual host key, "" for the top level config
+	wsfsRegistries map[string]*wsfs.SessionRegistry
+	registriesLock sync.Mutex
+	restoreLock    sync.Mutex
+	tracer         *wsfs.Tracer
 }
 
 func NewHub() (h *Hub, err error) {
 	h = new(Hub)
 	h.exitErrorChan = make(chan error, 1)
+	h.startTime = time.Now()
+	h.wsfsRegistries = make(map[string]*wsfs.SessionRegistry)
+	h.tracer = wsfs.NewTracer()
 	return
 }
 
-func (h *Hub) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
-	inst := h.inst.Load()
+func (l *hubListener) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	inst := l.hub.inst.Load()
 	if inst == nil || inst.server == nil {
 		http.Error(rsp, "server unavailable", http.StatusServiceUnavailable)
 		return
 	}
-	inst.server.ServeHTTP(rsp, req)
+	inst.serverFor(req.Host).serveFrontends(rsp, req, frontendSet(l.frontends.Load()))
 }
 
-func (h *Hub) Run(c config.Server) error {
-	registry := h.ensureWSFSRegistry(c.WSFS)
+func (h *Hub) exit(err error) {
+	select {
+	case h.exitErrorChan <- err:
+	default:
+	}
+}
 
-	server, err := NewServer(c, registry)
+func (h *Hub) Run(c config.Server) error {
+	opts, err := parseListenerOptions(c.Listeners)
 	if err != nil {
 		return err
 	}
-	h.inst.Store(&instance{server: server})
 
-	listener, tlsConfig, err := listen(c.Listener)
+	inst, err := h.newInstance(c)
 	if err != nil {
 		return err
 	}
-	h.listener = listener
-	h.listenerConfig = c.Listener
+	h.commitInstance(inst)
+	h.inst.Store(inst)
+	h.restoreSessions()
+
+	listeners := make([]*hubListener, 0, len(c.Listeners))
+	for i, lc := range c.Listeners {
+		l, err := h.startListener(lc, opts[i])
+		if err != nil {
+			h.stopListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	h.listenersLock.Lock()
+	h.listeners = listeners
+	h.listenersLock.Unlock()
+
+	if c.Control.Socket != "" {
+		if err = h.startControl(c.Control); err != nil {
+			h.stopListeners(listeners)
+			return err
+		}
+	}
+	notifyUpgradeReady()
+
+	err = <-h.exitErrorChan
+	h.stopControl()
+
+	h.listenersLock.Lock()
+	for _, l := range h.listeners {
+		if !h.handedOff.Load() {
+			cleanListen(l.config)
+		}
+		if l.certs != nil {
+			l.certs.stop()
+		}
+	}
+	h.listenersLock.Unlock()
+
+	h.registriesLock.Lock()
+	for _, registry := range h.wsfsRegistries {
+		registry.Stop()
+	}
+	h.registriesLock.Unlock()
+	h.tracer.Close()
+	return err
+}
 
-	httpServer := &http.Server{Handler: h}
-	if c.Listener.TLS.Enable {
-		httpServer.TLSConfig = tlsConfig
+func (h *Hub) startListener(c config.Listener, opts listenerOptions) (*hubListener, error) {
+	listener, certs, err := listen(c)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s %q: %w", c.Network, c.Address, err)
 	}
-	h.httpServer = httpServer
 
-	log.Warn().Str("Net", c.Listener.Network).Str("Addr", c.Listener.Address).Msg("Listening")
+	l := &hubListener{hub: h, config: c, listener: listener, certs: certs}
+	l.setOptions(opts)
+	l.httpServer = &http.Server{Handler: l, ConnContext: l.connContext}
+	if c.TLS.Enable {
+		l.httpServer.TLSConfig = certs.tlsConfig()
+	}
 
+	logListening(c)
 	go func() {
 		var serveErr error
-		if c.Listener.TLS.Enable {
-			serveErr = httpServer.ServeTLS(listener, "", "")
+		proxyListener := &proxyListener{Listener: listener, trusted: &l.proxyTrusted}
+		if c.TLS.Enable {
+			serveErr = l.httpServer.ServeTLS(proxyListener, "", "")
 		} else {
-			serveErr = httpServer.Serve(listener)
+			serveErr = l.httpServer.Serve(proxyListener)
 		}
-		if serveErr != nil {
-			h.exitErrorChan <- serveErr
+		if !errors.Is(serveErr, http.ErrServerClosed) {
+			h.exit(serveErr)
 		}
 	}()
+	return l, nil
+}
 
-	err = <-h.exitErrorChan
-	cleanListen(c.Listener)
-	if registry := h.wsfsRegistry.Load(); registry != nil {
-		registry.Stop()
+// stopListeners waits for in-flight requests and removes sock files.
+func (h *Hub) stopListeners(listeners []*hubListener) {
+	for _, l := range listeners {
+		// Intentionally wait without a deadline so in-flight requests can finish and
+		// long-lived wsfs sessions can survive a listener reload.
+		// TODO: Emit a warning if shutdown remains blocked for too long.
+		err := l.httpServer.Shutdown(context.Background())
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Old server shutdown failed")
+		}
+		cleanListen(l.config)
+		if l.certs != nil {
+			l.certs.stop()
+		}
+		log.Warn().Str("Net", l.config.Network).Str("Addr", l.config.Address).Msg("Stopped listening")
 	}
-	return err
+}
+
+func logListening(c config.Listener) {
+	e := log.Warn().Str("Net", c.Network).Str("Addr", c.Address)
+	if len(c.Frontends) != 0 {
+		e = e.Str("Frontends", strings.Join(c.Frontends, ","))
+	}
+	e.Msg("Listening")
+}
+
+func (l *hubListener) setOptions(opts listenerOptions) {
+	l.frontends.Store(uint32(opts.frontends))
+	if opts.proxyTrusted != nil {
+		l.proxyTrusted.Store(&opts.proxyTrusted)
+	} else {
+		l.proxyTrusted.Store(nil)
+	}
+	l.peerAuth.Store(opts.peerAuth)
+}
+
+func parseListenerOptions(listeners []config.Listener) ([]listenerOptions, error) {
+	if len(listeners) == 0 {
+		return nil, errors.New("no listener")
+	}
+
+	opts := make([]listenerOptions, len(listeners))
+	for i, l := range listeners {
+		if err := checkListenerDuplicate(listeners[:i], l); err != nil {
+			return nil, err
+		}
+
+		var err error
+		opts[i], err = parseListenerOption(l)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s %q: %w", l.Network, l.Address, err)
+		}
+	}
+	return opts, nil
+}
+
+func checkListenerDuplicate(others []config.Listener, l config.Listener) error {
+	for _, other := range others {
+		if other.Network == l.Network && other.Address == l.Address {
+			return fmt.Errorf("duplicate listener: %s %q", l.Network, l.Address)
+		}
+	}
+	return nil
+}
+
+func parseListenerOption(l config.Listener) (opts listenerOptions, err error) {
+	opts.frontends, err = parseFrontends(l.Frontends)
+	if err != nil {
+		return
+	}
+
+	if l.ProxyProtocol.Enable {
+		opts.proxyTrusted, err = util.ParseCIDRs(l.ProxyProtocol.TrustedCIDRs)
+		if err != nil {
+			return
+		}
+		if len(opts.proxyTrusted) == 0 && l.Network != "unix" {
+			err = errors.New("PROXY protocol enabled without trusted CIDRs")
+			return
+		}
+	}
+
+	if l.Network == "unix" {
+		if _, err = parseSocketPermission(l); err != nil {
+			return
+		}
+	}
+	if l.PeerAuth && l.Network != "unix" {
+		err = errors.New("PeerAuth requires a unix socket")
+		return
+	}
+	opts.peerAuth = l.PeerAuth
+	return
+}
+
+func (h *Hub) reloadRefused() error {
+	if h.handedOff.Load() {
+		return ErrHandedOff
+	}
+	if h.draining.Load() {
+		return ErrDraining
+	}
+	return nil
 }
 
 func (h *Hub) IssueReload() {
+	if err := h.reloadRefused(); err != nil {
+		log.Warn().Err(err).Msg("Reload ignored")
+		return
+	}
 	if !h.lock.TryLock() {
 		log.Warn().Msg("Reload has been postponed: Server is in reloading")
 		h.reloadReentrant.Store(true)
@@ -99,127 +317,365 @@ func (h *Hub) IssueReload() {
 	}
 	log.Warn().Msg("Reloading")
 
-	go h.doReload()
+	go func() {
+		defer h.reloadDone()
+		_ = h.doReload()
+	}()
 }
 
-func (h *Hub) doReload() {
-	defer func() {
-		err := recover()
-		if err != nil {
-			log.Error().Any("Error", err).Msg("Panic during reloading")
-		}
+// Reload waits for any reload in progress, then reloads and returns the
+// result.
+func (h *Hub) Reload() error {
+	if err := h.reloadRefused(); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.reloadDone()
+	log.Warn().Msg("Reloading")
 
-		h.lock.Unlock()
-		if h.reloadReentrant.CompareAndSwap(true, false) {
-			h.IssueReload()
+	return h.doReload()
+}
+
+func (h *Hub) reloadDone() {
+	h.lock.Unlock()
+	if h.reloadReentrant.CompareAndSwap(true, false) {
+		h.IssueReload()
+	}
+}
+
+func (h *Hub) doReload() (err error) {
+	defer func() {
+		if obj := recover(); obj != nil {
+			log.Error().Any("Error", obj).Msg("Panic during reloading")
+			err = fmt.Errorf("panic during reloading: %v", obj)
 		}
 	}()
 
 	conf, err := h.GetConfig()
 	if err != nil {
 		log.Error().Err(err).Msg("Reload failed: Unable to decode new config")
-		return
+		return fmt.Errorf("unable to decode new config: %w", err)
 	}
 
-	registry := h.ensureWSFSRegistry(conf.WSFS)
+	opts, err := parseListenerOptions(conf.Listeners)
+	if err != nil {
+		log.Error().Err(err).Msg("Reload failed: Bad listener config")
+		return fmt.Errorf("bad listener config: %w", err)
+	}
 
-	server, err := NewServer(conf, registry)
+	inst, err := h.newInstance(conf)
 	if err != nil {
 		log.Error().Err(err).Msg("Reload failed: Unable to new server")
-		return
+		return fmt.Errorf("unable to new server: %w", err)
 	}
 
-	if listenerEquals(h.listenerConfig, conf.Listener) {
-		h.inst.Store(&instance{server: server})
-		log.Warn().Msg("Reloaded")
-		return
+	h.listenersLock.Lock()
+	oldListeners := h.listeners
+	h.listenersLock.Unlock()
+
+	// keep unchanged listeners, start new ones before stopping removed ones
+	listeners := make([]*hubListener, len(conf.Listeners))
+	var started []*hubListener
+	kept := make(map[*hubListener]bool)
+	for i, lc := range conf.Listeners {
+		for _, old := range oldListeners {
+			if !kept[old] && listenerEquals(old.config, lc) {
+				listeners[i] = old
+				kept[old] = true
+				break
+			}
+		}
+		if listeners[i] != nil {
+			continue
+		}
+
+		l, err := h.startListener(lc, opts[i])
+		if err != nil {
+			log.Error().Err(err).Msg("Reload failed: Unable to listen on new config")
+			h.stopListeners(started)
+			h.discardInstance(inst)
+			return err
+		}
+		listeners[i] = l
+		started = append(started, l)
 	}
 
-	listener, tlsConfig, err := listen(conf.Listener)
-	if err != nil {
-		log.Error().Err(err).Msg("Reload failed: Unable to listen on new config")
-		return
+	var removed []*hubListener
+	for _, old := range oldListeners {
+		if !kept[old] {
+			removed = append(removed, old)
+		}
 	}
 
-	oldHTTPServer := h.httpServer
-	oldListenerConfig := h.listenerConfig
+	// only written by reloads, but read by others under listenersLock
+	configs := make([]config.Listener, len(listeners))
+	for i, l := range listeners {
+		lc := conf.Listeners[i]
+		if kept[l] && !socketPermissionEquals(l.config, lc) {
+			if err := applySocketPermission(lc); err != nil {
+				log.Error().Err(err).Str("Addr", lc.Address).Msg("Unable to change socket permission")
+			}
+		}
+		configs[i] = l.config
+		configs[i].Frontends = lc.Frontends
+		configs[i].ProxyProtocol = lc.ProxyProtocol
+		configs[i].SocketMode = lc.SocketMode
+		configs[i].SocketUser = lc.SocketUser
+		configs[i].SocketGroup = lc.SocketGroup
+		configs[i].PeerAuth = lc.PeerAuth
+		l.setOptions(opts[i])
+	}
+	h.commitInstance(inst)
+	h.inst.Store(inst)
+	h.listenersLock.Lock()
+	for i, l := range listeners {
+		l.config = configs[i]
+	}
+	h.listeners = listeners
+	h.listenersLock.Unlock()
+
+	h.stopListeners(removed)
+	h.pruneWSFSRegistries(conf)
+
+	log.Warn().Msg("Reloaded")
+	return nil
+}
 
-	newHTTPServer := &http.Server{Handler: h}
-	if conf.Listener.TLS.Enable {
-		newHTTPServer.TLSConfig = tlsConfig
+// IssueUpgrade hands the listeners off to a new process started from the
+// current executable, then shuts down once the new process is serving.
+func (h *Hub) IssueUpgrade() {
+	if !h.lock.TryLock() {
+		log.Error().Msg("Upgrade refused: Server is in reloading")
+		return
 	}
+	log.Warn().Msg("Upgrading")
 
-	h.inst.Store(&instance{server: server})
-	h.listener = listener
-	h.listenerConfig = conf.Listener
-	h.httpServer = newHTTPServer
+	go h.doUpgrade()
+}
 
-	log.Warn().Str("Net", conf.Listener.Network).Str("Addr", conf.Listener.Address).Msg("Listening")
-	go func() {
-		var serveErr error
-		if conf.Listener.TLS.Enable {
-			serveErr = newHTTPServer.ServeTLS(listener, "", "")
-		} else {
-			serveErr = newHTTPServer.Serve(listener)
+func (h *Hub) doUpgrade() {
+	handedOff := false
+	defer func() {
+		err := recover()
+		if err != nil {
+			log.Error().Any("Error", err).Msg("Panic during upgrading")
 		}
-		if serveErr != nil {
-			h.exitErrorChan <- serveErr
+
+		h.lock.Unlock()
+		if handedOff {
+			h.IssueShutdown()
 		}
 	}()
 
-	// Intentionally wait without a deadline so in-flight requests can finish and
-	// long-lived wsfs sessions can survive a listener reload.
-	// TODO: Emit a warning if shutdown remains blocked for too long.
-	shutdownErr := oldHTTPServer.Shutdown(context.Background())
-	if shutdownErr != nil && !errors.Is(shutdownErr, http.ErrServerClosed) {
-		log.Error().Err(shutdownErr).Msg("Old server shutdown failed")
+	h.listenersLock.Lock()
+	configs := make([]config.Listener, len(h.listeners))
+	listeners := make([]net.Listener, len(h.listeners))
+	for i, l := range h.listeners {
+		configs[i] = l.config
+		listeners[i] = l.listener
 	}
-	cleanListen(oldListenerConfig)
+	h.listenersLock.Unlock()
 
-	log.Warn().Msg("Reloaded")
+	err := startUpgrade(configs, listeners)
+	if err != nil {
+		log.Error().Err(err).Msg("Upgrade failed")
+		return
+	}
+
+	for _, listener := range listeners {
+		keepSocketFile(listener)
+	}
+	h.handedOff.Store(true)
+	handedOff = true
+	log.Warn().Msg("Listeners handed off, draining")
 }
 
+// IssueShutdown stops the listeners and closes the WSFS sessions, telling
+// clients when to resume. Connections still open after Shutdown.Timeout are
+// closed forcibly.
 func (h *Hub) IssueShutdown() {
 	log.Warn().Msg("Shutting down")
-	if h.httpServer != nil {
-		// Intentionally wait without a deadline so active wsfs sessions are not
-		// force-terminated during hub shutdown.
-		// TODO: Emit a warning if shutdown remains blocked for too long.
-		err := h.httpServer.Shutdown(context.Background())
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Error().Err(err).Msg("Server shutdown failed")
+
+	var c config.Shutdown
+	if inst := h.inst.Load(); inst != nil {
+		c = inst.config.Shutdown
+	}
+	retryAfter := time.Duration(c.RetryAfter) * time.Second
+	if h.handedOff.Load() {
+		// the new process is serving already, but has to wait for the
+		// sessions saved below
+		retryAfter = 0
+		if h.sessionStateFile() != "" {
+			retryAfter = time.Second
 		}
 	}
-	if registry := h.wsfsRegistry.Load(); registry != nil {
-		registry.Stop()
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	h.listenersLock.Lock()
+	listeners := h.listeners
+	h.listenersLock.Unlock()
+
+	h.registriesLock.Lock()
+	registries := make([]*wsfs.SessionRegistry, 0, len(h.wsfsRegistries))
+	for _, registry := range h.wsfsRegistries {
+		registries = append(registries, registry)
+	}
+	h.registriesLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Go(func() {
+			err := l.httpServer.Shutdown(ctx)
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Warn().Str("Addr", l.config.Address).Msg("Shutdown timed out, closing connections")
+				err = l.httpServer.Close()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Msg("Server shutdown failed")
+			}
+		})
 	}
+
+	// sessions are saved as soon as they are hibernated, since clients may
+	// resume them before the listeners are shut down
+	var sessionsWg sync.WaitGroup
+	for _, registry := range registries {
+		sessionsWg.Go(func() { registry.GoAway(ctx, retryAfter) })
+	}
+	sessionsWg.Wait()
+	h.saveSessions()
+
+	wg.Wait()
+	h.exit(http.ErrServerClosed)
 }
 
-func (h *Hub) ensureWSFSRegistry(c config.WSFS) *wsfs.SessionRegistry {
+// newInstance creates the servers of the top level config and its virtual
+// hosts. Each virtual host gets its own session registry. Nothing is applied
+// to the hub until commitInstance; discardInstance drops what was created.
+func (h *Hub) newInstance(c config.Server) (inst *instance, err error) {
+	if c.Shutdown.Timeout < 0 || c.Shutdown.RetryAfter < 0 {
+		return nil, ErrBadShutdown
+	}
+	trace, err := h.tracer.Prepare(c.Trace)
+	if err != nil {
+		return nil, err
+	}
+
+	inst = &instance{
+		config:      c,
+		vhosts:      make(map[string]*Server),
+		trace:       trace,
+		registries:  make(map[string]*wsfs.SessionRegistry),
+		wsfsConfigs: make(map[string]config.WSFS),
+		created:     make(map[string]bool),
+	}
+	defer func() {
+		if err != nil {
+			h.discardInstance(inst)
+			inst = nil
+		}
+	}()
+
+	inst.server, err = NewServer(c, h.wsfsRegistryFor(inst, "", c.WSFS))
+	if err != nil {
+		return
+	}
+	for i := range c.VirtualHosts {
+		vhost := &c.VirtualHosts[i]
+		server, err := NewServer(vhost.Server, h.wsfsRegistryFor(inst, vhost.Key(), vhost.Server.WSFS))
+		if err != nil {
+			return inst, fmt.Errorf("virtual host %q: %w", vhost.Key(), err)
+		}
+		for _, host := range vhost.Hosts {
+			inst.vhosts[host] = server
+		}
+	}
+	return inst, nil
+}
+
+// wsfsRegistryFor returns the registry of key for inst, which is a new one
+// if the hub has none yet.
+func (h *Hub) wsfsRegistryFor(inst *instance, key string, c config.WSFS) *wsfs.SessionRegistry {
 	if !c.Enable {
 		return nil
 	}
 
-	if registry := h.wsfsRegistry.Load(); registry != nil {
-		registry.Reconfigure(c)
-		return registry
+	h.registriesLock.Lock()
+	registry, ok := h.wsfsRegistries[key]
+	h.registriesLock.Unlock()
+	if !ok {
+		registry = wsfs.NewSessionRegistry(c)
+		registry.OnUnknownSession(h.restoreSessions)
+		registry.SetTracer(h.tracer)
+		inst.created[key] = true
+	}
+	inst.registries[key] = registry
+	inst.wsfsConfigs[key] = c
+	return registry
+}
+
+// commitInstance applies the trace and registry configs of inst, and
+// registers its new registries.
+func (h *Hub) commitInstance(inst *instance) {
+	h.tracer.Reconfigure(inst.trace)
+
+	h.registriesLock.Lock()
+	for key, registry := range inst.registries {
+		if inst.created[key] {
+			h.wsfsRegistries[key] = registry
+			go registry.CollectInactiveSessions()
+			continue
+		}
+		registry.Reconfigure(inst.wsfsConfigs[key])
+	}
+	h.registriesLock.Unlock()
+	h.applyTrace()
+}
+
+// discardInstance drops an instance which is not committed.
+func (h *Hub) discardInstance(inst *instance) {
+	inst.trace.Close()
+	for key := range inst.created {
+		inst.registries[key].Stop()
 	}
+}
 
-	registry := wsfs.NewSessionRegistry(c)
-	if h.wsfsRegistry.CompareAndSwap(nil, registry) {
-		go registry.CollectInactiveSessions()
-		return registry
+// pruneWSFSRegistries stops the registries of removed virtual hosts.
+func (h *Hub) pruneWSFSRegistries(c config.Server) {
+	keys := map[string]bool{"": true}
+	for i := range c.VirtualHosts {
+		keys[c.VirtualHosts[i].Key()] = true
 	}
 
-	registry = h.wsfsRegistry.Load()
-	registry.Reconfigure(c)
-	return registry
+	h.registriesLock.Lock()
+	defer h.registriesLock.Unlock()
+	for key, registry := range h.wsfsRegistries {
+		if !keys[key] {
+			registry.Stop()
+			delete(h.wsfsRegistries, key)
+		}
+	}
 }
 
+// Frontends, ProxyProtocol and socket options are not compared; they are
+// updated in place.
 func listenerEquals(a, b config.Listener) bool {
 	return a.Network == b.Network &&
 		a.Address == b.Address &&
 		a.TLS.Enable == b.TLS.Enable &&
+		a.TLS.SelfSigned == b.TLS.SelfSigned &&
 		a.TLS.CertFile == b.TLS.CertFile &&
-		a.TLS.KeyFile == b.TLS.KeyFile
+		a.TLS.KeyFile == b.TLS.KeyFile &&
+		slices.Equal(a.TLS.Certificates, b.TLS.Certificates)
+}
+
+func socketPermissionEquals(a, b config.Listener) bool {
+	return a.SocketMode == b.SocketMode &&
+		a.SocketUser == b.SocketUser &&
+		a.SocketGroup == b.SocketGroup
 }
@@ -0,0 +1,40 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"net"
+	"wsfs-core/internal/server/config"
+)
+
+var (
+	ErrNoUpgradeHandoff = errors.New("no upgrade handoff from a running server")
+	ErrUpgradeNotReady  = errors.New("new process exited before it was ready")
+
+	errInheritUnsupported = errors.New("inherited listeners are only supported on Unix")
+)
+
+func systemdListener(string) (net.Listener, error) {
+	return nil, errInheritUnsupported
+}
+
+func fdListener(string) (net.Listener, error) {
+	return nil, errInheritUnsupported
+}
+
+func AcceptUpgrade() error {
+	return errInheritUnsupported
+}
+
+func takeUpgradeListener(config.Listener) (net.Listener, bool, error) {
+	return nil, false, nil
+}
+
+func notifyUpgradeReady() {}
+
+func startUpgrade([]config.Listener, []net.Listener) error {
+	return errInheritUnsupported
+}
+
+func keepSocketFile(net.Listener) {}
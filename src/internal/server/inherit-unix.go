@@ -0,0 +1,322 @@
+//go:build unix
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"wsfs-core/internal/server/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// first fd passed by systemd and by os/exec ExtraFiles
+	inheritedFdStart = 3
+
+	upgradeEnv          = "WSFS_UPGRADE"
+	upgradeReadyTimeout = 1 * time.Minute
+)
+
+var (
+	ErrNoUpgradeHandoff = errors.New("no upgrade handoff from a running server")
+	ErrUpgradeNotReady  = errors.New("new process exited before it was ready")
+)
+
+type inheritedListener struct {
+	name string
+	file *os.File
+}
+
+// Inherited files are kept open for the whole process lifetime, so the same
+// socket can be listened on again after a reload switches back to it.
+var inherited struct {
+	lock sync.Mutex
+
+	systemdOnce sync.Once
+	systemd     []inheritedListener
+	fds         map[int]*os.File
+
+	upgrade      map[string]*os.File
+	upgradeReady *os.File
+}
+
+type upgradeHandoff struct {
+	ReadyFd   int
+	Listeners []upgradeHandoffListener
+}
+
+type upgradeHandoffListener struct {
+	Network string
+	Address string
+	Fd      int
+}
+
+func listenerKey(c config.Listener) string {
+	return c.Network + " " + c.Address
+}
+
+func loadSystemdListeners() {
+	inherited.systemdOnce.Do(func() {
+		defer os.Unsetenv("LISTEN_PID")
+		defer os.Unsetenv("LISTEN_FDS")
+		defer os.Unsetenv("LISTEN_FDNAMES")
+
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+		for i := range count {
+			fd := inheritedFdStart + i
+			syscall.CloseOnExec(fd)
+			name := "unknown"
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			inherited.systemd = append(inherited.systemd, inheritedListener{
+				name: name,
+				file: os.NewFile(uintptr(fd), "systemd:"+name),
+			})
+		}
+	})
+}
+
+// Empty name selects the first socket passed by systemd.
+func systemdListener(name string) (net.Listener, error) {
+	loadSystemdListeners()
+
+	for _, l := range inherited.systemd {
+		if name == "" || l.name == name {
+			return net.FileListener(l.file)
+		}
+	}
+	if name == "" {
+		return nil, errors.New("no socket passed by systemd")
+	}
+	return nil, fmt.Errorf("no socket named %q passed by systemd", name)
+}
+
+func fdListener(address string) (net.Listener, error) {
+	fd, err := strconv.Atoi(address)
+	if err != nil || fd < inheritedFdStart {
+		return nil, fmt.Errorf("bad inherited fd: %q", address)
+	}
+
+	inherited.lock.Lock()
+	defer inherited.lock.Unlock()
+
+	file, ok := inherited.fds[fd]
+	if !ok {
+		syscall.CloseOnExec(fd)
+		file = os.NewFile(uintptr(fd), "fd:"+address)
+		if inherited.fds == nil {
+			inherited.fds = make(map[int]*os.File)
+		}
+		inherited.fds[fd] = file
+	}
+	return net.FileListener(file)
+}
+
+// AcceptUpgrade takes over the listeners passed by a server that is being
+// upgraded. They are used in place of new listeners with the same config.
+func AcceptUpgrade() error {
+	env, ok := os.LookupEnv(upgradeEnv)
+	if !ok {
+		return ErrNoUpgradeHandoff
+	}
+	os.Unsetenv(upgradeEnv)
+
+	var handoff upgradeHandoff
+	if err := json.Unmarshal([]byte(env), &handoff); err != nil {
+		return fmt.Errorf("bad upgrade handoff: %w", err)
+	}
+
+	inherited.lock.Lock()
+	defer inherited.lock.Unlock()
+
+	syscall.CloseOnExec(handoff.ReadyFd)
+	inherited.upgradeReady = os.NewFile(uintptr(handoff.ReadyFd), "upgrade-ready")
+	inherited.upgrade = make(map[string]*os.File, len(handoff.Listeners))
+	for _, l := range handoff.Listeners {
+		syscall.CloseOnExec(l.Fd)
+		inherited.upgrade[listenerKey(config.Listener{Network: l.Network, Address: l.Address})] =
+			os.NewFile(uintptr(l.Fd), "upgrade:"+l.Network+":"+l.Address)
+	}
+	return nil
+}
+
+func takeUpgradeListener(c config.Listener) (net.Listener, bool, error) {
+	inherited.lock.Lock()
+	defer inherited.lock.Unlock()
+
+	file, ok := inherited.upgrade[listenerKey(c)]
+	if !ok {
+		return nil, false, nil
+	}
+	delete(inherited.upgrade, listenerKey(c))
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	return listener, true, err
+}
+
+// notifyUpgradeReady tells the old server that we are serving, so it can
+// stop accepting and drain. Unused handed off listeners are closed.
+func notifyUpgradeReady() {
+	inherited.lock.Lock()
+	defer inherited.lock.Unlock()
+
+	for key, file := range inherited.upgrade {
+		log.Warn().Str("Listener", key).Msg("Handed off listener is not used")
+		file.Close()
+	}
+	inherited.upgrade = nil
+
+	if inherited.upgradeReady == nil {
+		sdNotify("READY=1")
+		return
+	}
+	// systemd must follow the new process before the old one exits
+	sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid()))
+	_, err := inherited.upgradeReady.Write([]byte{1})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to notify old server")
+	}
+	inherited.upgradeReady.Close()
+	inherited.upgradeReady = nil
+}
+
+// sdNotify sends a state to systemd, if the service has Type=notify. The
+// new process of an upgrade needs NotifyAccess=all to be heard.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		// abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to notify systemd")
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Error().Err(err).Msg("Unable to notify systemd")
+	}
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// startUpgrade starts the current executable with the same arguments plus
+// --upgrade, passes the listeners to it and waits until it is serving.
+func startUpgrade(configs []config.Listener, listeners []net.Listener) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+
+	files := []*os.File{readyWriter}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	handoff := upgradeHandoff{ReadyFd: inheritedFdStart}
+	for i, listener := range listeners {
+		fl, ok := listener.(fileListener)
+		if !ok {
+			return fmt.Errorf("listener %q can not be handed off", listenerKey(configs[i]))
+		}
+		file, err := fl.File()
+		if err != nil {
+			return err
+		}
+		handoff.Listeners = append(handoff.Listeners, upgradeHandoffListener{
+			Network: configs[i].Network,
+			Address: configs[i].Address,
+			Fd:      inheritedFdStart + len(files),
+		})
+		files = append(files, file)
+	}
+	env, err := json.Marshal(handoff)
+	if err != nil {
+		return err
+	}
+
+	args := os.Args[1:]
+	if !upgradeArgPresent(args) {
+		args = append(args, "--upgrade")
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), upgradeEnv+"="+string(env))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	log.Warn().Int("Pid", cmd.Process.Pid).Msg("New process started")
+
+	for _, file := range files {
+		file.Close()
+	}
+	files = nil
+
+	readyReader.SetReadDeadline(time.Now().Add(upgradeReadyTimeout))
+	_, err = io.ReadFull(readyReader, make([]byte, 1))
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if errors.Is(err, io.EOF) {
+			return ErrUpgradeNotReady
+		}
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func upgradeArgPresent(args []string) bool {
+	for _, arg := range args {
+		if arg == "--upgrade" {
+			return true
+		}
+	}
+	return false
+}
+
+// keepSocketFile stops a unix listener from removing its socket file on
+// close, since the file now belongs to the new process.
+func keepSocketFile(listener net.Listener) {
+	if ul, ok := listener.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
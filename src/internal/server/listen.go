@@ -1,31 +1,44 @@
 package server
 
 import (
-	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"wsfs-core/internal/server/config"
 
 	"github.com/rs/zerolog/log"
 )
 
-func readTLSKeyPair(tlsConfig config.TLS) (*tls.Config, error) {
-	log.Info().Msg("Loading TLS key pair")
-	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+// The returned certStore is nil if TLS is disabled.
+func listen(c config.Listener) (listener net.Listener, certs *certStore, err error) {
+	if c.TLS.Enable {
+		certs, err = newCertStore(c)
+		if err != nil {
+			return
+		}
+		defer func() {
+			if err != nil {
+				certs.stop()
+			}
+		}()
+	}
 
-	if err != nil {
-		return nil, err
-	} else {
-		return &tls.Config{
-			GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-				return &cert, nil
-			},
-		}, nil
+	listener, ok, err := takeUpgradeListener(c)
+	if ok || err != nil {
+		return
+	}
+
+	switch c.Network {
+	case "systemd":
+		listener, err = systemdListener(c.Address)
+		return
+	case "fd":
+		listener, err = fdListener(c.Address)
+		return
 	}
-}
 
-func listen(c config.Listener) (listener net.Listener, tlsConfig *tls.Config, err error) {
 	if c.Network == "unix" {
 		var fi os.FileInfo
 		fi, err = os.Stat(c.Address)
@@ -45,21 +58,132 @@ func listen(c config.Listener) (listener net.Listener, tlsConfig *tls.Config, er
 		}
 	}
 
-	if c.TLS.Enable {
-		tlsConfig, err = readTLSKeyPair(c.TLS)
+	if c.Network == "unix" && (c.SocketMode != "" || c.SocketUser != "" || c.SocketGroup != "") {
+		listener, err = listenUnixWithPermission(c)
+		return
+	}
+
+	listener, err = net.Listen(c.Network, c.Address)
+	return
+}
+
+// listenUnixWithPermission binds to a temporary path and renames it into
+// place after changing the mode and owner, so no client can connect before.
+func listenUnixWithPermission(c config.Listener) (net.Listener, error) {
+	perm, err := parseSocketPermission(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpPath := fmt.Sprintf("%s.%d.tmp", c.Address, os.Getpid())
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// the sock file is removed by cleanListen, under its final path
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = perm.apply(tmpPath)
+	if err == nil {
+		err = os.Rename(tmpPath, c.Address)
+	}
+	if err != nil {
+		listener.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	return listener, nil
+}
+
+type socketPermission struct {
+	mode     os.FileMode
+	hasMode  bool
+	uid, gid int // -1 means unchanged
+}
+
+func parseSocketPermission(c config.Listener) (p socketPermission, err error) {
+	p.uid, p.gid = -1, -1
+
+	if c.SocketMode != "" {
+		mode, parseErr := strconv.ParseUint(c.SocketMode, 8, 32)
+		if parseErr != nil || mode&^0777 != 0 {
+			err = fmt.Errorf("bad socket mode %q", c.SocketMode)
+			return
+		}
+		p.mode, p.hasMode = os.FileMode(mode), true
+	}
+
+	if c.SocketUser != "" {
+		p.uid, err = lookupId(c.SocketUser, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
 		if err != nil {
+			err = fmt.Errorf("socket user %q: %w", c.SocketUser, err)
 			return
 		}
 	}
 
-	listener, err = net.Listen(c.Network, c.Address)
+	if c.SocketGroup != "" {
+		p.gid, err = lookupId(c.SocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			err = fmt.Errorf("socket group %q: %w", c.SocketGroup, err)
+			return
+		}
+	}
 	return
 }
 
+// lookupId accepts a numeric id, or a name resolved by lookup.
+func lookupId(nameOrId string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.ParseUint(nameOrId, 10, 31); err == nil {
+		return int(id), nil
+	}
+	idStr, err := lookup(nameOrId)
+	if err != nil {
+		return -1, err
+	}
+	id, err := strconv.ParseUint(idStr, 10, 31)
+	return int(id), err
+}
+
+func (p socketPermission) apply(path string) error {
+	if p.uid != -1 || p.gid != -1 {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return err
+		}
+	}
+	if p.hasMode {
+		return os.Chmod(path, p.mode)
+	}
+	return nil
+}
+
+// applySocketPermission updates the sock file of a kept listener on reload.
+func applySocketPermission(c config.Listener) error {
+	if c.Network != "unix" {
+		return nil
+	}
+	perm, err := parseSocketPermission(c)
+	if err != nil {
+		return err
+	}
+	return perm.apply(c.Address)
+}
+
 func cleanListen(c config.Listener) {
 	if c.Network == "unix" {
 		err := os.Remove(c.Address)
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) {
 			log.Error().Err(err).Msg("Unable to remove sock file")
 		}
 	}
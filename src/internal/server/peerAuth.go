@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+type peerUidKey struct{}
+
+// connContext records the peer uid of unix socket connections on listeners
+// with PeerAuth enabled. Connections through the PROXY protocol are skipped,
+// since the peer is the proxy.
+func (l *hubListener) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if !l.peerAuth.Load() {
+		return ctx
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	uid, err := util.PeerUid(unixConn)
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to get peer credentials")
+		return ctx
+	}
+	return context.WithValue(ctx, peerUidKey{}, uid)
+}
+
+func peerUid(req *http.Request) (uint32, bool) {
+	uid, ok := req.Context().Value(peerUidKey{}).(uint32)
+	return uid, ok
+}
+
+func newPeerUsers(users storage.Users) (map[uint32]*storage.User, error) {
+	peerUsers := make(map[uint32]*storage.User)
+	for _, user := range users {
+		for _, uid := range user.PeerUids {
+			if other, ok := peerUsers[uid]; ok {
+				return nil, fmt.Errorf("peer uid %d used by both user %q and %q", uid, other.Name, user.Name)
+			}
+			peerUsers[uid] = user
+		}
+	}
+	return peerUsers, nil
+}
+
+// peerAuth is tried only when the request has no Authorization header.
+func (s *Server) peerAuth(req *http.Request) (*storage.User, error) {
+	uid, ok := peerUid(req)
+	if !ok {
+		return nil, ErrAuthHeaderNotExists
+	}
+	user, ok := s.peerUsers[uid]
+	if !ok {
+		log.Info().Uint32("Uid", uid).Msg("Peer uid not mapped to a user")
+		return nil, ErrAuthHeaderNotExists
+	}
+	if !user.Access.Permits(req.RemoteAddr) {
+		log.Info().Str("Name", user.Name).Str("From", req.RemoteAddr).Msg("User denied from address")
+		return nil, ErrAddressDenied
+	}
+	return user, nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	proxyHeaderTimeout = 10 * time.Second
+	proxyV1MaxLength   = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	ErrBadProxyHeader = errors.New("bad PROXY protocol header")
+)
+
+// proxyListener accepts the PROXY protocol header from trusted sources. A
+// nil trusted list means the PROXY protocol is disabled.
+type proxyListener struct {
+	net.Listener
+	trusted *atomic.Pointer[[]netip.Prefix]
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := l.trusted.Load()
+	if trusted == nil {
+		return conn, nil
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok &&
+		!util.PrefixesContain(*trusted, addr.AddrPort().Addr()) {
+		return conn, nil
+	}
+	// sources that are not IP (unix sockets) are trusted
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads the header on first use, in the goroutine serving the
+// connection rather than in the accept loop.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	headerErr  error
+}
+
+func (c *proxyConn) readHeaderOnce() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		addr, err := readProxyHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			log.Warn().Err(err).Str("From", c.remoteAddr.String()).Msg("Bad PROXY protocol header")
+			c.headerErr = err
+			return
+		}
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeaderOnce()
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeaderOnce()
+	return c.remoteAddr
+}
+
+// readProxyHeader returns nil address for LOCAL and UNKNOWN connections.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return readProxyHeaderV1(r)
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrBadProxyHeader
+		}
+	}
+
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, ErrBadProxyHeader
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrBadProxyHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrBadProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrBadProxyHeader
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadProxyHeader, err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, ErrBadProxyHeader
+	}
+	command := header[12] & 0xf
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrBadProxyHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, ErrBadProxyHeader
+		}
+		addr := netip.AddrFrom4([4]byte(body[0:4]))
+		port := binary.BigEndian.Uint16(body[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, ErrBadProxyHeader
+		}
+		addr := netip.AddrFrom16([16]byte(body[0:16]))
+		port := binary.BigEndian.Uint16(body[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+	default:
+		// UNSPEC, UDP and unix addresses are not useful as a client address
+		return nil, nil
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// proxyV2 builds a v2 header with a length field of length, followed by body.
+func proxyV2(command, family byte, length int, body []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(length))
+	return append(header, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tcp4Body := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0, 80}
+	tcp6Body := make([]byte, 36)
+	copy(tcp6Body, netip.MustParseAddr("2001:db8::1").AsSlice())
+	copy(tcp6Body[16:], netip.MustParseAddr("2001:db8::2").AsSlice())
+	binary.BigEndian.PutUint16(tcp6Body[32:], 12345)
+
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		addr   string // "" for no address
+		bad    bool
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 80\r\n"), addr: "192.0.2.1:12345"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 80\r\n"), addr: "[2001:db8::1]:12345"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 unknown with addresses", header: []byte("PROXY UNKNOWN ::1 ::1 1 2\r\n")},
+		{name: "v1 truncated", header: []byte("PROXY TCP4 192.0.2.1"), bad: true},
+		{name: "v1 oversized", header: []byte("PROXY UNKNOWN " + strings.Repeat("x", proxyV1MaxLength) + "\r\n"), bad: true},
+		{name: "v1 no carriage return", header: []byte("PROXY UNKNOWN\n"), bad: true},
+		{name: "v1 bad address", header: []byte("PROXY TCP4 192.0.2 198.51.100.1 12345 80\r\n"), bad: true},
+		{name: "v1 bad port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 80\r\n"), bad: true},
+		{name: "v1 missing fields", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1\r\n"), bad: true},
+		{name: "v1 unknown protocol", header: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 12345 80\r\n"), bad: true},
+		{name: "not a header", header: []byte("GET / HTTP/1.1\r\n"), bad: true},
+		{name: "v2 tcp4", header: proxyV2(0x1, 0x11, len(tcp4Body), tcp4Body), addr: "192.0.2.1:12345"},
+		{name: "v2 tcp6", header: proxyV2(0x1, 0x21, len(tcp6Body), tcp6Body), addr: "[2001:db8::1]:12345"},
+		{name: "v2 local", header: proxyV2(0x0, 0x11, len(tcp4Body), tcp4Body)},
+		{name: "v2 unspec", header: proxyV2(0x1, 0x00, 0, nil)},
+		{name: "v2 tlv after addresses", header: proxyV2(0x1, 0x11, len(tcp4Body)+4, append(tcp4Body, 0x04, 0, 1, 0)), addr: "192.0.2.1:12345"},
+		{name: "v2 truncated fixed part", header: proxyV2(0x1, 0x11, len(tcp4Body), nil)[:14], bad: true},
+		{name: "v2 length past the data", header: proxyV2(0x1, 0x11, 200, tcp4Body), bad: true},
+		{name: "v2 oversized length", header: proxyV2(0x1, 0x11, 0xffff, tcp4Body), bad: true},
+		{name: "v2 short tcp4 body", header: proxyV2(0x1, 0x11, 8, tcp4Body[:8]), bad: true},
+		{name: "v2 short tcp6 body", header: proxyV2(0x1, 0x21, 20, tcp6Body[:20]), bad: true},
+		{name: "v2 bad version", header: append(proxyV2(0x1, 0x11, 0, nil)[:12], 0x11, 0x11, 0, 0), bad: true},
+		{name: "v2 bad command", header: proxyV2(0x2, 0x11, len(tcp4Body), tcp4Body), bad: true},
+	} {
+		// the data after the header is left for the connection
+		r := bufio.NewReader(bytes.NewReader(append(tc.header, "payload"...)))
+		if tc.bad {
+			r = bufio.NewReader(bytes.NewReader(tc.header))
+		}
+		addr, err := readProxyHeader(r)
+		if tc.bad {
+			if err == nil {
+				t.Errorf("%s: accepted as %v", tc.name, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tc.addr {
+			t.Errorf("%s: address %q, want %q", tc.name, got, tc.addr)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+			t.Errorf("%s: left %q after the header", tc.name, rest)
+		}
+	}
+}
+
+// TestProxyListenerTrust sends a header from a peer that is trusted and from
+// one that is not; the header of the latter is data, not its address.
+func TestProxyListenerTrust(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		trusted string
+		addr    string // "" for the address of the peer
+		data    string
+	}{
+		{name: "trusted", trusted: "127.0.0.0/8", addr: "192.0.2.1:12345", data: "payload"},
+		{name: "not trusted", trusted: "192.0.2.0/24", data: "PROXY TCP4 192.0.2.1 198.51.100.1 12345 80\r\npayload"},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var trusted atomic.Pointer[[]netip.Prefix]
+		prefixes := []netip.Prefix{netip.MustParsePrefix(tc.trusted)}
+		trusted.Store(&prefixes)
+		pl := &proxyListener{Listener: ln, trusted: &trusted}
+
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 80\r\npayload")); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		want := tc.addr
+		if want == "" {
+			want = client.LocalAddr().String()
+		}
+		if got := conn.RemoteAddr().String(); got != want {
+			t.Errorf("%s: address %q, want %q", tc.name, got, want)
+		}
+		if data, err := io.ReadAll(conn); err != nil || string(data) != tc.data {
+			t.Errorf("%s: read %q, %v, want %q", tc.name, data, err, tc.data)
+		}
+		conn.Close()
+		ln.Close()
+	}
+}
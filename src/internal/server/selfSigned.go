@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+var ErrSelfSignedPartial = errors.New("only one of self-signed cert file and key file exists")
+
+// Key pairs that are not persisted are kept for the process lifetime, so the
+// certificate hash does not change across reloads.
+var selfSigned struct {
+	lock  sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// SelfSignedCertificate returns the self-signed key pair of a listener.
+// If CertFile and KeyFile are set, the key pair is read from them, or
+// generated and written to them if neither exists.
+func SelfSignedCertificate(c config.Listener) (*tls.Certificate, error) {
+	if c.TLS.CertFile == "" && c.TLS.KeyFile == "" {
+		selfSigned.lock.Lock()
+		defer selfSigned.lock.Unlock()
+
+		key := c.Network + " " + c.Address
+		if cert, ok := selfSigned.certs[key]; ok {
+			return cert, nil
+		}
+		certPEM, keyPEM, err := generateSelfSigned(selfSignedHosts(c))
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		if selfSigned.certs == nil {
+			selfSigned.certs = make(map[string]*tls.Certificate)
+		}
+		selfSigned.certs[key] = &cert
+		return &cert, nil
+	}
+
+	_, certErr := os.Stat(c.TLS.CertFile)
+	_, keyErr := os.Stat(c.TLS.KeyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		certPEM, keyPEM, err := generateSelfSigned(selfSignedHosts(c))
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(c.TLS.KeyFile, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(c.TLS.CertFile, certPEM, 0644); err != nil {
+			return nil, err
+		}
+		log.Warn().Str("CertFile", c.TLS.CertFile).Str("KeyFile", c.TLS.KeyFile).Msg("Self-signed key pair generated")
+	} else if os.IsNotExist(certErr) || os.IsNotExist(keyErr) {
+		return nil, ErrSelfSignedPartial
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+	return &cert, err
+}
+
+// SelfSignedCertHash is the hash to pass to `mount --cert-hash`.
+func SelfSignedCertHash(c config.Listener) (string, error) {
+	cert, err := SelfSignedCertificate(c)
+	if err != nil {
+		return "", err
+	}
+	return util.X509CertHash(cert.Leaf), nil
+}
+
+func selfSignedHosts(c config.Listener) []string {
+	hosts := []string{"localhost"}
+	if strings.HasPrefix(c.Network, "tcp") {
+		if host, _, err := net.SplitHostPort(c.Address); err == nil && host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+func generateSelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"WSFS self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsUnspecified() {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return
+}
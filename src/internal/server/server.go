@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"wsfs-core/internal/server/config"
@@ -21,6 +22,8 @@ import (
 
 var cacheIdRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
+var ErrBadBasePath = errors.New("base path must be an absolute URL path without special characters")
+
 var _ = (internalerror.ErrorHandler)((*Server)(nil))
 
 type Server struct {
@@ -31,10 +34,13 @@ type Server struct {
 	wsfsHandler   *wsfs.Handler
 
 	users     storage.Users
+	peerUsers map[uint32]*storage.User
 	anonymous *storage.User
 
 	realIpHeader string
 	serverHeader string
+	basePath     string // without trailing slash, empty for root
+	access       util.AddressRules
 }
 
 func NewServer(c config.Server, wsfsRegistry *wsfs.SessionRegistry) (s *Server, err error) {
@@ -44,25 +50,40 @@ func NewServer(c config.Server, wsfsRegistry *wsfs.SessionRegistry) (s *Server,
 		serverHeader: c.ServerHeader,
 	}
 
+	s.basePath, err = normalizeBasePath(c.BasePath)
+	if err != nil {
+		return
+	}
+
+	s.access, err = util.NewAddressRules(c.AllowCIDRs, c.DenyCIDRs)
+	if err != nil {
+		return
+	}
+
 	s.users, s.anonymous, err = storage.NewUsers(c, anonymousUsername)
 	if err != nil {
 		return
 	}
 
+	s.peerUsers, err = newPeerUsers(s.users)
+	if err != nil {
+		return
+	}
+
 	if c.Webdav.Webui.Enable && !c.Webdav.Enable {
 		err = errors.New("webui enabled but webdav disabled")
 		return
 	}
 
 	if c.Webdav.Enable {
-		s.webdavHandler, err = webdav.NewHandler(c.Webdav, s)
+		s.webdavHandler, err = webdav.NewHandler(c.Webdav, s.basePath, s)
 		if err != nil {
 			return
 		}
 	}
 
 	if c.Webdav.Webui.Enable {
-		s.webuiHandler, err = webui.NewHandler(c.Webdav.Webui, s.cacheId)
+		s.webuiHandler, err = webui.NewHandler(c.Webdav.Webui, s.basePath, s.cacheId)
 		if err != nil {
 			return
 		}
@@ -74,9 +95,20 @@ func NewServer(c config.Server, wsfsRegistry *wsfs.SessionRegistry) (s *Server,
 			err = resolveErr
 			return
 		}
+		compression, compressionErr := wsfs.ParseCompression(c.WSFS.Compression)
+		if compressionErr != nil {
+			err = compressionErr
+			return
+		}
+		if c.WSFS.CompressionThreshold < 0 {
+			err = wsfs.ErrBadCompressionThreshold
+			return
+		}
 		s.wsfsHandler = wsfs.NewHandler(s, fsIds, wsfs.FeatureOptions{
-			EnableLink:         c.WSFS.EnableLink,
-			AllowedXAttrPrefix: c.WSFS.AllowedXAttrPrefix,
+			EnableLink:           c.WSFS.EnableLink,
+			AllowedXAttrPrefix:   c.WSFS.AllowedXAttrPrefix,
+			Compression:          compression,
+			CompressionThreshold: c.WSFS.CompressionThreshold,
 		}, wsfsRegistry)
 	}
 
@@ -159,6 +191,9 @@ func (s *Server) tryAuth(rsp http.ResponseWriter, req *http.Request) (user *stor
 	var err error
 
 	user, err = httpBasicAuth(s.users, req)
+	if err == ErrAuthHeaderNotExists {
+		user, err = s.peerAuth(req)
+	}
 	switch err {
 	case nil: // pass
 	case ErrAuthHeaderNotExists, ErrAnonymous:
@@ -170,6 +205,9 @@ func (s *Server) tryAuth(rsp http.ResponseWriter, req *http.Request) (user *stor
 	case ErrBadHttpAuthHeader, ErrUserNotExists, ErrHashMismatch:
 		s.writeAuthRsp(rsp)
 		user = nil
+	case ErrAddressDenied:
+		s.ServeErrorPage(rsp, req, http.StatusForbidden, "Forbidden")
+		user = nil
 	default:
 		user = nil
 		log.Error().Err(err).Msg("Unable to auth user")
@@ -189,7 +227,49 @@ func rewriteRemoteAddr(req *http.Request, realIpHeader string) {
 	}
 }
 
-func (s *Server) ServeHTTP(rsp_ http.ResponseWriter, req *http.Request) {
+// "/files/" and "/files" are both normalized to "/files", "/" to "".
+func normalizeBasePath(basePath string) (string, error) {
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath == "" {
+		return "", nil
+	}
+	if !util.IsUrlValid(basePath) || (&url.URL{Path: basePath}).EscapedPath() != basePath {
+		return "", ErrBadBasePath
+	}
+	return basePath, nil
+}
+
+// stripBasePath removes the base path from the request URL. The base path
+// itself is redirected to the directory form, so relative links in WebUI work.
+func (s *Server) stripBasePath(rsp http.ResponseWriter, req *http.Request) (ok bool) {
+	rest, found := strings.CutPrefix(req.URL.Path, s.basePath)
+	if !found || (rest != "" && rest[0] != '/') {
+		s.ServeErrorMessage(rsp, req, http.StatusNotFound, "Not Found")
+		return false
+	}
+	if rest == "" {
+		if req.Method == "GET" || req.Method == "HEAD" {
+			target := s.basePath + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(rsp, req, target, http.StatusMovedPermanently)
+			return false
+		}
+		rest = "/"
+	}
+
+	req.URL.Path = rest
+	// the escaped form is recomputed from Path
+	req.URL.RawPath = ""
+	return true
+}
+
+func (s *Server) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	s.serveFrontends(rsp, req, frontendAll)
+}
+
+func (s *Server) serveFrontends(rsp_ http.ResponseWriter, req *http.Request, frontends frontendSet) {
 	rsp := newResponseWriter(rsp_)
 	defer func() {
 		if err := recover(); err != nil {
@@ -209,13 +289,22 @@ func (s *Server) ServeHTTP(rsp_ http.ResponseWriter, req *http.Request) {
 		rsp.Header().Set("Server", s.serverHeader)
 	}
 
+	if !s.access.Permits(req.RemoteAddr) {
+		s.ServeErrorMessage(rsp, req, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if s.basePath != "" && !s.stripBasePath(rsp, req) {
+		return
+	}
+
 	if !util.IsUrlValid(req.URL.Path) {
 		s.ServeErrorPage(rsp, req, http.StatusBadRequest, "invalid URL path")
 		return
 	}
 
 	querys := req.URL.Query()
-	if querys.Has("webui-assets") && s.webuiHandler != nil {
+	if querys.Has("webui-assets") && s.webuiHandler != nil && frontends.has(frontendWebui) {
 		if req.Method != "GET" && req.Method != "HEAD" {
 			s.writeMethodNotAllow(rsp, "GET, HEAD")
 		} else {
@@ -224,12 +313,17 @@ func (s *Server) ServeHTTP(rsp_ http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if s.webdavHandler != nil && frontends.has(frontendWebdav) &&
+		s.webdavHandler.ServeCORS(rsp, req) {
+		return
+	}
+
 	user := s.tryAuth(rsp, req)
 	if user == nil {
 		return
 	}
 
-	if s.wsfsHandler != nil {
+	if s.wsfsHandler != nil && frontends.has(frontendWSFS) {
 		if s.wsfsHandler.TryServerHTTP(rsp, req, user, querys.Has("wsfs")) {
 			return
 		}
@@ -237,12 +331,12 @@ func (s *Server) ServeHTTP(rsp_ http.ResponseWriter, req *http.Request) {
 		s.writeMethodNotAllow(rsp, "")
 		return
 	}
-	if s.webuiHandler != nil &&
+	if s.webuiHandler != nil && frontends.has(frontendWebui) &&
 		strings.HasSuffix(req.URL.Path, "/") &&
 		(req.Method == "GET" || req.Method == "HEAD") {
 		s.webuiHandler.ServeList(rsp, req, user)
 	} else {
-		if s.webdavHandler != nil {
+		if s.webdavHandler != nil && frontends.has(frontendWebdav) {
 			s.webdavHandler.ServeHTTP(rsp, req, user)
 		} else {
 			s.writeMethodNotAllow(rsp, "")
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"wsfs-core/internal/server/wsfs"
+
+	"github.com/rs/zerolog/log"
+)
+
+const sessionStateVersion = 1
+
+// sessionState is the content of Config.SessionStateFile. It holds resume
+// ids, so it is readable by the owner only.
+type sessionState struct {
+	Version    int
+	Registries map[string][]wsfs.SavedSession // by virtual host key
+}
+
+func (h *Hub) sessionStateFile() string {
+	if inst := h.inst.Load(); inst != nil {
+		return inst.config.SessionStateFile
+	}
+	return ""
+}
+
+// saveSessions writes the hibernated sessions of all registries to the
+// state file, replacing it at once.
+func (h *Hub) saveSessions() {
+	path := h.sessionStateFile()
+	if path == "" {
+		return
+	}
+
+	state := sessionState{Version: sessionStateVersion, Registries: map[string][]wsfs.SavedSession{}}
+	count := 0
+	h.registriesLock.Lock()
+	for key, registry := range h.wsfsRegistries {
+		if saved := registry.Save(); len(saved) != 0 {
+			state.Registries[key] = saved
+			count += len(saved)
+		}
+	}
+	h.registriesLock.Unlock()
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		tmpPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+		if err = os.WriteFile(tmpPath, data, 0o600); err == nil {
+			if err = os.Rename(tmpPath, path); err != nil {
+				_ = os.Remove(tmpPath)
+			}
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("Path", path).Msg("Unable to save sessions")
+		return
+	}
+	log.Warn().Int("Sessions", count).Str("Path", path).Msg("Sessions saved")
+}
+
+// restoreSessions loads the state file into the registries and removes it,
+// so that its sessions are restored once. It is called on start, and when
+// an unknown session is resumed, which happens after an upgrade when the
+// old process saves its sessions after the new one started.
+func (h *Hub) restoreSessions() {
+	path := h.sessionStateFile()
+	if path == "" {
+		return
+	}
+
+	h.restoreLock.Lock()
+	defer h.restoreLock.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = os.Remove(path)
+	}
+	var state sessionState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err == nil && state.Version != sessionStateVersion {
+		err = fmt.Errorf("unsupported version %d", state.Version)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("Path", path).Msg("Unable to restore sessions")
+		return
+	}
+
+	h.registriesLock.Lock()
+	defer h.registriesLock.Unlock()
+	for key, saved := range state.Registries {
+		registry, ok := h.wsfsRegistries[key]
+		if !ok {
+			log.Warn().Str("VirtualHost", key).Int("Sessions", len(saved)).Msg("Sessions of a removed virtual host dropped")
+			continue
+		}
+		registry.Restore(saved)
+	}
+}
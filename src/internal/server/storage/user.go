@@ -1,9 +1,18 @@
 package storage
 
+import (
+	"time"
+	"wsfs-core/internal/util"
+)
+
 type User struct {
 	Name     string
 	Password []byte
 
 	ReadOnly bool
 	Storage  *Storage
+	Access   util.AddressRules
+	PeerUids []uint32
+
+	SessionLifetime time.Duration // 0 means the default of the registry
 }
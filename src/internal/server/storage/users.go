@@ -2,7 +2,9 @@ package storage
 
 import (
 	"fmt"
+	"time"
 	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/util"
 
 	"github.com/rs/zerolog/log"
 )
@@ -42,16 +44,31 @@ func NewUsers(conf config.Server, anonymousUsername string) (users Users, anonym
 			return
 		}
 
+		if us.SessionLifetime < 0 {
+			err = fmt.Errorf("user %q: session lifetime can not be negative", us.Name)
+			return
+		}
+
 		if _, ok := storages[us.Storage]; !ok {
 			err = fmt.Errorf("user %q referenced a storage that does not exist", us.Name)
 			return
 		}
 
+		access, accessErr := util.NewAddressRules(us.AllowCIDRs, us.DenyCIDRs)
+		if accessErr != nil {
+			err = fmt.Errorf("user %q: %w", us.Name, accessErr)
+			return
+		}
+
 		users[us.Name] = &User{
 			Name:     us.Name,
 			Password: []byte(us.SecretHash),
 			Storage:  storages[us.Storage],
 			ReadOnly: us.ReadOnly,
+			Access:   access,
+			PeerUids: us.PeerUids,
+
+			SessionLifetime: time.Duration(us.SessionLifetime) * time.Second,
 		}
 
 		if storagesReadOnly[us.Storage] {
@@ -19,6 +19,7 @@ var (
 	ErrHashMismatch        = errors.New("password hash mismatch")
 	ErrAuthHeaderNotExists = errors.New("http auth header not exists")
 	ErrAnonymous           = errors.New("anonymous user")
+	ErrAddressDenied       = errors.New("address denied")
 )
 
 func checkPassword(user *storage.User, password []byte) (err error) {
@@ -30,7 +31,7 @@ func checkPassword(user *storage.User, password []byte) (err error) {
 	return err
 }
 
-func authUser(users map[string]*storage.User, username, password string) (*storage.User, error) {
+func authUser(users map[string]*storage.User, username, password, remoteAddr string) (*storage.User, error) {
 	var user *storage.User
 	var ok bool
 
@@ -42,6 +43,14 @@ func authUser(users map[string]*storage.User, username, password string) (*stora
 		return nil, ErrUserNotExists
 	}
 
+	// checked before the password, so a denied address learns nothing about
+	// it; answered as an unknown user, so it does not learn the user exists
+	// either
+	if !user.Access.Permits(remoteAddr) {
+		log.Info().Str("Name", username).Str("From", remoteAddr).Msg("User denied from address")
+		return nil, ErrUserNotExists
+	}
+
 	return user, checkPassword(user, []byte(password))
 }
 
@@ -55,5 +64,5 @@ func httpBasicAuth(users map[string]*storage.User, req *http.Request) (*storage.
 		return nil, ErrBadHttpAuthHeader
 	}
 
-	return authUser(users, username, password)
+	return authUser(users, username, password, req.RemoteAddr)
 }
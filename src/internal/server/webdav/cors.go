@@ -0,0 +1,109 @@
+package webdav
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"wsfs-core/internal/server/config"
+)
+
+// ErrCORSAnyOriginCredentials is returned for a config letting any website
+// make requests with the credentials of the user.
+var ErrCORSAnyOriginCredentials = errors.New("webdav: CORS credentials can not be allowed for any origin")
+
+var (
+	defaultCORSMethods = []string{"OPTIONS", "GET", "HEAD", "PROPFIND", "PROPPATCH", "PUT", "PATCH", "DELETE", "MKCOL", "COPY", "MOVE"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Depth", "Destination", "Overwrite", "Range", "X-Update-Range"}
+	// Content-Type and the like are always exposed by browsers
+	corsExposedHeaders = "DAV, Allow, Content-Disposition, Content-Range, ETag, Location, Last-Modified"
+)
+
+type cors struct {
+	anyOrigin        bool
+	origins          []string
+	methods          string
+	headers          string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(c config.CORS) (*cors, error) {
+	if !c.Enable {
+		return nil, nil
+	}
+	anyOrigin := slices.Contains(c.AllowedOrigins, "*")
+	if anyOrigin && c.AllowCredentials {
+		return nil, ErrCORSAnyOriginCredentials
+	}
+
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	cs := &cors{
+		anyOrigin:        anyOrigin,
+		methods:          strings.ToUpper(strings.Join(methods, ", ")),
+		headers:          strings.Join(headers, ", "),
+		allowCredentials: c.AllowCredentials,
+	}
+	for _, origin := range c.AllowedOrigins {
+		cs.origins = append(cs.origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+	if c.MaxAge > 0 {
+		cs.maxAge = strconv.Itoa(c.MaxAge)
+	}
+	return cs, nil
+}
+
+func (cs *cors) originAllowed(origin string) bool {
+	return cs.anyOrigin || slices.Contains(cs.origins, strings.ToLower(origin))
+}
+
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// ServeCORS writes CORS headers for an allowed origin, and answers preflight
+// requests. Browsers send preflights without credentials, so this is called
+// before authentication. A DAV OPTIONS request is not a preflight and is left
+// to handleOptions.
+func (h *Handler) ServeCORS(rsp http.ResponseWriter, req *http.Request) (handled bool) {
+	if h.cors == nil {
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	rsp.Header().Add("Vary", "Origin")
+	if origin == "" || !h.cors.originAllowed(origin) {
+		return false
+	}
+
+	// the origin is echoed, since "*" does not work with credentials
+	rsp.Header().Set("Access-Control-Allow-Origin", origin)
+	if h.cors.allowCredentials {
+		rsp.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !isPreflight(req) {
+		rsp.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		return false
+	}
+
+	rsp.Header().Set("Access-Control-Allow-Methods", h.cors.methods)
+	rsp.Header().Set("Access-Control-Allow-Headers", h.cors.headers)
+	if h.cors.maxAge != "" {
+		rsp.Header().Set("Access-Control-Max-Age", h.cors.maxAge)
+	}
+	rsp.Header().Set("DAV", davCompliance)
+	rsp.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -3,11 +3,12 @@ package webdav
 import (
 	"net/http"
 	"net/url"
+	"strings"
 	"wsfs-core/internal/server/storage"
 	"wsfs-core/internal/util"
 )
 
-func getPathAndDest(st *storage.Storage, req *http.Request) (path string, dest string, err error) {
+func getPathAndDest(st *storage.Storage, req *http.Request, basePath string) (path string, dest string, err error) {
 	path = st.Path + req.URL.Path
 
 	desthdr := req.Header.Get("Destination")
@@ -27,12 +28,13 @@ func getPathAndDest(st *storage.Storage, req *http.Request) (path string, dest s
 		return
 	}
 
-	if !util.IsUrlValid(desturl.Path) {
+	destPath, ok := strings.CutPrefix(desturl.Path, basePath)
+	if !ok || !util.IsUrlValid(destPath) {
 		err = errInvalidDestination
 		return
 	}
 
-	dest = st.Path + desturl.Path
+	dest = st.Path + destPath
 	if path == dest {
 		err = errDestinationEqualsSource
 		return
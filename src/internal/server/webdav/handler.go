@@ -29,20 +29,32 @@ const (
 	recursionMax = 256
 
 	preconditionPropfindFiniteDepth = "propfind-finite-depth"
+
+	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
+	// https://sabre.io/dav/http-patch/
+	davCompliance = "1, sabredav-partialupdate"
 )
 
 type Handler struct {
+	basePath               string
 	enableWebui            bool
 	allowPropfindInfDepth  bool
 	enableContentTypeProbe bool
+	cors                   *cors
 	errorHandler           internalerror.ErrorHandler
 }
 
-func NewHandler(c config.Webdav, errorHandler internalerror.ErrorHandler) (h *Handler, err error) {
+func NewHandler(c config.Webdav, basePath string, errorHandler internalerror.ErrorHandler) (h *Handler, err error) {
+	cors, err := newCORS(c.CORS)
+	if err != nil {
+		return nil, err
+	}
 	h = &Handler{
+		basePath:               basePath,
 		enableWebui:            c.Webui.Enable,
 		allowPropfindInfDepth:  c.AllowPropfindInfDepth,
 		enableContentTypeProbe: c.EnableContentTypeProbe,
+		cors:                   cors,
 		errorHandler:           errorHandler,
 	}
 	return
@@ -130,9 +142,7 @@ func (h *Handler) handleOptions(rsp http.ResponseWriter, req *http.Request, user
 	}
 
 	rsp.Header().Set("Allow", allow)
-	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
-	// https://sabre.io/dav/http-patch/
-	rsp.Header().Set("DAV", "1, sabredav-partialupdate")
+	rsp.Header().Set("DAV", davCompliance)
 	// http://msdn.microsoft.com/en-au/library/cc250217.aspx
 	rsp.Header().Set("MS-Author-Via", "DAV")
 	return http.StatusOK, nil
@@ -309,7 +319,7 @@ func (h *Handler) handleMkcol(_ http.ResponseWriter, req *http.Request, st *stor
 
 func (h *Handler) handleCopyMove(_ http.ResponseWriter, req *http.Request, st *storage.Storage) (status int, err error) {
 	var src, dst string
-	src, dst, err = getPathAndDest(st, req)
+	src, dst, err = getPathAndDest(st, req, h.basePath)
 
 	switch err {
 	case nil:
@@ -378,7 +388,7 @@ func (h *Handler) handlePropfind(rsp http.ResponseWriter, req *http.Request, st
 		}
 	}
 	if depth == infiniteDepth && !h.allowPropfindInfDepth {
-		preconditionErrorResponse(rsp, preconditionPropfindFiniteDepth, req.URL.Path, http.StatusForbidden)
+		preconditionErrorResponse(rsp, preconditionPropfindFiniteDepth, h.basePath+req.URL.Path, http.StatusForbidden)
 		return
 	}
 
@@ -388,11 +398,11 @@ func (h *Handler) handlePropfind(rsp http.ResponseWriter, req *http.Request, st
 		//log.Debug().Str("obj", reqPath).Msg("walk fn")
 		if err != nil {
 			if os.IsNotExist(err) {
-				templates.WritePropfindItemBadResponse(rsp, reqPath, "HTTP/1.1 404 Not Found")
+				templates.WritePropfindItemBadResponse(rsp, h.basePath, reqPath, "HTTP/1.1 404 Not Found")
 			} else if os.IsPermission(err) {
-				templates.WritePropfindItemBadResponse(rsp, reqPath, "HTTP/1.1 403 Forbidden")
+				templates.WritePropfindItemBadResponse(rsp, h.basePath, reqPath, "HTTP/1.1 403 Forbidden")
 			} else {
-				templates.WritePropfindItemBadResponse(rsp, reqPath, "HTTP/1.1 500 Internal Server Error")
+				templates.WritePropfindItemBadResponse(rsp, h.basePath, reqPath, "HTTP/1.1 500 Internal Server Error")
 				log.Warn().Err(err).Str("Path", reqPath).Msg("Walk error")
 			}
 			return nil
@@ -410,7 +420,7 @@ func (h *Handler) handlePropfind(rsp http.ResponseWriter, req *http.Request, st
 				}
 			}
 		}
-		templates.WritePropfindItemOKResponse(rsp, reqPath, info, h.enableContentTypeProbe, totalBytes, availBytes)
+		templates.WritePropfindItemOKResponse(rsp, h.basePath, reqPath, info, h.enableContentTypeProbe, totalBytes, availBytes)
 		return nil
 	})
 	templates.WritePropfindEnd(rsp)
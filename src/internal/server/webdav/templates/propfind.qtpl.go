@@ -89,11 +89,11 @@ func PropfindEnd() string {
 }
 
 //line propfind.qtpl:17
-func StreamPropfindItemOKResponse(qw422016 *qt422016.Writer, name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) {
+func StreamPropfindItemOKResponse(qw422016 *qt422016.Writer, basePath, name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) {
 //line propfind.qtpl:17
 	qw422016.N().S(`<D:response><D:href>`)
 //line propfind.qtpl:19
-	qw422016.N().S(escapeWebdavHref(name))
+	qw422016.N().S(escapeWebdavHref(basePath + name))
 //line propfind.qtpl:19
 	qw422016.N().S(`</D:href>`)
 //line propfind.qtpl:24
@@ -156,22 +156,22 @@ func StreamPropfindItemOKResponse(qw422016 *qt422016.Writer, name string, fi os.
 }
 
 //line propfind.qtpl:48
-func WritePropfindItemOKResponse(qq422016 qtio422016.Writer, name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) {
+func WritePropfindItemOKResponse(qq422016 qtio422016.Writer, basePath, name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) {
 //line propfind.qtpl:48
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line propfind.qtpl:48
-	StreamPropfindItemOKResponse(qw422016, name, fi, enableContentTypeProbe, total, avail)
+	StreamPropfindItemOKResponse(qw422016, basePath, name, fi, enableContentTypeProbe, total, avail)
 //line propfind.qtpl:48
 	qt422016.ReleaseWriter(qw422016)
 //line propfind.qtpl:48
 }
 
 //line propfind.qtpl:48
-func PropfindItemOKResponse(name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) string {
+func PropfindItemOKResponse(basePath, name string, fi os.FileInfo, enableContentTypeProbe bool, total, avail uint64) string {
 //line propfind.qtpl:48
 	qb422016 := qt422016.AcquireByteBuffer()
 //line propfind.qtpl:48
-	WritePropfindItemOKResponse(qb422016, name, fi, enableContentTypeProbe, total, avail)
+	WritePropfindItemOKResponse(qb422016, basePath, name, fi, enableContentTypeProbe, total, avail)
 //line propfind.qtpl:48
 	qs422016 := string(qb422016.B)
 //line propfind.qtpl:48
@@ -182,11 +182,11 @@ func PropfindItemOKResponse(name string, fi os.FileInfo, enableContentTypeProbe
 }
 
 //line propfind.qtpl:50
-func StreamPropfindItemBadResponse(qw422016 *qt422016.Writer, name string, str string) {
+func StreamPropfindItemBadResponse(qw422016 *qt422016.Writer, basePath, name string, str string) {
 //line propfind.qtpl:50
 	qw422016.N().S(`<D:response><D:href>`)
 //line propfind.qtpl:52
-	qw422016.N().S(escapeWebdavHref(name))
+	qw422016.N().S(escapeWebdavHref(basePath + name))
 //line propfind.qtpl:52
 	qw422016.N().S(`</D:href><D:propstat><D:status>`)
 //line propfind.qtpl:54
@@ -197,22 +197,22 @@ func StreamPropfindItemBadResponse(qw422016 *qt422016.Writer, name string, str s
 }
 
 //line propfind.qtpl:57
-func WritePropfindItemBadResponse(qq422016 qtio422016.Writer, name string, str string) {
+func WritePropfindItemBadResponse(qq422016 qtio422016.Writer, basePath, name string, str string) {
 //line propfind.qtpl:57
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line propfind.qtpl:57
-	StreamPropfindItemBadResponse(qw422016, name, str)
+	StreamPropfindItemBadResponse(qw422016, basePath, name, str)
 //line propfind.qtpl:57
 	qt422016.ReleaseWriter(qw422016)
 //line propfind.qtpl:57
 }
 
 //line propfind.qtpl:57
-func PropfindItemBadResponse(name string, str string) string {
+func PropfindItemBadResponse(basePath, name string, str string) string {
 //line propfind.qtpl:57
 	qb422016 := qt422016.AcquireByteBuffer()
 //line propfind.qtpl:57
-	WritePropfindItemBadResponse(qb422016, name, str)
+	WritePropfindItemBadResponse(qb422016, basePath, name, str)
 //line propfind.qtpl:57
 	qs422016 := string(qb422016.B)
 //line propfind.qtpl:57
@@ -17,6 +17,7 @@ import (
 var builtinResources embed.FS
 
 type Handler struct {
+	basePath        string
 	customResources string
 	cacheId         string
 	showDirSize     bool
@@ -24,8 +25,9 @@ type Handler struct {
 	customJS        bool
 }
 
-func NewHandler(c config.Webui, cacheId string) (h *Handler, err error) {
+func NewHandler(c config.Webui, basePath string, cacheId string) (h *Handler, err error) {
 	h = &Handler{
+		basePath:        basePath,
 		cacheId:         cacheId,
 		showDirSize:     c.ShowDirSize,
 		customResources: c.CustomResources,
@@ -61,7 +63,7 @@ func (w *Handler) ServeList(rsp http.ResponseWriter, req *http.Request, user *st
 	}
 
 	rsp.WriteHeader(http.StatusOK)
-	templates.WriteList(rsp, w.cacheId, arg.Paths, arg.Files, w.showDirSize, w.customCSS, w.customJS, user.ReadOnly)
+	templates.WriteList(rsp, w.basePath, w.cacheId, arg.Paths, arg.Files, w.showDirSize, w.customCSS, w.customJS, user.ReadOnly)
 }
 
 func (w *Handler) ServeAssets(rsp http.ResponseWriter, req *http.Request) {
@@ -82,7 +84,7 @@ func (w *Handler) ServeAssets(rsp http.ResponseWriter, req *http.Request) {
 
 func (w *Handler) ServeErrorPage(rsp http.ResponseWriter, _ *http.Request, status int, msg string) {
 	rsp.WriteHeader(status)
-	templates.WriteError(rsp, w.cacheId, status, msg, w.customCSS, w.customJS)
+	templates.WriteError(rsp, w.basePath, w.cacheId, status, msg, w.customCSS, w.customJS)
 }
 
 func (w *Handler) ServeError(rsp http.ResponseWriter, req *http.Request, err error) {
@@ -18,7 +18,7 @@ var (
 )
 
 //line error.qtpl:1
-func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg string, customCSS bool, customJS bool) {
+func StreamError(qw422016 *qt422016.Writer, basePath string, cacheId string, status int, msg string, customCSS bool, customJS bool) {
 //line error.qtpl:1
 	qw422016.N().S(`<!DOCTYPE html>
 <html lang="en">
@@ -39,7 +39,11 @@ func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg stri
 //line error.qtpl:8
 	if customJS {
 //line error.qtpl:8
-		qw422016.N().S(`<script src="/custom/custom.js?webui-assets=`)
+		qw422016.N().S(`<script src="`)
+//line error.qtpl:8
+		qw422016.E().S(basePath)
+//line error.qtpl:8
+		qw422016.N().S(`/custom/custom.js?webui-assets=`)
 //line error.qtpl:8
 		qw422016.N().S(cacheId)
 //line error.qtpl:8
@@ -48,12 +52,20 @@ func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg stri
 	}
 //line error.qtpl:8
 	qw422016.N().S(`
-    <link rel="shortcut icon" href="/img/favicon.ico?webui-assets=`)
+    <link rel="shortcut icon" href="`)
+//line error.qtpl:9
+	qw422016.E().S(basePath)
+//line error.qtpl:9
+	qw422016.N().S(`/img/favicon.ico?webui-assets=`)
 //line error.qtpl:9
 	qw422016.N().S(cacheId)
 //line error.qtpl:9
 	qw422016.N().S(`">
-    <link rel="stylesheet" type="text/css" href="/css/main.css?webui-assets=`)
+    <link rel="stylesheet" type="text/css" href="`)
+//line error.qtpl:10
+	qw422016.E().S(basePath)
+//line error.qtpl:10
+	qw422016.N().S(`/css/main.css?webui-assets=`)
 //line error.qtpl:10
 	qw422016.N().S(cacheId)
 //line error.qtpl:10
@@ -62,7 +74,11 @@ func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg stri
 //line error.qtpl:11
 	if customCSS {
 //line error.qtpl:11
-		qw422016.N().S(`<link rel="stylesheet" type="text/css" href="/custom/custom.css?webui-assets=`)
+		qw422016.N().S(`<link rel="stylesheet" type="text/css" href="`)
+//line error.qtpl:11
+		qw422016.E().S(basePath)
+//line error.qtpl:11
+		qw422016.N().S(`/custom/custom.css?webui-assets=`)
 //line error.qtpl:11
 		qw422016.N().S(cacheId)
 //line error.qtpl:11
@@ -85,11 +101,19 @@ func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg stri
 //line error.qtpl:16
 	qw422016.N().S(`</p>
     <div class="rrow">
-        <button type="button" onclick="location.href='/'"><span class="icon backIcon"></span><div data-t>Return to root</div></button>
+        <button type="button" onclick="location.href='`)
+//line error.qtpl:18
+	qw422016.E().S(basePath)
+//line error.qtpl:18
+	qw422016.N().S(`/'"><span class="icon backIcon"></span><div data-t>Return to root</div></button>
     </div>
 </main>
 </body>
-<script src="/js/i18n.js?webui-assets=`)
+<script src="`)
+//line error.qtpl:22
+	qw422016.E().S(basePath)
+//line error.qtpl:22
+	qw422016.N().S(`/js/i18n.js?webui-assets=`)
 //line error.qtpl:22
 	qw422016.N().S(cacheId)
 //line error.qtpl:22
@@ -99,22 +123,22 @@ func StreamError(qw422016 *qt422016.Writer, cacheId string, status int, msg stri
 }
 
 //line error.qtpl:23
-func WriteError(qq422016 qtio422016.Writer, cacheId string, status int, msg string, customCSS bool, customJS bool) {
+func WriteError(qq422016 qtio422016.Writer, basePath string, cacheId string, status int, msg string, customCSS bool, customJS bool) {
 //line error.qtpl:23
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line error.qtpl:23
-	StreamError(qw422016, cacheId, status, msg, customCSS, customJS)
+	StreamError(qw422016, basePath, cacheId, status, msg, customCSS, customJS)
 //line error.qtpl:23
 	qt422016.ReleaseWriter(qw422016)
 //line error.qtpl:23
 }
 
 //line error.qtpl:23
-func Error(cacheId string, status int, msg string, customCSS bool, customJS bool) string {
+func Error(basePath string, cacheId string, status int, msg string, customCSS bool, customJS bool) string {
 //line error.qtpl:23
 	qb422016 := qt422016.AcquireByteBuffer()
 //line error.qtpl:23
-	WriteError(qb422016, cacheId, status, msg, customCSS, customJS)
+	WriteError(qb422016, basePath, cacheId, status, msg, customCSS, customJS)
 //line error.qtpl:23
 	qs422016 := string(qb422016.B)
 //line error.qtpl:23
@@ -35,7 +35,7 @@ type FileInfo struct {
 }
 
 //line list.qtpl:18
-func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) {
+func StreamList(qw422016 *qt422016.Writer, basePath string, cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) {
 //line list.qtpl:18
 	qw422016.N().S(`<!DOCTYPE html>
 <html lang="en">
@@ -71,12 +71,20 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
 	}
 //line list.qtpl:25
 	qw422016.N().S(`</script>
-    <link rel="shortcut icon" href="/img/favicon.ico?webui-assets=`)
+    <link rel="shortcut icon" href="`)
+//line list.qtpl:26
+	qw422016.E().S(basePath)
+//line list.qtpl:26
+	qw422016.N().S(`/img/favicon.ico?webui-assets=`)
 //line list.qtpl:26
 	qw422016.N().S(cacheId)
 //line list.qtpl:26
 	qw422016.N().S(`">
-    <script src="/js/list.js?webui-assets=`)
+    <script src="`)
+//line list.qtpl:27
+	qw422016.E().S(basePath)
+//line list.qtpl:27
+	qw422016.N().S(`/js/list.js?webui-assets=`)
 //line list.qtpl:27
 	qw422016.N().S(cacheId)
 //line list.qtpl:27
@@ -85,7 +93,11 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
 //line list.qtpl:28
 	if customJS {
 //line list.qtpl:28
-		qw422016.N().S(`<script src="/custom/custom.js?webui-assets=`)
+		qw422016.N().S(`<script src="`)
+//line list.qtpl:28
+		qw422016.E().S(basePath)
+//line list.qtpl:28
+		qw422016.N().S(`/custom/custom.js?webui-assets=`)
 //line list.qtpl:28
 		qw422016.N().S(cacheId)
 //line list.qtpl:28
@@ -94,12 +106,20 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
 	}
 //line list.qtpl:28
 	qw422016.N().S(`
-    <link rel="stylesheet" type="text/css" href="/css/main.css?webui-assets=`)
+    <link rel="stylesheet" type="text/css" href="`)
+//line list.qtpl:29
+	qw422016.E().S(basePath)
+//line list.qtpl:29
+	qw422016.N().S(`/css/main.css?webui-assets=`)
 //line list.qtpl:29
 	qw422016.N().S(cacheId)
 //line list.qtpl:29
 	qw422016.N().S(`">
-    <link rel="stylesheet" type="text/css" href="/css/list.css?webui-assets=`)
+    <link rel="stylesheet" type="text/css" href="`)
+//line list.qtpl:30
+	qw422016.E().S(basePath)
+//line list.qtpl:30
+	qw422016.N().S(`/css/list.css?webui-assets=`)
 //line list.qtpl:30
 	qw422016.N().S(cacheId)
 //line list.qtpl:30
@@ -108,7 +128,11 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
 //line list.qtpl:31
 	if customCSS {
 //line list.qtpl:31
-		qw422016.N().S(`<link rel="stylesheet" type="text/css" href="/custom/custom.css?webui-assets=`)
+		qw422016.N().S(`<link rel="stylesheet" type="text/css" href="`)
+//line list.qtpl:31
+		qw422016.E().S(basePath)
+//line list.qtpl:31
+		qw422016.N().S(`/custom/custom.css?webui-assets=`)
 //line list.qtpl:31
 		qw422016.N().S(cacheId)
 //line list.qtpl:31
@@ -225,7 +249,11 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
         </table>
     </main>
 </body>
-<script src="/js/i18n.js?webui-assets=`)
+<script src="`)
+//line list.qtpl:67
+	qw422016.E().S(basePath)
+//line list.qtpl:67
+	qw422016.N().S(`/js/i18n.js?webui-assets=`)
 //line list.qtpl:67
 	qw422016.N().S(cacheId)
 //line list.qtpl:67
@@ -237,22 +265,22 @@ func StreamList(qw422016 *qt422016.Writer, cacheId string, paths []string, files
 }
 
 //line list.qtpl:70
-func WriteList(qq422016 qtio422016.Writer, cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) {
+func WriteList(qq422016 qtio422016.Writer, basePath string, cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) {
 //line list.qtpl:70
 	qw422016 := qt422016.AcquireWriter(qq422016)
 //line list.qtpl:70
-	StreamList(qw422016, cacheId, paths, files, showDirSize, customCSS, customJS, ReadOnly)
+	StreamList(qw422016, basePath, cacheId, paths, files, showDirSize, customCSS, customJS, ReadOnly)
 //line list.qtpl:70
 	qt422016.ReleaseWriter(qw422016)
 //line list.qtpl:70
 }
 
 //line list.qtpl:70
-func List(cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) string {
+func List(basePath string, cacheId string, paths []string, files []FileInfo, showDirSize bool, customCSS bool, customJS bool, ReadOnly bool) string {
 //line list.qtpl:70
 	qb422016 := qt422016.AcquireByteBuffer()
 //line list.qtpl:70
-	WriteList(qb422016, cacheId, paths, files, showDirSize, customCSS, customJS, ReadOnly)
+	WriteList(qb422016, basePath, cacheId, paths, files, showDirSize, customCSS, customJS, ReadOnly)
 //line list.qtpl:70
 	qs422016 := string(qb422016.B)
 //line list.qtpl:70
@@ -6,7 +6,7 @@ import (
 	"wsfs-core/internal/share/wsfsprotocol"
 )
 
-func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
+func (s *session) doCommandCall(clientMark uint32, cmd uint8, r io.Reader) {
 	switch cmd {
 	case wsfsprotocol.CmdOpen:
 		var req wsfsprotocol.CmdOpenStruct
@@ -14,9 +14,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdOpen(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdClose:
@@ -25,9 +27,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdClose(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdRead:
@@ -36,9 +40,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdRead(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdReadDir:
@@ -47,9 +53,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdReadDir(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdReadLink:
@@ -58,9 +66,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdReadLink(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdWrite:
@@ -71,10 +81,12 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 			s.releaseFastBuffer(dataBuf)
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			defer s.releaseFastBuffer(dataBuf)
 			s.cmdWrite(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSeek:
@@ -83,9 +95,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSeek(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdAllocate:
@@ -94,9 +108,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdAllocate(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdGetAttr:
@@ -105,9 +121,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdGetAttr(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSetAttr:
@@ -116,9 +134,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSetAttr(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSync:
@@ -127,9 +147,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSync(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdMkdir:
@@ -138,9 +160,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdMkdir(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSymLink:
@@ -149,9 +173,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSymLink(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdRemove:
@@ -160,9 +186,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdRemove(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdRmDir:
@@ -171,9 +199,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdRmDir(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdFsStat:
@@ -182,9 +212,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdFsStat(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdReadAt:
@@ -193,9 +225,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdReadAt(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdWriteAt:
@@ -206,10 +240,12 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 			s.releaseFastBuffer(dataBuf)
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			defer s.releaseFastBuffer(dataBuf)
 			s.cmdWriteAt(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdCopyFileRange:
@@ -218,9 +254,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdCopyFileRange(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdRename:
@@ -229,9 +267,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdRename(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSetAttrByFD:
@@ -240,9 +280,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSetAttrByFD(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdReadDirPlus:
@@ -251,9 +293,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdReadDirPlus(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdWriteStreamOpen:
@@ -264,6 +308,9 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 			s.releaseFastBuffer(dataBuf)
 			goto BadCmdFormat
 		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
 		s.cmdWriteStreamOpen(clientMark, req, dataBuf)
 		return
 	case wsfsprotocol.CmdWriteStreamData:
@@ -274,6 +321,9 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 			s.releaseFastBuffer(dataBuf)
 			goto BadCmdFormat
 		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
 		s.cmdWriteStreamData(clientMark, req, dataBuf)
 		return
 	case wsfsprotocol.CmdCloneFileRange:
@@ -282,9 +332,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdCloneFileRange(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdGetFileLock:
@@ -293,9 +345,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdGetFileLock(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSetFileLock:
@@ -304,9 +358,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSetFileLock(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSetFileLockWait:
@@ -315,9 +371,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdSetFileLockWait(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdLink:
@@ -326,9 +384,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdLink(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdSetXAttr:
@@ -339,10 +399,12 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 			s.releaseFastBuffer(dataBuf)
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			defer s.releaseFastBuffer(dataBuf)
 			s.cmdSetXAttr(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdGetXAttr:
@@ -351,9 +413,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdGetXAttr(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdListXAttr:
@@ -362,9 +426,11 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdListXAttr(clientMark, req)
-			return nil
 		})
 		return
 	case wsfsprotocol.CmdRemoveXAttr:
@@ -373,9 +439,62 @@ func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {
 		if err != nil {
 			goto BadCmdFormat
 		}
-		s.cmdGroup.Go(func() error {
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
 			s.cmdRemoveXAttr(clientMark, req)
-			return nil
+		})
+		return
+	case wsfsprotocol.CmdReadStreamOpen:
+		var req wsfsprotocol.CmdReadStreamOpenStruct
+		err := wsfsprotocol.ReadCmdReadStreamOpenStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.cmdReadStreamOpen(clientMark, req)
+		return
+	case wsfsprotocol.CmdReadStreamCredit:
+		var req wsfsprotocol.CmdReadStreamCreditStruct
+		err := wsfsprotocol.ReadCmdReadStreamCreditStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.cmdReadStreamCredit(clientMark, req)
+		return
+	case wsfsprotocol.CmdCompound:
+		var req wsfsprotocol.CmdCompoundStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdCompoundStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			defer s.releaseFastBuffer(dataBuf)
+			s.cmdCompound(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdGetAttrByFD:
+		var req wsfsprotocol.CmdGetAttrByFDStruct
+		err := wsfsprotocol.ReadCmdGetAttrByFDStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdGetAttrByFD(clientMark, req)
 		})
 		return
 	default:
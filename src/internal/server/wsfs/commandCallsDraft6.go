@@ -0,0 +1,456 @@
+// Code generated by 'genCommandCalls.go'. DO NOT EDIT.
+package wsfs
+
+import (
+	"io"
+	"wsfs-core/internal/share/wsfsprotocol"
+)
+
+func (s *session) doCommandCallDraft6(clientMark uint32, cmd uint8, r io.Reader) {
+	switch cmd {
+	case wsfsprotocol.CmdOpen:
+		var req wsfsprotocol.CmdOpenStruct
+		err := wsfsprotocol.ReadCmdOpenStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdOpen(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdClose:
+		var req wsfsprotocol.CmdCloseStruct
+		err := wsfsprotocol.ReadCmdCloseStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdClose(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdRead:
+		var req wsfsprotocol.CmdReadStruct
+		err := wsfsprotocol.ReadCmdReadStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdRead(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdReadDir:
+		var req wsfsprotocol.CmdReadDirStruct
+		err := wsfsprotocol.ReadCmdReadDirStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdReadDir(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdReadLink:
+		var req wsfsprotocol.CmdReadLinkStruct
+		err := wsfsprotocol.ReadCmdReadLinkStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdReadLink(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdWrite:
+		var req wsfsprotocol.CmdWriteStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdWriteStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			defer s.releaseFastBuffer(dataBuf)
+			s.cmdWrite(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSeek:
+		var req wsfsprotocol.CmdSeekStruct
+		err := wsfsprotocol.ReadCmdSeekStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSeek(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdAllocate:
+		var req wsfsprotocol.CmdAllocateStruct
+		err := wsfsprotocol.ReadCmdAllocateStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdAllocate(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdGetAttr:
+		var req wsfsprotocol.CmdGetAttrStruct
+		err := wsfsprotocol.ReadCmdGetAttrStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdGetAttr(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSetAttr:
+		var req wsfsprotocol.CmdSetAttrStruct
+		err := wsfsprotocol.ReadCmdSetAttrStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSetAttr(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSync:
+		var req wsfsprotocol.CmdSyncStruct
+		err := wsfsprotocol.ReadCmdSyncStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSync(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdMkdir:
+		var req wsfsprotocol.CmdMkdirStruct
+		err := wsfsprotocol.ReadCmdMkdirStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdMkdir(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSymLink:
+		var req wsfsprotocol.CmdSymLinkStruct
+		err := wsfsprotocol.ReadCmdSymLinkStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSymLink(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdRemove:
+		var req wsfsprotocol.CmdRemoveStruct
+		err := wsfsprotocol.ReadCmdRemoveStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdRemove(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdRmDir:
+		var req wsfsprotocol.CmdRmDirStruct
+		err := wsfsprotocol.ReadCmdRmDirStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdRmDir(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdFsStat:
+		var req wsfsprotocol.CmdFsStatStruct
+		err := wsfsprotocol.ReadCmdFsStatStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdFsStat(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdReadAt:
+		var req wsfsprotocol.CmdReadAtStruct
+		err := wsfsprotocol.ReadCmdReadAtStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdReadAt(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdWriteAt:
+		var req wsfsprotocol.CmdWriteAtStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdWriteAtStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			defer s.releaseFastBuffer(dataBuf)
+			s.cmdWriteAt(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdCopyFileRange:
+		var req wsfsprotocol.CmdCopyFileRangeStruct
+		err := wsfsprotocol.ReadCmdCopyFileRangeStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdCopyFileRange(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdRename:
+		var req wsfsprotocol.CmdRenameStruct
+		err := wsfsprotocol.ReadCmdRenameStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdRename(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSetAttrByFD:
+		var req wsfsprotocol.CmdSetAttrByFDStruct
+		err := wsfsprotocol.ReadCmdSetAttrByFDStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSetAttrByFD(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdReadDirPlus:
+		var req wsfsprotocol.CmdReadDirPlusStruct
+		err := wsfsprotocol.ReadCmdReadDirPlusStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdReadDirPlus(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdWriteStreamOpen:
+		var req wsfsprotocol.CmdWriteStreamOpenStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdWriteStreamOpenStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.cmdWriteStreamOpen(clientMark, req, dataBuf)
+		return
+	case wsfsprotocol.CmdWriteStreamData:
+		var req wsfsprotocol.CmdWriteStreamDataStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdWriteStreamDataStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.cmdWriteStreamData(clientMark, req, dataBuf)
+		return
+	case wsfsprotocol.CmdCloneFileRange:
+		var req wsfsprotocol.CmdCloneFileRangeStruct
+		err := wsfsprotocol.ReadCmdCloneFileRangeStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdCloneFileRange(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdGetFileLock:
+		var req wsfsprotocol.CmdGetFileLockStruct
+		err := wsfsprotocol.ReadCmdGetFileLockStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdGetFileLock(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSetFileLock:
+		var req wsfsprotocol.CmdSetFileLockStruct
+		err := wsfsprotocol.ReadCmdSetFileLockStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSetFileLock(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSetFileLockWait:
+		var req wsfsprotocol.CmdSetFileLockWaitStruct
+		err := wsfsprotocol.ReadCmdSetFileLockWaitStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdSetFileLockWait(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdLink:
+		var req wsfsprotocol.CmdLinkStruct
+		err := wsfsprotocol.ReadCmdLinkStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdLink(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdSetXAttr:
+		var req wsfsprotocol.CmdSetXAttrStruct
+		dataBuf := s.acquireFastBuffer()
+		err := wsfsprotocol.ReadCmdSetXAttrStructFromReaderWithBuffer(&req, r, dataBuf)
+		if err != nil {
+			s.releaseFastBuffer(dataBuf)
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			defer s.releaseFastBuffer(dataBuf)
+			s.cmdSetXAttr(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdGetXAttr:
+		var req wsfsprotocol.CmdGetXAttrStruct
+		err := wsfsprotocol.ReadCmdGetXAttrStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdGetXAttr(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdListXAttr:
+		var req wsfsprotocol.CmdListXAttrStruct
+		err := wsfsprotocol.ReadCmdListXAttrStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdListXAttr(clientMark, req)
+		})
+		return
+	case wsfsprotocol.CmdRemoveXAttr:
+		var req wsfsprotocol.CmdRemoveXAttrStruct
+		err := wsfsprotocol.ReadCmdRemoveXAttrStructFromReader(&req, r)
+		if err != nil {
+			goto BadCmdFormat
+		}
+		if s.tracing() {
+			s.traceCommand(clientMark, req)
+		}
+		s.goCommand(clientMark, func() {
+			s.cmdRemoveXAttr(clientMark, req)
+		})
+		return
+	default:
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "Unknown command")
+	}
+	return
+BadCmdFormat:
+	s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "Bad command format")
+	return
+}
@@ -29,18 +29,22 @@ func closeSFD(fd sfd_t) {
 	_ = (*os.File)(fd).Close()
 }
 
+func statSFD(fd sfd_t) (fs.FileInfo, error) {
+	return (*os.File)(fd).Stat()
+}
+
 func (s *session) convOwner(_ os.FileInfo) (ownerInfo uint8) {
 	return wsfsprotocol.OWNER_UG
 }
 
-func (s *session) cmdOpen(clientMark uint8, req wsfsprotocol.CmdOpenStruct) {
-	if !util.IsUrlValid(req.Path) {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
-		return
+// openFile opens a path of the storage with protocol open flags.
+func (s *session) openFile(path string, protocolOFlag uint32, fmode uint32) (sfd_t, uint8, string, bool) {
+	if !util.IsUrlValid(path) {
+		return nil, wsfsprotocol.ErrorInvalid, "bad path", false
 	}
 
 	oflag := 0
-	switch req.OFlag & wsfsprotocol.O_ACCMODE {
+	switch protocolOFlag & wsfsprotocol.O_ACCMODE {
 	case wsfsprotocol.O_RDONLY:
 		oflag |= wsfsstdconv.OpenFlagToStd[wsfsprotocol.O_RDONLY]
 	case wsfsprotocol.O_WRONLY:
@@ -48,54 +52,83 @@ func (s *session) cmdOpen(clientMark uint8, req wsfsprotocol.CmdOpenStruct) {
 	case wsfsprotocol.O_RDWR:
 		oflag |= wsfsstdconv.OpenFlagToStd[wsfsprotocol.O_RDWR]
 	default:
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad open access mode")
-		return
+		return nil, wsfsprotocol.ErrorInvalid, "bad open access mode", false
 	}
 	for _, proctocolFlag := range wsfsprotocol.OpenFlags {
 		if proctocolFlag&wsfsprotocol.O_ACCMODE != 0 {
 			continue
 		}
-		if req.OFlag&proctocolFlag != 0 {
+		if protocolOFlag&proctocolFlag != 0 {
 			unixFlag, ok := wsfsstdconv.OpenFlagToStd[proctocolFlag]
 			if !ok {
-				s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "not supported open flag")
-				return
+				return nil, wsfsprotocol.ErrorNotSupport, "not supported open flag", false
 			}
 			oflag |= unixFlag
 		}
 	}
 
-	sfd, err := openSFD(s.storage.Path+req.Path, oflag, fs.FileMode(req.FMode))
+	sfd, err := openSFD(s.storage.Path+path, oflag, fs.FileMode(fmode))
 	if err != nil {
-		s.writeRspError(clientMark, osErrCode(err), "syscall error")
+		return nil, osErrCode(err), "syscall error", false
+	}
+	return sfd_t(sfd), wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdOpen(clientMark uint32, req wsfsprotocol.CmdOpenStruct) {
+	if s.readOnly && wsfsprotocol.OpenFlagWrites(req.OFlag) {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorAccessRestricted, "Read-only session")
+		return
+	}
+	sfd, errCode, errDesc, ok := s.openFile(req.Path, req.OFlag, req.FMode)
+	if !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
 		return
 	}
 
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspOpenToWriter(wsfsprotocol.RspOpen{FD: s.newFD(sfd_t(sfd))}, s.writer)
-		s.writeDone(err)
+	wfd := s.newFD(sfd, openedFile{path: req.Path, oflag: req.OFlag})
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err := wsfsprotocol.WriteRspOpenToWriter(wsfsprotocol.RspOpen{FD: wfd}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdClose(clientMark uint8, req wsfsprotocol.CmdCloseStruct) {
-	rsfd, ok := s.fds.Load(req.FD)
+func sfdOffset(fd sfd_t) (int64, error) {
+	return (*os.File)(fd).Seek(0, io.SeekCurrent)
+}
+
+func seekSFD(fd sfd_t, offset int64) error {
+	_, err := (*os.File)(fd).Seek(offset, io.SeekStart)
+	return err
+}
+
+// closeFD closes a fd of the session.
+func (s *session) closeFD(fd uint32) (uint8, string, bool) {
+	rsfd, ok := s.fds.Load(fd)
 	if !ok {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
-		return
+		return wsfsprotocol.ErrorInvalidFD, "bad fd", false
 	}
-	s.fds.Delete(req.FD)
+	s.fds.Delete(fd)
+	s.openedFiles.Delete(fd)
+	s.stopReadStreams(fd)
 
 	if err := (*os.File)(rsfd.(sfd_t)).Close(); err != nil {
-		s.writeRspError(clientMark, osErrCode(err), "syscall error")
+		return osErrCode(err), "syscall error", false
+	}
+	return wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdClose(clientMark uint32, req wsfsprotocol.CmdCloseStruct) {
+	if errCode, errDesc, ok := s.closeFD(req.FD); !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
 		return
 	}
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) readAndSend(clientMark uint8, fd *os.File, size uint64, partial bool) (uint64, bool) {
-	buf := bufPool.Get().(*util.Buffer)
+func (s *session) readAndSend(clientMark uint32, fd *os.File, size uint64, partial bool) (uint64, bool) {
+	buf := s.getBuf()
 	defer putBuf(buf)
-	buf.Write([]byte{clientMark, wsfsprotocol.ErrorOK})
+	buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
 	readed, err := fd.Read(buf.Bytes[buf.Written():][:int(size)])
 	buf.Grow(readed)
 
@@ -104,13 +137,13 @@ func (s *session) readAndSend(clientMark uint8, fd *os.File, size uint64, partia
 		return 0, false
 	}
 	if partial && uint64(readed) == size {
-		buf.Bytes[1] = wsfsprotocol.ErrorPartialResponse
+		buf.Bytes[rspCodeIndex] = wsfsprotocol.ErrorPartialResponse
 	}
 	s.write(buf.Done())
 	return uint64(readed), true
 }
 
-func (s *session) cmdRead(clientMark uint8, req wsfsprotocol.CmdReadStruct) {
+func (s *session) cmdRead(clientMark uint32, req wsfsprotocol.CmdReadStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -118,27 +151,28 @@ func (s *session) cmdRead(clientMark uint8, req wsfsprotocol.CmdReadStruct) {
 	}
 	sfd := (*os.File)(rsfd.(sfd_t))
 
-	if req.Size < maxReadPayLoad {
+	maxReadPayload := s.maxReadPayload()
+	if req.Size < maxReadPayload {
 		s.readAndSend(clientMark, sfd, req.Size, false)
 		return
 	}
-	for range req.Size / maxReadPayLoad {
-		readed, ok := s.readAndSend(clientMark, sfd, maxReadPayLoad, true)
+	for range req.Size / maxReadPayload {
+		readed, ok := s.readAndSend(clientMark, sfd, maxReadPayload, true)
 		if !ok {
 			return
 		}
-		if readed < maxReadPayLoad {
+		if readed < maxReadPayload {
 			return
 		}
 	}
-	if req.Size%maxReadPayLoad == 0 {
+	if req.Size%maxReadPayload == 0 {
 		s.writeRspOK(clientMark)
 	} else {
-		s.readAndSend(clientMark, sfd, req.Size%maxReadPayLoad, false)
+		s.readAndSend(clientMark, sfd, req.Size%maxReadPayload, false)
 	}
 }
 
-func (s *session) cmdReadLink(clientMark uint8, req wsfsprotocol.CmdReadLinkStruct) {
+func (s *session) cmdReadLink(clientMark uint32, req wsfsprotocol.CmdReadLinkStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -158,13 +192,13 @@ func (s *session) cmdReadLink(clientMark uint8, req wsfsprotocol.CmdReadLinkStru
 		return
 	}
 
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspReadLinkToWriter(wsfsprotocol.RspReadLink{TargetPath: strings.TrimPrefix(target, s.storage.Path)}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspReadLinkToWriter(wsfsprotocol.RspReadLink{TargetPath: strings.TrimPrefix(target, s.storage.Path)}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdSeek(clientMark uint8, req wsfsprotocol.CmdSeekStruct) {
+func (s *session) cmdSeek(clientMark uint32, req wsfsprotocol.CmdSeekStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -182,13 +216,13 @@ func (s *session) cmdSeek(clientMark uint8, req wsfsprotocol.CmdSeekStruct) {
 		s.writeRspError(clientMark, osErrCode(err), "syscall error")
 		return
 	}
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspSeekToWriter(wsfsprotocol.RspSeek{Offset: uint64(offset)}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspSeekToWriter(wsfsprotocol.RspSeek{Offset: uint64(offset)}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdWrite(clientMark uint8, req wsfsprotocol.CmdWriteStruct) {
+func (s *session) cmdWrite(clientMark uint32, req wsfsprotocol.CmdWriteStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -199,17 +233,17 @@ func (s *session) cmdWrite(clientMark uint8, req wsfsprotocol.CmdWriteStruct) {
 		s.writeRspError(clientMark, osErrCode(err), "syscall error")
 		return
 	}
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspWriteToWriter(wsfsprotocol.RspWrite{Written: uint64(count)}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspWriteToWriter(wsfsprotocol.RspWrite{Written: uint64(count)}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdAllocate(clientMark uint8, _ wsfsprotocol.CmdAllocateStruct) {
+func (s *session) cmdAllocate(clientMark uint32, _ wsfsprotocol.CmdAllocateStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdSetAttr(clientMark uint8, req wsfsprotocol.CmdSetAttrStruct) {
+func (s *session) cmdSetAttr(clientMark uint32, req wsfsprotocol.CmdSetAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -230,7 +264,7 @@ func (s *session) cmdSetAttr(clientMark uint8, req wsfsprotocol.CmdSetAttrStruct
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdSync(clientMark uint8, req wsfsprotocol.CmdSyncStruct) {
+func (s *session) cmdSync(clientMark uint32, req wsfsprotocol.CmdSyncStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -243,19 +277,25 @@ func (s *session) cmdSync(clientMark uint8, req wsfsprotocol.CmdSyncStruct) {
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdMkdir(clientMark uint8, req wsfsprotocol.CmdMkdirStruct) {
-	if !util.IsUrlValid(req.Path) {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
-		return
+func (s *session) mkdir(path string, mode uint32) (uint8, string, bool) {
+	if !util.IsUrlValid(path) {
+		return wsfsprotocol.ErrorInvalid, "bad path", false
 	}
-	if err := os.Mkdir(s.storage.Path+req.Path, fs.FileMode(req.Mode)); err != nil {
-		s.writeRspError(clientMark, osErrCode(err), "syscall error")
+	if err := os.Mkdir(s.storage.Path+path, fs.FileMode(mode)); err != nil {
+		return osErrCode(err), "syscall error", false
+	}
+	return wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdMkdir(clientMark uint32, req wsfsprotocol.CmdMkdirStruct) {
+	if errCode, errDesc, ok := s.mkdir(req.Path, req.Mode); !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
 		return
 	}
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdSymLink(clientMark uint8, req wsfsprotocol.CmdSymLinkStruct) {
+func (s *session) cmdSymLink(clientMark uint32, req wsfsprotocol.CmdSymLinkStruct) {
 	if !util.IsUrlValid(req.TargetPath) || !util.IsUrlValid(req.FilePath) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -267,7 +307,7 @@ func (s *session) cmdSymLink(clientMark uint8, req wsfsprotocol.CmdSymLinkStruct
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdRemove(clientMark uint8, req wsfsprotocol.CmdRemoveStruct) {
+func (s *session) cmdRemove(clientMark uint32, req wsfsprotocol.CmdRemoveStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -280,15 +320,15 @@ func (s *session) cmdRemove(clientMark uint8, req wsfsprotocol.CmdRemoveStruct)
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdRmDir(clientMark uint8, req wsfsprotocol.CmdRmDirStruct) {
+func (s *session) cmdRmDir(clientMark uint32, req wsfsprotocol.CmdRmDirStruct) {
 	//log.Debug().Uint8("Cm", clientMark).Str("Path", req.Path).Msg("Removing directory")
 	s.cmdRemove(clientMark, wsfsprotocol.CmdRemoveStruct{Path: req.Path})
 }
 
-func (s *session) readAtAndSend(clientMark uint8, fd *os.File, off uint64, size uint64, partial bool) (uint64, bool) {
-	buf := bufPool.Get().(*util.Buffer)
+func (s *session) readAtAndSend(clientMark uint32, fd *os.File, off uint64, size uint64, partial bool) (uint64, bool) {
+	buf := s.getBuf()
 	defer putBuf(buf)
-	buf.Write([]byte{clientMark, wsfsprotocol.ErrorOK})
+	buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
 	readed, err := fd.ReadAt(buf.Bytes[buf.Written():][:int(size)], int64(off))
 	buf.Grow(readed)
 
@@ -297,13 +337,13 @@ func (s *session) readAtAndSend(clientMark uint8, fd *os.File, off uint64, size
 		return 0, false
 	}
 	if partial && uint64(readed) == size {
-		buf.Bytes[1] = wsfsprotocol.ErrorPartialResponse
+		buf.Bytes[rspCodeIndex] = wsfsprotocol.ErrorPartialResponse
 	}
 	s.write(buf.Done())
 	return uint64(readed), true
 }
 
-func (s *session) cmdReadAt(clientMark uint8, req wsfsprotocol.CmdReadAtStruct) {
+func (s *session) cmdReadAt(clientMark uint32, req wsfsprotocol.CmdReadAtStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -312,28 +352,29 @@ func (s *session) cmdReadAt(clientMark uint8, req wsfsprotocol.CmdReadAtStruct)
 	sfd := (*os.File)(rsfd.(sfd_t))
 	off := req.Offset
 
-	if req.Size < maxReadPayLoad {
+	maxReadPayload := s.maxReadPayload()
+	if req.Size < maxReadPayload {
 		s.readAtAndSend(clientMark, sfd, off, req.Size, false)
 		return
 	}
-	for range req.Size / maxReadPayLoad {
-		readed, ok := s.readAtAndSend(clientMark, sfd, off, maxReadPayLoad, true)
+	for range req.Size / maxReadPayload {
+		readed, ok := s.readAtAndSend(clientMark, sfd, off, maxReadPayload, true)
 		if !ok {
 			return
 		}
-		if readed < maxReadPayLoad {
+		if readed < maxReadPayload {
 			return
 		}
 		off += readed
 	}
-	if req.Size%maxReadPayLoad == 0 {
+	if req.Size%maxReadPayload == 0 {
 		s.writeRspOK(clientMark)
 	} else {
-		s.readAtAndSend(clientMark, sfd, off, req.Size%maxReadPayLoad, false)
+		s.readAtAndSend(clientMark, sfd, off, req.Size%maxReadPayload, false)
 	}
 }
 
-func (s *session) cmdWriteAt(clientMark uint8, req wsfsprotocol.CmdWriteAtStruct) {
+func (s *session) cmdWriteAt(clientMark uint32, req wsfsprotocol.CmdWriteAtStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -344,10 +385,18 @@ func (s *session) cmdWriteAt(clientMark uint8, req wsfsprotocol.CmdWriteAtStruct
 		s.writeRspError(clientMark, osErrCode(err), "syscall error")
 		return
 	}
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspWriteAtToWriter(wsfsprotocol.RspWriteAt{Written: uint64(count)}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspWriteAtToWriter(wsfsprotocol.RspWriteAt{Written: uint64(count)}, c.writer)
+		c.writeDone(err)
+	}
+}
+
+func readChunkAt(fd sfd_t, offset uint64, buf []byte) (int, uint8, string, bool) {
+	readed, err := (*os.File)(fd).ReadAt(buf, int64(offset))
+	if err != nil && err != io.EOF {
+		return 0, osErrCode(err), "syscall error", false
 	}
+	return readed, 0, "", true
 }
 
 func writeStreamWriteChunk(fd sfd_t, offset uint64, data []byte) (uint64, uint8, string, bool) {
@@ -367,27 +416,27 @@ func writeStreamWriteChunk(fd sfd_t, offset uint64, data []byte) (uint64, uint8,
 	return totalWritten, 0, "", true
 }
 
-func (s *session) cmdCopyFileRange(clientMark uint8, _ wsfsprotocol.CmdCopyFileRangeStruct) {
+func (s *session) cmdCopyFileRange(clientMark uint32, _ wsfsprotocol.CmdCopyFileRangeStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdCloneFileRange(clientMark uint8, _ wsfsprotocol.CmdCloneFileRangeStruct) {
+func (s *session) cmdCloneFileRange(clientMark uint32, _ wsfsprotocol.CmdCloneFileRangeStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdGetFileLock(clientMark uint8, _ wsfsprotocol.CmdGetFileLockStruct) {
+func (s *session) cmdGetFileLock(clientMark uint32, _ wsfsprotocol.CmdGetFileLockStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdSetFileLock(clientMark uint8, _ wsfsprotocol.CmdSetFileLockStruct) {
+func (s *session) cmdSetFileLock(clientMark uint32, _ wsfsprotocol.CmdSetFileLockStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdSetFileLockWait(clientMark uint8, _ wsfsprotocol.CmdSetFileLockWaitStruct) {
+func (s *session) cmdSetFileLockWait(clientMark uint32, _ wsfsprotocol.CmdSetFileLockWaitStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
 
-func (s *session) cmdRename(clientMark uint8, req wsfsprotocol.CmdRenameStruct) {
+func (s *session) cmdRename(clientMark uint32, req wsfsprotocol.CmdRenameStruct) {
 	if !util.IsUrlValid(req.OldPath) || !util.IsUrlValid(req.NewPath) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -405,7 +454,7 @@ func (s *session) cmdRename(clientMark uint8, req wsfsprotocol.CmdRenameStruct)
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdSetAttrByFD(clientMark uint8, req wsfsprotocol.CmdSetAttrByFDStruct) {
+func (s *session) cmdSetAttrByFD(clientMark uint32, req wsfsprotocol.CmdSetAttrByFDStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -420,7 +469,7 @@ func (s *session) cmdSetAttrByFD(clientMark uint8, req wsfsprotocol.CmdSetAttrBy
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdFsStat(clientMark uint8, req wsfsprotocol.CmdFsStatStruct) {
+func (s *session) cmdFsStat(clientMark uint32, req wsfsprotocol.CmdFsStatStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -430,12 +479,12 @@ func (s *session) cmdFsStat(clientMark uint8, req wsfsprotocol.CmdFsStatStruct)
 		s.writeRspError(clientMark, osErrCode(err), "syscall error")
 		return
 	}
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspFsStatToWriter(wsfsprotocol.RspFsStat{Total: total, Free: free, Available: avail}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspFsStatToWriter(wsfsprotocol.RspFsStat{Total: total, Free: free, Available: avail}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdLink(clientMark uint8, _ wsfsprotocol.CmdLinkStruct) {
+func (s *session) cmdLink(clientMark uint32, _ wsfsprotocol.CmdLinkStruct) {
 	s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "syscall error")
 }
@@ -4,6 +4,7 @@ package wsfs
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -26,6 +27,23 @@ func closeSFD(fd sfd_t) {
 	_ = syscall.Close(int(fd))
 }
 
+// statSFD stats an open file through a dup of it, since closing the os.File
+// closes its fd.
+func statSFD(fd sfd_t) (fs.FileInfo, error) {
+	syscall.ForkLock.RLock()
+	dup, err := syscall.Dup(int(fd))
+	if err == nil {
+		syscall.CloseOnExec(dup)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(dup), "")
+	defer f.Close()
+	return f.Stat()
+}
+
 // copy from src/os/file.go, modifed.
 // Copyright 2009 The Go Authors. All rights reserved.
 // syscallMode returns the syscall-specific mode bits from Go's portable mode bits.
@@ -111,15 +129,15 @@ func (s *session) convOwner(fi os.FileInfo) (ownerInfo uint8) {
 	return
 }
 
-func (s *session) cmdOpen(clientMark uint8, req wsfsprotocol.CmdOpenStruct) {
-	if !util.IsUrlValid(req.Path) {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
-		return
+// openFile opens a path of the storage with protocol open flags.
+func (s *session) openFile(path string, protocolOFlag uint32, fmode uint32) (sfd_t, uint8, string, bool) {
+	if !util.IsUrlValid(path) {
+		return 0, wsfsprotocol.ErrorInvalid, "bad path", false
 	}
-	apath := s.storage.Path + req.Path
+	apath := s.storage.Path + path
 
 	oflag := 0
-	switch req.OFlag & wsfsprotocol.O_ACCMODE {
+	switch protocolOFlag & wsfsprotocol.O_ACCMODE {
 	case wsfsprotocol.O_RDONLY:
 		oflag |= wsfsunixconv.OpenFlagToUnix[wsfsprotocol.O_RDONLY]
 	case wsfsprotocol.O_WRONLY:
@@ -127,18 +145,16 @@ func (s *session) cmdOpen(clientMark uint8, req wsfsprotocol.CmdOpenStruct) {
 	case wsfsprotocol.O_RDWR:
 		oflag |= wsfsunixconv.OpenFlagToUnix[wsfsprotocol.O_RDWR]
 	default:
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad open access mode")
-		return
+		return 0, wsfsprotocol.ErrorInvalid, "bad open access mode", false
 	}
 	for _, proctocolFlag := range wsfsprotocol.OpenFlags {
 		if proctocolFlag&wsfsprotocol.O_ACCMODE != 0 {
 			continue
 		}
-		if req.OFlag&proctocolFlag != 0 {
+		if protocolOFlag&proctocolFlag != 0 {
 			unixFlag, ok := wsfsunixconv.OpenFlagToUnix[proctocolFlag]
 			if !ok {
-				s.writeRspError(clientMark, wsfsprotocol.ErrorNotSupport, "not supported open flag")
-				return
+				return 0, wsfsprotocol.ErrorNotSupport, "not supported open flag", false
 			}
 			oflag |= unixFlag
 		}
@@ -147,48 +163,77 @@ func (s *session) cmdOpen(clientMark uint8, req wsfsprotocol.CmdOpenStruct) {
 	var sfd int
 	var err error
 	ignoringEINTR(func() error {
-		sfd, err = syscall.Open(apath, oflag, syscallMode(fs.FileMode(req.FMode)))
+		sfd, err = syscall.Open(apath, oflag, syscallMode(fs.FileMode(fmode)))
 		return err
 	})
-
 	if err != nil {
-		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
-	} else {
-		wfd := s.newFD(sfd_t(sfd))
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspOpenToWriter(wsfsprotocol.RspOpen{FD: wfd}, s.writer)
-			s.writeDone(err)
-		}
+		return 0, wsfsErrCode(err), "syscall error", false
 	}
+	return sfd_t(sfd), wsfsprotocol.ErrorOK, "", true
 }
 
-func (s *session) cmdClose(clientMark uint8, req wsfsprotocol.CmdCloseStruct) {
-
-	rsfd, ok := s.fds.Load(req.FD)
+func (s *session) cmdOpen(clientMark uint32, req wsfsprotocol.CmdOpenStruct) {
+	if s.readOnly && wsfsprotocol.OpenFlagWrites(req.OFlag) {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorAccessRestricted, "Read-only session")
+		return
+	}
+	sfd, errCode, errDesc, ok := s.openFile(req.Path, req.OFlag, req.FMode)
 	if !ok {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
+		s.writeRspError(clientMark, errCode, errDesc)
 		return
 	}
+
+	wfd := s.newFD(sfd, openedFile{path: req.Path, oflag: req.OFlag})
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err := wsfsprotocol.WriteRspOpenToWriter(wsfsprotocol.RspOpen{FD: wfd}, c.writer)
+		c.writeDone(err)
+	}
+}
+
+func sfdOffset(fd sfd_t) (int64, error) {
+	return syscall.Seek(int(fd), 0, io.SeekCurrent)
+}
+
+func seekSFD(fd sfd_t, offset int64) error {
+	_, err := syscall.Seek(int(fd), offset, io.SeekStart)
+	return err
+}
+
+// closeFD closes a fd of the session.
+func (s *session) closeFD(fd uint32) (uint8, string, bool) {
+	rsfd, ok := s.fds.Load(fd)
+	if !ok {
+		return wsfsprotocol.ErrorInvalidFD, "bad fd", false
+	}
 	sfd := rsfd.(sfd_t)
 
 	// TODO: Carefully handle EINTR
 	// when close() return EINTR. Linux and AIX typically close the file
 	// descriptor despite interruption, whereas HPUX may keep the descriptor
 	// open.
-	s.fds.Delete(req.FD)
+	s.fds.Delete(fd)
+	s.openedFiles.Delete(fd)
+	s.stopReadStreams(fd)
 	err := syscall.Close(int(sfd))
 
 	if err != nil {
-		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
+		return wsfsErrCode(err), "syscall error", false
+	}
+	return wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdClose(clientMark uint32, req wsfsprotocol.CmdCloseStruct) {
+	if errCode, errDesc, ok := s.closeFD(req.FD); !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
 	} else {
 		s.writeRspOK(clientMark)
 	}
 }
 
-func (s *session) readAndSend(clientMark uint8, fd int, size uint64, partial bool) (uint64, bool) {
-	buf := bufPool.Get().(*util.Buffer)
+func (s *session) readAndSend(clientMark uint32, fd int, size uint64, partial bool) (uint64, bool) {
+	buf := s.getBuf()
 	defer putBuf(buf)
-	buf.Write([]byte{clientMark, wsfsprotocol.ErrorOK})
+	buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
 	readed, err := syscall.Read(fd, buf.Bytes[buf.Written():][:int(size)])
 	buf.Grow(readed)
 
@@ -197,13 +242,13 @@ func (s *session) readAndSend(clientMark uint8, fd int, size uint64, partial boo
 		return 0, false
 	}
 	if partial && uint64(readed) == size {
-		buf.Bytes[1] = wsfsprotocol.ErrorPartialResponse
+		buf.Bytes[rspCodeIndex] = wsfsprotocol.ErrorPartialResponse
 	}
 	s.write(buf.Done())
 	return uint64(readed), true
 }
 
-func (s *session) cmdRead(clientMark uint8, req wsfsprotocol.CmdReadStruct) {
+func (s *session) cmdRead(clientMark uint32, req wsfsprotocol.CmdReadStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -211,27 +256,28 @@ func (s *session) cmdRead(clientMark uint8, req wsfsprotocol.CmdReadStruct) {
 	}
 	sfd := rsfd.(sfd_t)
 
-	if req.Size < maxReadPayLoad {
+	maxReadPayload := s.maxReadPayload()
+	if req.Size < maxReadPayload {
 		s.readAndSend(clientMark, int(sfd), req.Size, false)
 	} else {
-		for range req.Size / maxReadPayLoad {
-			readed, ok := s.readAndSend(clientMark, int(sfd), maxReadPayLoad, true)
+		for range req.Size / maxReadPayload {
+			readed, ok := s.readAndSend(clientMark, int(sfd), maxReadPayload, true)
 			if !ok {
 				return
 			}
-			if readed < maxReadPayLoad {
+			if readed < maxReadPayload {
 				return
 			}
 		}
-		if req.Size%maxReadPayLoad == 0 {
+		if req.Size%maxReadPayload == 0 {
 			s.writeRspOK(clientMark)
 		} else {
-			s.readAndSend(clientMark, int(sfd), req.Size%maxReadPayLoad, false)
+			s.readAndSend(clientMark, int(sfd), req.Size%maxReadPayload, false)
 		}
 	}
 }
 
-func (s *session) cmdReadLink(clientMark uint8, req wsfsprotocol.CmdReadLinkStruct) {
+func (s *session) cmdReadLink(clientMark uint32, req wsfsprotocol.CmdReadLinkStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -258,9 +304,9 @@ func (s *session) cmdReadLink(clientMark uint8, req wsfsprotocol.CmdReadLinkStru
 
 	if strings.HasPrefix(target, s.storage.Path) {
 		target = strings.TrimPrefix(target, s.storage.Path)
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspReadLinkToWriter(wsfsprotocol.RspReadLink{TargetPath: target}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspReadLinkToWriter(wsfsprotocol.RspReadLink{TargetPath: target}, c.writer)
+			c.writeDone(err)
 		}
 	} else {
 		// we will handle this kind symlinks in getattr and readdir to
@@ -269,7 +315,7 @@ func (s *session) cmdReadLink(clientMark uint8, req wsfsprotocol.CmdReadLinkStru
 	}
 }
 
-func (s *session) cmdSeek(clientMark uint8, req wsfsprotocol.CmdSeekStruct) {
+func (s *session) cmdSeek(clientMark uint32, req wsfsprotocol.CmdSeekStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -289,14 +335,14 @@ func (s *session) cmdSeek(clientMark uint8, req wsfsprotocol.CmdSeekStruct) {
 	if err != nil {
 		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
 	} else {
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspSeekToWriter(wsfsprotocol.RspSeek{Offset: uint64(offset)}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspSeekToWriter(wsfsprotocol.RspSeek{Offset: uint64(offset)}, c.writer)
+			c.writeDone(err)
 		}
 	}
 }
 
-func (s *session) cmdWrite(clientMark uint8, req wsfsprotocol.CmdWriteStruct) {
+func (s *session) cmdWrite(clientMark uint32, req wsfsprotocol.CmdWriteStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -314,14 +360,14 @@ func (s *session) cmdWrite(clientMark uint8, req wsfsprotocol.CmdWriteStruct) {
 	if err != nil {
 		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
 	} else {
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspWriteToWriter(wsfsprotocol.RspWrite{Written: uint64(count)}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspWriteToWriter(wsfsprotocol.RspWrite{Written: uint64(count)}, c.writer)
+			c.writeDone(err)
 		}
 	}
 }
 
-func (s *session) cmdAllocate(clientMark uint8, req wsfsprotocol.CmdAllocateStruct) {
+func (s *session) cmdAllocate(clientMark uint32, req wsfsprotocol.CmdAllocateStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -338,7 +384,7 @@ func (s *session) cmdAllocate(clientMark uint8, req wsfsprotocol.CmdAllocateStru
 	}
 }
 
-func (s *session) cmdSetAttr(clientMark uint8, req wsfsprotocol.CmdSetAttrStruct) {
+func (s *session) cmdSetAttr(clientMark uint32, req wsfsprotocol.CmdSetAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -384,7 +430,7 @@ func (s *session) cmdSetAttr(clientMark uint8, req wsfsprotocol.CmdSetAttrStruct
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdSync(clientMark uint8, req wsfsprotocol.CmdSyncStruct) {
+func (s *session) cmdSync(clientMark uint32, req wsfsprotocol.CmdSyncStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -401,23 +447,29 @@ func (s *session) cmdSync(clientMark uint8, req wsfsprotocol.CmdSyncStruct) {
 	}
 }
 
-func (s *session) cmdMkdir(clientMark uint8, req wsfsprotocol.CmdMkdirStruct) {
-	if !util.IsUrlValid(req.Path) {
-		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
-		return
+func (s *session) mkdir(path string, mode uint32) (uint8, string, bool) {
+	if !util.IsUrlValid(path) {
+		return wsfsprotocol.ErrorInvalid, "bad path", false
 	}
-	apath := s.storage.Path + req.Path
+	apath := s.storage.Path + path
 
-	err := syscall.Mkdir(apath, syscallMode(fs.FileMode(req.Mode)))
+	err := syscall.Mkdir(apath, syscallMode(fs.FileMode(mode)))
 
 	if err != nil {
-		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
+		return wsfsErrCode(err), "syscall error", false
+	}
+	return wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdMkdir(clientMark uint32, req wsfsprotocol.CmdMkdirStruct) {
+	if errCode, errDesc, ok := s.mkdir(req.Path, req.Mode); !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
 	} else {
 		s.writeRspOK(clientMark)
 	}
 }
 
-func (s *session) cmdSymLink(clientMark uint8, req wsfsprotocol.CmdSymLinkStruct) {
+func (s *session) cmdSymLink(clientMark uint32, req wsfsprotocol.CmdSymLinkStruct) {
 	if !util.IsUrlValid(req.TargetPath) || !util.IsUrlValid(req.FilePath) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -434,7 +486,7 @@ func (s *session) cmdSymLink(clientMark uint8, req wsfsprotocol.CmdSymLinkStruct
 	}
 }
 
-func (s *session) cmdRemove(clientMark uint8, req wsfsprotocol.CmdRemoveStruct) {
+func (s *session) cmdRemove(clientMark uint32, req wsfsprotocol.CmdRemoveStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -450,7 +502,7 @@ func (s *session) cmdRemove(clientMark uint8, req wsfsprotocol.CmdRemoveStruct)
 	}
 }
 
-func (s *session) cmdRmDir(clientMark uint8, req wsfsprotocol.CmdRmDirStruct) {
+func (s *session) cmdRmDir(clientMark uint32, req wsfsprotocol.CmdRmDirStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -466,7 +518,7 @@ func (s *session) cmdRmDir(clientMark uint8, req wsfsprotocol.CmdRmDirStruct) {
 	}
 }
 
-func (s *session) cmdFsStat(clientMark uint8, req wsfsprotocol.CmdFsStatStruct) {
+func (s *session) cmdFsStat(clientMark uint32, req wsfsprotocol.CmdFsStatStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -478,17 +530,17 @@ func (s *session) cmdFsStat(clientMark uint8, req wsfsprotocol.CmdFsStatStruct)
 	if err != nil {
 		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
 	} else {
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspFsStatToWriter(wsfsprotocol.RspFsStat{Total: total, Free: free, Available: avail}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspFsStatToWriter(wsfsprotocol.RspFsStat{Total: total, Free: free, Available: avail}, c.writer)
+			c.writeDone(err)
 		}
 	}
 }
 
-func (s *session) readAtAndSend(clientMark uint8, fd int, off uint64, size uint64, partial bool) (uint64, bool) {
-	buf := bufPool.Get().(*util.Buffer)
+func (s *session) readAtAndSend(clientMark uint32, fd int, off uint64, size uint64, partial bool) (uint64, bool) {
+	buf := s.getBuf()
 	defer putBuf(buf)
-	buf.Write([]byte{clientMark, wsfsprotocol.ErrorOK})
+	buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
 	readed, err := syscall.Pread(fd, buf.Bytes[buf.Written():][:int(size)], int64(off))
 	buf.Grow(readed)
 
@@ -497,13 +549,13 @@ func (s *session) readAtAndSend(clientMark uint8, fd int, off uint64, size uint6
 		return 0, false
 	}
 	if partial && uint64(readed) == size {
-		buf.Bytes[1] = wsfsprotocol.ErrorPartialResponse
+		buf.Bytes[rspCodeIndex] = wsfsprotocol.ErrorPartialResponse
 	}
 	s.write(buf.Done())
 	return uint64(readed), true
 }
 
-func (s *session) cmdReadAt(clientMark uint8, req wsfsprotocol.CmdReadAtStruct) {
+func (s *session) cmdReadAt(clientMark uint32, req wsfsprotocol.CmdReadAtStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -512,28 +564,29 @@ func (s *session) cmdReadAt(clientMark uint8, req wsfsprotocol.CmdReadAtStruct)
 	sfd := rsfd.(sfd_t)
 	off := req.Offset
 
-	if req.Size < maxReadPayLoad {
+	maxReadPayload := s.maxReadPayload()
+	if req.Size < maxReadPayload {
 		s.readAtAndSend(clientMark, int(sfd), off, req.Size, false)
 	} else {
-		for range req.Size / maxReadPayLoad {
-			readed, ok := s.readAtAndSend(clientMark, int(sfd), off, maxReadPayLoad, true)
+		for range req.Size / maxReadPayload {
+			readed, ok := s.readAtAndSend(clientMark, int(sfd), off, maxReadPayload, true)
 			if !ok {
 				return
 			}
-			if readed < maxReadPayLoad {
+			if readed < maxReadPayload {
 				return
 			}
 			off += readed
 		}
-		if req.Size%maxReadPayLoad == 0 {
+		if req.Size%maxReadPayload == 0 {
 			s.writeRspOK(clientMark)
 		} else {
-			s.readAtAndSend(clientMark, int(sfd), off, req.Size%maxReadPayLoad, false)
+			s.readAtAndSend(clientMark, int(sfd), off, req.Size%maxReadPayload, false)
 		}
 	}
 }
 
-func (s *session) cmdWriteAt(clientMark uint8, req wsfsprotocol.CmdWriteAtStruct) {
+func (s *session) cmdWriteAt(clientMark uint32, req wsfsprotocol.CmdWriteAtStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -551,13 +604,26 @@ func (s *session) cmdWriteAt(clientMark uint8, req wsfsprotocol.CmdWriteAtStruct
 	if err != nil {
 		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
 	} else {
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspWriteAtToWriter(wsfsprotocol.RspWriteAt{Written: uint64(count)}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspWriteAtToWriter(wsfsprotocol.RspWriteAt{Written: uint64(count)}, c.writer)
+			c.writeDone(err)
 		}
 	}
 }
 
+func readChunkAt(fd sfd_t, offset uint64, buf []byte) (int, uint8, string, bool) {
+	var readed int
+	var err error
+	ignoringEINTR(func() error {
+		readed, err = syscall.Pread(int(fd), buf, int64(offset))
+		return err
+	})
+	if err != nil {
+		return 0, wsfsErrCode(err), "syscall error", false
+	}
+	return readed, 0, "", true
+}
+
 func writeStreamWriteChunk(fd sfd_t, offset uint64, data []byte) (uint64, uint8, string, bool) {
 	var totalWritten uint64
 	for len(data) > 0 {
@@ -580,7 +646,7 @@ func writeStreamWriteChunk(fd sfd_t, offset uint64, data []byte) (uint64, uint8,
 	return totalWritten, 0, "", true
 }
 
-func (s *session) cmdCopyFileRange(clientMark uint8, req wsfsprotocol.CmdCopyFileRangeStruct) {
+func (s *session) cmdCopyFileRange(clientMark uint32, req wsfsprotocol.CmdCopyFileRangeStruct) {
 	if req.Size > wsfsprotocol.MaxCopyFileRangeChunk {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "copy_file_range size exceeds limit")
 		return
@@ -607,14 +673,14 @@ func (s *session) cmdCopyFileRange(clientMark uint8, req wsfsprotocol.CmdCopyFil
 	if err != nil {
 		s.writeRspError(clientMark, wsfsErrCode(err), "syscall error")
 	} else {
-		if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-			err = wsfsprotocol.WriteRspCopyFileRangeToWriter(wsfsprotocol.RspCopyFileRange{Copied: uint64(writed)}, s.writer)
-			s.writeDone(err)
+		if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+			err = wsfsprotocol.WriteRspCopyFileRangeToWriter(wsfsprotocol.RspCopyFileRange{Copied: uint64(writed)}, c.writer)
+			c.writeDone(err)
 		}
 	}
 }
 
-func (s *session) cmdCloneFileRange(clientMark uint8, req wsfsprotocol.CmdCloneFileRangeStruct) {
+func (s *session) cmdCloneFileRange(clientMark uint32, req wsfsprotocol.CmdCloneFileRangeStruct) {
 	rsfd1, ok := s.fds.Load(req.SrcFD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -637,7 +703,7 @@ func (s *session) cmdCloneFileRange(clientMark uint8, req wsfsprotocol.CmdCloneF
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdRename(clientMark uint8, req wsfsprotocol.CmdRenameStruct) {
+func (s *session) cmdRename(clientMark uint32, req wsfsprotocol.CmdRenameStruct) {
 	if !util.IsUrlValid(req.OldPath) || !util.IsUrlValid(req.NewPath) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -668,7 +734,7 @@ func (s *session) cmdRename(clientMark uint8, req wsfsprotocol.CmdRenameStruct)
 	}
 }
 
-func (s *session) cmdSetAttrByFD(clientMark uint8, req wsfsprotocol.CmdSetAttrByFDStruct) {
+func (s *session) cmdSetAttrByFD(clientMark uint32, req wsfsprotocol.CmdSetAttrByFDStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -715,7 +781,7 @@ func (s *session) cmdSetAttrByFD(clientMark uint8, req wsfsprotocol.CmdSetAttrBy
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdGetFileLock(clientMark uint8, req wsfsprotocol.CmdGetFileLockStruct) {
+func (s *session) cmdGetFileLock(clientMark uint32, req wsfsprotocol.CmdGetFileLockStruct) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -728,21 +794,21 @@ func (s *session) cmdGetFileLock(clientMark uint8, req wsfsprotocol.CmdGetFileLo
 		return
 	}
 
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		err = wsfsprotocol.WriteRspGetFileLockToWriter(wsfsprotocol.RspGetFileLock{FileLock: outLock}, s.writer)
-		s.writeDone(err)
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err = wsfsprotocol.WriteRspGetFileLockToWriter(wsfsprotocol.RspGetFileLock{FileLock: outLock}, c.writer)
+		c.writeDone(err)
 	}
 }
 
-func (s *session) cmdSetFileLock(clientMark uint8, req wsfsprotocol.CmdSetFileLockStruct) {
+func (s *session) cmdSetFileLock(clientMark uint32, req wsfsprotocol.CmdSetFileLockStruct) {
 	s.cmdSetFileLockCommon(clientMark, req.FD, req.FileLock, false)
 }
 
-func (s *session) cmdSetFileLockWait(clientMark uint8, req wsfsprotocol.CmdSetFileLockWaitStruct) {
+func (s *session) cmdSetFileLockWait(clientMark uint32, req wsfsprotocol.CmdSetFileLockWaitStruct) {
 	s.cmdSetFileLockCommon(clientMark, req.FD, req.FileLock, true)
 }
 
-func (s *session) cmdSetFileLockCommon(clientMark uint8, fd uint32, lock wsfsprotocol.FileLockInfo, blocking bool) {
+func (s *session) cmdSetFileLockCommon(clientMark uint32, fd uint32, lock wsfsprotocol.FileLockInfo, blocking bool) {
 	rsfd, ok := s.fds.Load(fd)
 	if !ok {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
@@ -755,7 +821,7 @@ func (s *session) cmdSetFileLockCommon(clientMark uint8, fd uint32, lock wsfspro
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdLink(clientMark uint8, req wsfsprotocol.CmdLinkStruct) {
+func (s *session) cmdLink(clientMark uint32, req wsfsprotocol.CmdLinkStruct) {
 	if !util.IsUrlValid(req.TargetPath) || !util.IsUrlValid(req.FilePath) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -9,8 +9,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
 
 	"wsfs-core/internal/server/wsfs/timeval"
 	"wsfs-core/internal/server/wsfs/xattr"
@@ -21,45 +21,54 @@ import (
 )
 
 //go:generate go run genCommandCalls.go -- "../../share/wsfsprotocol/const.go" "commandCalls.go" "wsfs"
-
-const (
-	MaxMsgSize                     = wsfsprotocol.MaxMsgSize
-	maxReadPayLoad          uint64 = uint64(MaxMsgSize) - 2
-	maxXAttrResponsePayload        = wsfsprotocol.MaxResponseLength - 2
-)
+//go:generate go run genCommandCalls.go -- "../../share/wsfsprotocol/const.go" "commandCallsDraft6.go" "wsfs" "Draft6" "CmdRemoveXAttr"
 
 var (
 	errInvalidXAttrList = errors.New("invalid xattr list")
-	bufPool             = sync.Pool{
-		New: func() any {
-			return util.NewBuffer(MaxMsgSize)
-		},
-	}
+	bufPool             = util.NewBufferPool(wsfsprotocol.MaxMsgSize, wsfsprotocol.MaxNegotiableMsgSize)
 )
 
 func putBuf(buf *util.Buffer) {
-	buf.Reset()
 	bufPool.Put(buf)
 }
 
-func (s *session) dispatchCommand(r io.Reader) (err error) {
-	var header [2]byte
-	_, err = io.ReadFull(r, header[:])
+// maxReadPayload is the data of a read response message.
+func (s *session) maxReadPayload() uint64 {
+	return uint64(s.msgSize - rspHeaderSize)
+}
+
+func (s *session) dispatchCommand(c *sessionConn, r io.Reader) (err error) {
+	var header [3]byte // client mark(1 or 2) + command(1)
+	markSize := s.protocol.markSize
+	_, err = io.ReadFull(r, header[:markSize+1])
 	if err != nil {
 		return fmt.Errorf("bad command header: %w", err)
 	}
-	//log.Debug().Uint8("Cm", header[0]).Uint8("Op", header[1]).Msg("Recived commnad")
-	s.doCommandCall(header[0], header[1], r)
+	mark := uint16(header[0])
+	if markSize == 2 {
+		mark |= uint16(header[1]) << 8
+	}
+	clientMark := connMark(c.index, mark)
+	cmd := header[markSize]
+	//log.Debug().Uint16("Cm", mark).Uint8("Op", cmd).Msg("Recived commnad")
+	// the payload of a refused command is left unread
+	refused := s.readOnly && slices.Contains(wsfsprotocol.MutatingCommands, cmd)
+	if refused {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorAccessRestricted, "Read-only session")
+	} else {
+		s.protocol.commandCall(s, clientMark, cmd, r)
+	}
 	// coder/websocket requires the current message reader to be drained to EOF
 	// before Reader can be called for the next message.
 	n, err := io.Copy(io.Discard, r)
 	if err != nil {
 		return fmt.Errorf("bad command payload: %w", err)
 	}
-	if n > 0 {
+	if n > 0 && !refused {
 		log.Warn().
-			Uint8("Cm", header[0]).
-			Uint8("Op", header[1]).
+			Uint16("Cm", mark).
+			Int("Conn", c.index).
+			Uint8("Op", cmd).
 			Int64("TrailingBytes", n).
 			Msg("Command payload not fully consumed")
 	}
@@ -163,7 +172,7 @@ func (s *session) getAttr(apath string) (fs.FileInfo, wsfsprotocol.Timespec, err
 	return s.restrictingSymlinkByFileInfo(filepath.Dir(apath)+"/", fi)
 }
 
-func (s *session) cmdReadDir(clientMark uint8, req wsfsprotocol.CmdReadDirStruct) {
+func (s *session) cmdReadDir(clientMark uint32, req wsfsprotocol.CmdReadDirStruct) {
 	path := req.Path
 
 	if !util.IsUrlValid(path) {
@@ -184,9 +193,9 @@ func (s *session) cmdReadDir(clientMark uint8, req wsfsprotocol.CmdReadDirStruct
 		}
 	}()
 
-	rsp := bufPool.Get().(*util.Buffer)
+	rsp := s.getBuf()
 	defer putBuf(rsp)
-	rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+	rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 	for {
 		dirents, readdirerr := f.ReadDir(16)
 		if readdirerr != nil && !errors.Is(readdirerr, io.EOF) {
@@ -204,22 +213,22 @@ func (s *session) cmdReadDir(clientMark uint8, req wsfsprotocol.CmdReadDirStruct
 				}
 			}
 
-			if rsp.Written()+wsfsprotocol.GetDirentRequiredSize(wdirent) > wsfsprotocol.MaxMsgSize {
+			if rsp.Written()+wsfsprotocol.GetDirentRequiredSize(wdirent) > s.msgSize {
 				s.write(rsp.Done())
-				rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+				rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 			}
 			wsfsprotocol.WriteDirentToWriter(wdirent, rsp)
 		}
 
 		if len(dirents) == 0 || errors.Is(readdirerr, io.EOF) {
-			rsp.Bytes[1] = wsfsprotocol.ErrorOK
+			rsp.Bytes[rspCodeIndex] = wsfsprotocol.ErrorOK
 			s.write(rsp.Done())
 			break
 		}
 	}
 }
 
-func (s *session) cmdGetAttr(clientMark uint8, req wsfsprotocol.CmdGetAttrStruct) {
+func (s *session) cmdGetAttr(clientMark uint32, req wsfsprotocol.CmdGetAttrStruct) {
 	lpath := req.Path
 
 	if !util.IsUrlValid(lpath) {
@@ -232,17 +241,43 @@ func (s *session) cmdGetAttr(clientMark uint8, req wsfsprotocol.CmdGetAttrStruct
 	if err != nil {
 		goto BAD
 	}
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
 		err = wsfsprotocol.WriteRspGetAttrToWriter(wsfsprotocol.RspGetAttr{
 			FI: makeWSFSFileInfo(fi, mtime, s.convOwner(fi)),
-		}, s.writer)
-		s.writeDone(err)
+		}, c.writer)
+		c.writeDone(err)
 	}
 	return
 BAD:
 	s.writeRspError(clientMark, osErrCode(err), "syscall error")
 }
 
+// getAttrByFD gets the attributes of an open file, which may have been
+// renamed or removed since it was opened.
+func (s *session) getAttrByFD(fd uint32) (wsfsprotocol.FileInfo, uint8, string, bool) {
+	rsfd, ok := s.fds.Load(fd)
+	if !ok {
+		return wsfsprotocol.FileInfo{}, wsfsprotocol.ErrorInvalidFD, "bad fd", false
+	}
+	fi, err := statSFD(rsfd.(sfd_t))
+	if err != nil {
+		return wsfsprotocol.FileInfo{}, osErrCode(err), "syscall error", false
+	}
+	return makeWSFSFileInfo(fi, timeval.MTimeFromFileInfo(fi), s.convOwner(fi)), wsfsprotocol.ErrorOK, "", true
+}
+
+func (s *session) cmdGetAttrByFD(clientMark uint32, req wsfsprotocol.CmdGetAttrByFDStruct) {
+	fi, errCode, errDesc, ok := s.getAttrByFD(req.FD)
+	if !ok {
+		s.writeRspError(clientMark, errCode, errDesc)
+		return
+	}
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		err := wsfsprotocol.WriteRspGetAttrToWriter(wsfsprotocol.RspGetAttr{FI: fi}, c.writer)
+		c.writeDone(err)
+	}
+}
+
 func (s *session) lookupDirentSafe(dirBase string, entry fs.DirEntry) wsfsprotocol.Dirent {
 	wdirent, err := s.lookupDirent(dirBase, entry)
 	if err != nil {
@@ -255,21 +290,21 @@ func (s *session) lookupDirentSafe(dirBase string, entry fs.DirEntry) wsfsprotoc
 	return wdirent
 }
 
-func (s *session) writeDirentChunk(rsp *util.Buffer, clientMark uint8, wdirent wsfsprotocol.Dirent) {
+func (s *session) writeDirentChunk(rsp *util.Buffer, clientMark uint32, wdirent wsfsprotocol.Dirent) {
 	requiredSize := 1 + wsfsprotocol.GetDirentRequiredSize(wdirent)
-	if rsp.Written()+requiredSize > MaxMsgSize {
+	if rsp.Written()+requiredSize > s.msgSize {
 		s.write(rsp.Done())
-		rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+		rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 	}
 	rsp.Write([]byte{wsfsprotocol.READDIRPLUS_INDICATOR_CONTINUE})
 	wsfsprotocol.WriteDirentToWriter(wdirent, rsp)
 }
 
-func (s *session) writePrefetchIndicator(rsp *util.Buffer, clientMark uint8, indicator uint8) {
+func (s *session) writePrefetchIndicator(rsp *util.Buffer, clientMark uint32, indicator uint8) {
 	requiredSize := 1
-	if rsp.Written()+requiredSize > MaxMsgSize {
+	if rsp.Written()+requiredSize > s.msgSize {
 		s.write(rsp.Done())
-		rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+		rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 	}
 	rsp.Write([]byte{indicator})
 }
@@ -319,7 +354,7 @@ func (s *session) nextPrefetchDir(first []fs.DirEntry, dirBase string, start int
 	return nil, len(first), nil
 }
 
-func (s *session) streamPrefetchDir(rsp *util.Buffer, clientMark uint8, state *prefetchDirState) (failed bool) {
+func (s *session) streamPrefetchDir(rsp *util.Buffer, clientMark uint32, state *prefetchDirState) (failed bool) {
 	defer state.file.Close()
 
 	for {
@@ -348,7 +383,7 @@ const (
 	maxPrefetchDirs           = 32
 )
 
-func (s *session) cmdReadDirPlus(clientMark uint8, req wsfsprotocol.CmdReadDirPlusStruct) {
+func (s *session) cmdReadDirPlus(clientMark uint32, req wsfsprotocol.CmdReadDirPlusStruct) {
 	path := req.Path
 
 	if !util.IsUrlValid(path) {
@@ -374,8 +409,8 @@ func (s *session) cmdReadDirPlus(clientMark uint8, req wsfsprotocol.CmdReadDirPl
 	disablePrefetch := len(first) > maxRootEntriesForPrefetch
 
 	// 发送第一批 ROOT 条目
-	rsp := bufPool.Get().(*util.Buffer)
-	rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+	rsp := s.getBuf()
+	rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 	for _, entry := range first {
 		s.writeDirentChunk(rsp, clientMark, s.lookupDirentSafe(dirBase, entry))
 	}
@@ -398,7 +433,7 @@ func (s *session) cmdReadDirPlus(clientMark uint8, req wsfsprotocol.CmdReadDirPl
 
 	// 结束 ROOT 段
 	if disablePrefetch {
-		rsp.Bytes[1] = wsfsprotocol.ErrorOK
+		rsp.Bytes[rspCodeIndex] = wsfsprotocol.ErrorOK
 		s.write(rsp.Done())
 		putBuf(rsp)
 		return
@@ -431,8 +466,8 @@ func (s *session) cmdReadDirPlus(clientMark uint8, req wsfsprotocol.CmdReadDirPl
 			continue
 		}
 
-		rsp := bufPool.Get().(*util.Buffer)
-		rsp.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
+		rsp := s.getBuf()
+		rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
 		s.writePrefetchIndicator(rsp, clientMark, wsfsprotocol.READDIRPLUS_INDICATOR_PREFETCH)
 
 		for {
@@ -459,7 +494,7 @@ func (s *session) cmdReadDirPlus(clientMark uint8, req wsfsprotocol.CmdReadDirPl
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdWriteStreamOpen(clientMark uint8, req wsfsprotocol.CmdWriteStreamOpenStruct, dataBuf []byte) {
+func (s *session) cmdWriteStreamOpen(clientMark uint32, req wsfsprotocol.CmdWriteStreamOpenStruct, dataBuf []byte) {
 	rsfd, ok := s.fds.Load(req.FD)
 	if !ok {
 		if dataBuf != nil {
@@ -482,9 +517,8 @@ func (s *session) cmdWriteStreamOpen(clientMark uint8, req wsfsprotocol.CmdWrite
 		return
 	}
 
-	s.cmdGroup.Go(func() error {
+	s.goCommand(clientMark, func() {
 		stream.run(rsfd.(sfd_t), req.Offset)
-		return nil
 	})
 
 	stream.enqueue(writeStreamInput{
@@ -493,7 +527,7 @@ func (s *session) cmdWriteStreamOpen(clientMark uint8, req wsfsprotocol.CmdWrite
 	})
 }
 
-func (s *session) cmdWriteStreamData(clientMark uint8, req wsfsprotocol.CmdWriteStreamDataStruct, dataBuf []byte) {
+func (s *session) cmdWriteStreamData(clientMark uint32, req wsfsprotocol.CmdWriteStreamDataStruct, dataBuf []byte) {
 	stream, ok := s.loadWriteStream(clientMark)
 	if !ok {
 		if dataBuf != nil {
@@ -510,15 +544,16 @@ func (s *session) cmdWriteStreamData(clientMark uint8, req wsfsprotocol.CmdWrite
 	})
 }
 
-func (s *session) writeRspWriteStreamClose(clientMark uint8, written uint64) {
-	if !s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
+func (s *session) writeRspWriteStreamClose(clientMark uint32, written uint64) {
+	c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK)
+	if c == nil {
 		return
 	}
-	err := wsfsprotocol.WriteRspWriteStreamCloseToWriter(wsfsprotocol.RspWriteStreamClose{Written: written}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteRspWriteStreamCloseToWriter(wsfsprotocol.RspWriteStreamClose{Written: written}, c.writer)
+	c.writeDone(err)
 }
 
-func (s *session) cmdSetXAttr(clientMark uint8, req wsfsprotocol.CmdSetXAttrStruct) {
+func (s *session) cmdSetXAttr(clientMark uint32, req wsfsprotocol.CmdSetXAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -540,7 +575,7 @@ func (s *session) cmdSetXAttr(clientMark uint8, req wsfsprotocol.CmdSetXAttrStru
 	s.writeRspOK(clientMark)
 }
 
-func (s *session) cmdGetXAttr(clientMark uint8, req wsfsprotocol.CmdGetXAttrStruct) {
+func (s *session) cmdGetXAttr(clientMark uint32, req wsfsprotocol.CmdGetXAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -564,7 +599,7 @@ func (s *session) cmdGetXAttr(clientMark uint8, req wsfsprotocol.CmdGetXAttrStru
 	s.writeXAttrData(clientMark, data)
 }
 
-func (s *session) cmdListXAttr(clientMark uint8, req wsfsprotocol.CmdListXAttrStruct) {
+func (s *session) cmdListXAttr(clientMark uint32, req wsfsprotocol.CmdListXAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -585,7 +620,7 @@ func (s *session) cmdListXAttr(clientMark uint8, req wsfsprotocol.CmdListXAttrSt
 	s.writeXAttrData(clientMark, data)
 }
 
-func (s *session) cmdRemoveXAttr(clientMark uint8, req wsfsprotocol.CmdRemoveXAttrStruct) {
+func (s *session) cmdRemoveXAttr(clientMark uint32, req wsfsprotocol.CmdRemoveXAttrStruct) {
 	if !util.IsUrlValid(req.Path) {
 		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "bad path")
 		return
@@ -643,21 +678,22 @@ func (s *session) filterXAttrList(data []byte) ([]byte, error) {
 	return filtered, nil
 }
 
-func (s *session) writeXAttrData(clientMark uint8, data []byte) {
+func (s *session) writeXAttrData(clientMark uint32, data []byte) {
 	if len(data) == 0 {
 		s.writeRspOK(clientMark)
 		return
 	}
 
-	buf := bufPool.Get().(*util.Buffer)
+	buf := s.getBuf()
 	defer putBuf(buf)
-	for len(data) > maxXAttrResponsePayload {
-		buf.Write([]byte{clientMark, wsfsprotocol.ErrorPartialResponse})
-		buf.Write(data[:maxXAttrResponsePayload])
+	maxPayload := s.msgSize - rspHeaderSize
+	for len(data) > maxPayload {
+		buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorPartialResponse))
+		buf.Write(data[:maxPayload])
 		s.write(buf.Done())
-		data = data[maxXAttrResponsePayload:]
+		data = data[maxPayload:]
 	}
-	buf.Write([]byte{clientMark, wsfsprotocol.ErrorOK})
+	buf.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
 	buf.Write(data)
 	s.write(buf.Done())
 }
@@ -0,0 +1,188 @@
+package wsfs
+
+import (
+	"bytes"
+	"slices"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+)
+
+// compoundStepReserve is the room kept in the response for each step after
+// a read, whose results are at most a file info or a short error.
+const compoundStepReserve = 64
+
+type compoundStep struct {
+	cmd uint8
+	req any
+}
+
+// parseCompoundSteps reads all the steps before any is run, so that a bad
+// step is refused without the steps before it done.
+func parseCompoundSteps(data []byte) ([]compoundStep, string, bool) {
+	r := bytes.NewReader(data)
+	var steps []compoundStep
+	for r.Len() > 0 {
+		if len(steps) == wsfsprotocol.MaxCompoundSteps {
+			return nil, "too many compound steps", false
+		}
+		cmd, _ := r.ReadByte()
+		var req any
+		var err error
+		switch cmd {
+		case wsfsprotocol.CmdOpen:
+			var stepReq wsfsprotocol.CmdOpenStruct
+			err = wsfsprotocol.ReadCmdOpenStructFromReader(&stepReq, r)
+			req = stepReq
+		case wsfsprotocol.CmdClose:
+			var stepReq wsfsprotocol.CmdCloseStruct
+			err = wsfsprotocol.ReadCmdCloseStructFromReader(&stepReq, r)
+			req = stepReq
+		case wsfsprotocol.CmdGetAttr:
+			var stepReq wsfsprotocol.CmdGetAttrStruct
+			err = wsfsprotocol.ReadCmdGetAttrStructFromReader(&stepReq, r)
+			req = stepReq
+		case wsfsprotocol.CmdMkdir:
+			var stepReq wsfsprotocol.CmdMkdirStruct
+			err = wsfsprotocol.ReadCmdMkdirStructFromReader(&stepReq, r)
+			req = stepReq
+		case wsfsprotocol.CmdReadAt:
+			var stepReq wsfsprotocol.CmdReadAtStruct
+			err = wsfsprotocol.ReadCmdReadAtStructFromReader(&stepReq, r)
+			req = stepReq
+		case wsfsprotocol.CmdGetAttrByFD:
+			var stepReq wsfsprotocol.CmdGetAttrByFDStruct
+			err = wsfsprotocol.ReadCmdGetAttrByFDStructFromReader(&stepReq, r)
+			req = stepReq
+		default:
+			return nil, "bad compound step command", false
+		}
+		if err != nil {
+			return nil, "bad compound step format", false
+		}
+		steps = append(steps, compoundStep{cmd: cmd, req: req})
+	}
+	if len(steps) == 0 {
+		return nil, "empty compound command", false
+	}
+	return steps, "", true
+}
+
+func (s *session) cmdCompound(clientMark uint32, req wsfsprotocol.CmdCompoundStruct) {
+	steps, errDesc, ok := parseCompoundSteps(req.Steps)
+	if !ok {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, errDesc)
+		return
+	}
+
+	rsp := s.getBuf()
+	defer putBuf(rsp)
+	rsp.Write(rspHeader(clientMark, wsfsprotocol.ErrorOK))
+	opened := make([]uint32, len(steps)) // fd opened by each step
+	for i, step := range steps {
+		reserve := (len(steps) - i - 1) * compoundStepReserve
+		errCode, errDesc, ok := s.runCompoundStep(rsp, step, opened[:i], &opened[i], reserve)
+		if !ok {
+			wsfsprotocol.WriteCompoundResultHeader(errCode, wsfsprotocol.GetRspErrorRequiredSize(wsfsprotocol.RspError{Desc: errDesc}), rsp)
+			wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: errDesc}, rsp)
+			rsp.Bytes[rspCodeIndex] = errCode
+			break
+		}
+	}
+	s.write(rsp.Done())
+}
+
+// compoundFD returns the fd of the session a step refers to.
+func compoundFD(fd uint32, opened []uint32) (uint32, bool) {
+	step, ok := wsfsprotocol.CompoundFDStep(fd)
+	if !ok {
+		return fd, true
+	}
+	if step >= len(opened) || opened[step] == 0 {
+		return 0, false
+	}
+	return opened[step], true
+}
+
+// runCompoundStep writes the result of a successful step to rsp.
+func (s *session) runCompoundStep(rsp *util.Buffer, step compoundStep, opened []uint32, openedFD *uint32, reserve int) (uint8, string, bool) {
+	if s.readOnly && slices.Contains(wsfsprotocol.MutatingCommands, step.cmd) {
+		return wsfsprotocol.ErrorAccessRestricted, "Read-only session", false
+	}
+
+	switch req := step.req.(type) {
+	case wsfsprotocol.CmdOpenStruct:
+		if s.readOnly && wsfsprotocol.OpenFlagWrites(req.OFlag) {
+			return wsfsprotocol.ErrorAccessRestricted, "Read-only session", false
+		}
+		sfd, errCode, errDesc, ok := s.openFile(req.Path, req.OFlag, req.FMode)
+		if !ok {
+			return errCode, errDesc, false
+		}
+		*openedFD = s.newFD(sfd, openedFile{path: req.Path, oflag: req.OFlag})
+		result := wsfsprotocol.RspOpen{FD: *openedFD}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, wsfsprotocol.GetRspOpenRequiredSize(result), rsp)
+		wsfsprotocol.WriteRspOpenToWriter(result, rsp)
+
+	case wsfsprotocol.CmdCloseStruct:
+		fd, ok := compoundFD(req.FD, opened)
+		if !ok {
+			return wsfsprotocol.ErrorInvalidFD, "bad compound fd", false
+		}
+		if errCode, errDesc, ok := s.closeFD(fd); !ok {
+			return errCode, errDesc, false
+		}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, 0, rsp)
+
+	case wsfsprotocol.CmdGetAttrStruct:
+		if !util.IsUrlValid(req.Path) {
+			return wsfsprotocol.ErrorInvalid, "bad path", false
+		}
+		fi, mtime, err := s.getAttr(s.storage.Path + req.Path)
+		if err != nil {
+			return osErrCode(err), "syscall error", false
+		}
+		result := wsfsprotocol.RspGetAttr{FI: makeWSFSFileInfo(fi, mtime, s.convOwner(fi))}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, wsfsprotocol.GetRspGetAttrRequiredSize(result), rsp)
+		wsfsprotocol.WriteRspGetAttrToWriter(result, rsp)
+
+	case wsfsprotocol.CmdGetAttrByFDStruct:
+		fd, ok := compoundFD(req.FD, opened)
+		if !ok {
+			return wsfsprotocol.ErrorInvalidFD, "bad compound fd", false
+		}
+		fi, errCode, errDesc, ok := s.getAttrByFD(fd)
+		if !ok {
+			return errCode, errDesc, false
+		}
+		result := wsfsprotocol.RspGetAttr{FI: fi}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, wsfsprotocol.GetRspGetAttrRequiredSize(result), rsp)
+		wsfsprotocol.WriteRspGetAttrToWriter(result, rsp)
+
+	case wsfsprotocol.CmdMkdirStruct:
+		if errCode, errDesc, ok := s.mkdir(req.Path, req.Mode); !ok {
+			return errCode, errDesc, false
+		}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, 0, rsp)
+
+	case wsfsprotocol.CmdReadAtStruct:
+		fd, ok := compoundFD(req.FD, opened)
+		if !ok {
+			return wsfsprotocol.ErrorInvalidFD, "bad compound fd", false
+		}
+		rsfd, ok := s.fds.Load(fd)
+		if !ok {
+			return wsfsprotocol.ErrorInvalidFD, "bad fd", false
+		}
+		// the read is cut to the room left in the response
+		room := max(s.msgSize-rsp.Written()-wsfsprotocol.CompoundResultHeaderSize-reserve, 0)
+		size := int(min(req.Size, uint64(room)))
+		data := rsp.Bytes[rsp.Written()+wsfsprotocol.CompoundResultHeaderSize:][:size]
+		readed, errCode, errDesc, ok := readChunkAt(rsfd.(sfd_t), req.Offset, data)
+		if !ok {
+			return errCode, errDesc, false
+		}
+		wsfsprotocol.WriteCompoundResultHeader(wsfsprotocol.ErrorOK, readed, rsp)
+		rsp.Grow(readed)
+	}
+	return wsfsprotocol.ErrorOK, "", true
+}
@@ -0,0 +1,172 @@
+package wsfs
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	client "wsfs-core/internal/client/session"
+	"wsfs-core/internal/share/wsfsprotocol"
+
+	"github.com/coder/websocket"
+)
+
+func sendCompound(t *testing.T, conn *websocket.Conn, steps func(w *bytes.Buffer) error) (uint8, []wsfsprotocol.CompoundResult) {
+	t.Helper()
+	code, body := roundTrip(t, conn, 9, wsfsprotocol.CmdCompound, steps)
+	// a refused command has an error of its own instead of results
+	results, err := wsfsprotocol.ReadCompoundResults(body)
+	if err != nil && code == wsfsprotocol.ErrorOK {
+		t.Fatalf("decode results: %v", err)
+	}
+	return code, results
+}
+
+func TestCompound(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "small"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerAt(t, dir)
+	conn, _, err := dialTestServer(t, server, wsfsprotocol.WSSubprotocols)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	code, results := sendCompound(t, conn, func(w *bytes.Buffer) error {
+		fd := wsfsprotocol.CompoundFD(0)
+		w.WriteByte(wsfsprotocol.CmdOpen)
+		wsfsprotocol.WriteCmdOpenStructToWriter(wsfsprotocol.CmdOpenStruct{Path: "/small", OFlag: wsfsprotocol.O_RDONLY}, w)
+		w.WriteByte(wsfsprotocol.CmdReadAt)
+		wsfsprotocol.WriteCmdReadAtStructToWriter(wsfsprotocol.CmdReadAtStruct{FD: fd, Size: 100}, w)
+		w.WriteByte(wsfsprotocol.CmdClose)
+		return wsfsprotocol.WriteCmdCloseStructToWriter(wsfsprotocol.CmdCloseStruct{FD: fd}, w)
+	})
+	if code != wsfsprotocol.ErrorOK || len(results) != 3 {
+		t.Fatalf("open, read, close = %d with %d results", code, len(results))
+	}
+	if string(results[1].Body) != "hello" {
+		t.Fatalf("read %q", results[1].Body)
+	}
+
+	// stops at the existing directory
+	code, results = sendCompound(t, conn, func(w *bytes.Buffer) error {
+		for _, p := range []string{"/a", "/a", "/a/b"} {
+			w.WriteByte(wsfsprotocol.CmdMkdir)
+			wsfsprotocol.WriteCmdMkdirStructToWriter(wsfsprotocol.CmdMkdirStruct{Path: p, Mode: 0o755}, w)
+		}
+		return nil
+	})
+	if code != wsfsprotocol.ErrorExists || len(results) != 2 || results[1].Code != wsfsprotocol.ErrorExists {
+		t.Fatalf("mkdir chain = %d with %d results", code, len(results))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "b")); !os.IsNotExist(err) {
+		t.Fatalf("step after the failed one was run: %v", err)
+	}
+
+	code, _ = sendCompound(t, conn, func(w *bytes.Buffer) error {
+		w.WriteByte(wsfsprotocol.CmdWrite)
+		return wsfsprotocol.WriteCmdWriteStructToWriter(wsfsprotocol.CmdWriteStruct{FD: 1}, w)
+	})
+	if code != wsfsprotocol.ErrorInvalid {
+		t.Fatalf("write step = %d, want invalid", code)
+	}
+}
+
+func TestCompoundGetAttrByFD(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "small"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerAt(t, dir)
+	conn, _, err := dialTestServer(t, server, wsfsprotocol.WSSubprotocols)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	code, results := sendCompound(t, conn, func(w *bytes.Buffer) error {
+		w.WriteByte(wsfsprotocol.CmdOpen)
+		wsfsprotocol.WriteCmdOpenStructToWriter(wsfsprotocol.CmdOpenStruct{Path: "/small", OFlag: wsfsprotocol.O_RDONLY}, w)
+		w.WriteByte(wsfsprotocol.CmdGetAttrByFD)
+		wsfsprotocol.WriteCmdGetAttrByFDStructToWriter(wsfsprotocol.CmdGetAttrByFDStruct{FD: wsfsprotocol.CompoundFD(0)}, w)
+		// no step 2 opened a file
+		w.WriteByte(wsfsprotocol.CmdGetAttrByFD)
+		return wsfsprotocol.WriteCmdGetAttrByFDStructToWriter(wsfsprotocol.CmdGetAttrByFDStruct{FD: wsfsprotocol.CompoundFD(2)}, w)
+	})
+	if code != wsfsprotocol.ErrorInvalidFD || len(results) != 3 {
+		t.Fatalf("open, get attr = %d with %d results", code, len(results))
+	}
+	var rsp wsfsprotocol.RspGetAttr
+	if err := wsfsprotocol.ReadRspGetAttrFromReader(&rsp, bytes.NewReader(results[1].Body)); err != nil {
+		t.Fatalf("decode get attr: %v", err)
+	}
+	if rsp.FI.Size != 5 || !fs.FileMode(rsp.FI.Mode).IsRegular() {
+		t.Fatalf("get attr by fd = size %d, mode %v", rsp.FI.Size, fs.FileMode(rsp.FI.Mode))
+	}
+}
+
+func newTestClientSession(t *testing.T, dir string) *client.Session {
+	t.Helper()
+	conn, rsp, err := dialTestServer(t, newTestServerAt(t, dir), wsfsprotocol.WSSubprotocols)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	s, err := client.NewSession(nil, nil, 1, 0, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start(conn, wsfsprotocol.ReadCapabilities(rsp.Header))
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// TestCompoundClient runs the compound helpers of the client session.
+func TestCompoundClient(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "small"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestClientSession(t, dir)
+
+	if data, code := s.ReadSmallFile("/small", 100); code != wsfsprotocol.ErrorOK || string(data) != "hello" {
+		t.Fatalf("read small file = %q, %d", data, code)
+	}
+	if _, code := s.ReadSmallFile("/missing", 100); code != wsfsprotocol.ErrorNotExists {
+		t.Fatalf("read missing file = %d, want not exists", code)
+	}
+
+	fis, codes := s.CmdGetAttrs([]string{"/small", "/missing", "/"})
+	if codes[0] != wsfsprotocol.ErrorOK || codes[1] != wsfsprotocol.ErrorNotExists || codes[2] != wsfsprotocol.ErrorOK {
+		t.Fatalf("get attrs = %v", codes)
+	}
+	if fis[0].Size != 5 || !fs.FileMode(fis[2].Mode).IsDir() {
+		t.Fatalf("get attrs = size %d, root mode %v", fis[0].Size, fs.FileMode(fis[2].Mode))
+	}
+
+	// existing directories are skipped
+	for range 2 {
+		if code := s.CmdMkdirAll("/a/b/c", 0o755); code != wsfsprotocol.ErrorOK {
+			t.Fatalf("mkdir all = %d", code)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "a", "b", "c")); err != nil || !fi.IsDir() {
+		t.Fatalf("mkdir all made no directory: %v", err)
+	}
+	if code := s.CmdMkdirAll("/small/d", 0o755); code == wsfsprotocol.ErrorOK {
+		t.Fatal("mkdir all under a file succeeded")
+	}
+
+	fd, fi, code := s.CmdOpenGetAttr("/new", wsfsprotocol.O_RDWR|wsfsprotocol.O_CREAT, 0o644)
+	if code != wsfsprotocol.ErrorOK {
+		t.Fatalf("open get attr = %d", code)
+	}
+	if fi.Size != 0 || !fs.FileMode(fi.Mode).IsRegular() {
+		t.Fatalf("open get attr = size %d, mode %v", fi.Size, fs.FileMode(fi.Mode))
+	}
+	if code := s.CmdClose(fd); code != wsfsprotocol.ErrorOK {
+		t.Fatalf("close = %d", code)
+	}
+}
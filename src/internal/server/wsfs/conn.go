@@ -0,0 +1,109 @@
+package wsfs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coder/websocket"
+)
+
+// A command is known by its client mark with the index of the connection it
+// came on above it, so that its response goes back on that connection, and
+// a command still running for a closed connection is not mistaken for one
+// of another connection.
+const connIndexShift = 16
+
+func connMark(index int, mark uint16) uint32 {
+	return uint32(index)<<connIndexShift | uint32(mark)
+}
+
+func markConnIndex(clientMark uint32) int {
+	return int(clientMark >> connIndexShift)
+}
+
+// sessionConn is one of the connections a session runs on.
+type sessionConn struct {
+	index      int
+	remoteAddr string
+
+	// this should only be read by write caller
+	// read caller take another copy of conn to make sure independent
+	// so writeLock's holder can set conn to nil to stop incoming write
+	conn      *websocket.Conn
+	writer    io.WriteCloser
+	writeLock sync.Mutex
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	err       atomic.Pointer[error] // the first one
+
+	// commands and streams that came on the connection
+	cmds sync.WaitGroup
+}
+
+// setErr keeps the first error of the connection.
+func (c *sessionConn) setErr(err error) {
+	c.err.CompareAndSwap(nil, &err)
+}
+
+// loadErr returns the first error of the connection, nil if none.
+func (c *sessionConn) loadErr() error {
+	if err := c.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (c *sessionConn) running() bool {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn != nil
+}
+
+func (c *sessionConn) close(code websocket.StatusCode, reason string) {
+	c.writeLock.Lock()
+	conn := c.conn
+	c.writeLock.Unlock()
+	if conn != nil {
+		_ = conn.Close(code, reason)
+	}
+}
+
+func (c *sessionConn) requireWrite() (ok bool) {
+	var err error
+
+	c.writeLock.Lock()
+	if c.conn == nil {
+		c.writeLock.Unlock()
+		return false
+	}
+
+	c.writer, err = c.conn.Writer(c.ctx, websocket.MessageBinary)
+
+	if err != nil {
+		c.setErr(err)
+		c.conn = nil
+		c.ctxCancel()
+		c.writeLock.Unlock()
+		return false
+	}
+	return true
+}
+
+func (c *sessionConn) writeDone(err error) {
+	if c.writer != nil {
+		closeErr := c.writer.Close()
+		if err == nil {
+			err = closeErr
+		}
+		c.writer = nil
+	}
+	if err != nil {
+		c.setErr(err)
+		c.conn = nil
+		c.ctxCancel()
+	}
+	c.writeLock.Unlock()
+}
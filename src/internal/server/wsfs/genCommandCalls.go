@@ -18,8 +18,10 @@ import (
 const banner = "// Code generated by 'genCommandCalls.go'. DO NOT EDIT."
 
 var syncCommands = map[string]bool{
-	"CmdWriteStreamOpen": true,
-	"CmdWriteStreamData": true,
+	"CmdWriteStreamOpen":  true,
+	"CmdWriteStreamData":  true,
+	"CmdReadStreamOpen":   true,
+	"CmdReadStreamCredit": true,
 }
 
 var selfManagedBufferCommands = map[string]bool{
@@ -71,7 +73,9 @@ func parseBytesStructs(srcPath string) (map[string]bool, error) {
 	return bytesStructs, nil
 }
 
-func parseCommands(srcPath string) ([]command, error) {
+// parseCommands returns the commands up to lastCommand, or all of them if
+// it is empty.
+func parseCommands(srcPath string, lastCommand string) ([]command, error) {
 	fset := token.NewFileSet()
 	node, err := parser.ParseFile(fset, srcPath, nil, 0)
 	if err != nil {
@@ -102,14 +106,23 @@ func parseCommands(srcPath string) ([]command, error) {
 					MethodName: "cmd" + baseName,
 					Sync:       syncCommands[name.Name],
 				})
+				if name.Name == lastCommand {
+					return commands, nil
+				}
 			}
 		}
 	}
 
+	if lastCommand != "" {
+		return nil, fmt.Errorf("last command %s not found", lastCommand)
+	}
 	return commands, nil
 }
 
-func genCommandCalls(commands []command, packageName string) ([]byte, error) {
+// genCommandCalls generates the command table of a protocol version. The
+// table of the current version is doCommandCall; other versions get their
+// version appended, e.g. doCommandCallDraft6, and end at their last command.
+func genCommandCalls(commands []command, packageName string, version string) ([]byte, error) {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "%s\n", banner)
 	fmt.Fprintf(&buf, "package %s\n\n", packageName)
@@ -118,7 +131,7 @@ func genCommandCalls(commands []command, packageName string) ([]byte, error) {
 	fmt.Fprintf(&buf, "\"wsfs-core/internal/share/wsfsprotocol\"\n")
 	fmt.Fprintf(&buf, ")\n\n")
 
-	fmt.Fprintf(&buf, "func (s *session) doCommandCall(clientMark, cmd uint8, r io.Reader) {\n")
+	fmt.Fprintf(&buf, "func (s *session) doCommandCall%s(clientMark uint32, cmd uint8, r io.Reader) {\n", version)
 	fmt.Fprintf(&buf, "switch cmd {\n")
 	for _, cmd := range commands {
 		fmt.Fprintf(&buf, "case wsfsprotocol.%s:\n", cmd.ConstName)
@@ -130,6 +143,9 @@ func genCommandCalls(commands []command, packageName string) ([]byte, error) {
 			fmt.Fprintf(&buf, "s.releaseFastBuffer(dataBuf)\n")
 			fmt.Fprintf(&buf, "goto BadCmdFormat\n")
 			fmt.Fprintf(&buf, "}\n")
+			fmt.Fprintf(&buf, "if s.tracing() {\n")
+			fmt.Fprintf(&buf, "s.traceCommand(clientMark, req)\n")
+			fmt.Fprintf(&buf, "}\n")
 			if cmd.Sync {
 				if cmd.SelfManagedBuffer {
 					fmt.Fprintf(&buf, "s.%s(clientMark, req, dataBuf)\n", cmd.MethodName)
@@ -138,14 +154,13 @@ func genCommandCalls(commands []command, packageName string) ([]byte, error) {
 					fmt.Fprintf(&buf, "s.releaseFastBuffer(dataBuf)\n")
 				}
 			} else {
-				fmt.Fprintf(&buf, "s.cmdGroup.Go(func() error {\n")
+				fmt.Fprintf(&buf, "s.goCommand(clientMark, func() {\n")
 				if cmd.SelfManagedBuffer {
 					fmt.Fprintf(&buf, "s.%s(clientMark, req, dataBuf)\n", cmd.MethodName)
 				} else {
 					fmt.Fprintf(&buf, "defer s.releaseFastBuffer(dataBuf)\n")
 					fmt.Fprintf(&buf, "s.%s(clientMark, req)\n", cmd.MethodName)
 				}
-				fmt.Fprintf(&buf, "return nil\n")
 				fmt.Fprintf(&buf, "})\n")
 			}
 			fmt.Fprintf(&buf, "return\n")
@@ -154,12 +169,14 @@ func genCommandCalls(commands []command, packageName string) ([]byte, error) {
 			fmt.Fprintf(&buf, "if err != nil {\n")
 			fmt.Fprintf(&buf, "goto BadCmdFormat\n")
 			fmt.Fprintf(&buf, "}\n")
+			fmt.Fprintf(&buf, "if s.tracing() {\n")
+			fmt.Fprintf(&buf, "s.traceCommand(clientMark, req)\n")
+			fmt.Fprintf(&buf, "}\n")
 			if cmd.Sync {
 				fmt.Fprintf(&buf, "s.%s(clientMark, req)\n", cmd.MethodName)
 			} else {
-				fmt.Fprintf(&buf, "s.cmdGroup.Go(func() error {\n")
+				fmt.Fprintf(&buf, "s.goCommand(clientMark, func() {\n")
 				fmt.Fprintf(&buf, "s.%s(clientMark, req)\n", cmd.MethodName)
-				fmt.Fprintf(&buf, "return nil\n")
 				fmt.Fprintf(&buf, "})\n")
 			}
 			fmt.Fprintf(&buf, "return\n")
@@ -182,12 +199,20 @@ func main() {
 		os.Args = append(os.Args[:1], os.Args[2:]...)
 	}
 
-	if len(os.Args) != 4 {
+	if len(os.Args) < 4 || len(os.Args) > 6 {
 		fmt.Println("Bad usage")
+		fmt.Printf("Usage: %s CONST_FILE OUTPUT_FILE PACKAGE [VERSION [LAST_COMMAND]]\n", os.Args[0])
 		os.Exit(1)
 	}
+	version, lastCommand := "", ""
+	if len(os.Args) >= 5 {
+		version = os.Args[4]
+	}
+	if len(os.Args) == 6 {
+		lastCommand = os.Args[5]
+	}
 
-	commands, err := parseCommands(os.Args[1])
+	commands, err := parseCommands(os.Args[1], lastCommand)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -202,7 +227,7 @@ func main() {
 		commands[i].SelfManagedBuffer = selfManagedBufferCommands[commands[i].ConstName]
 	}
 
-	out, err := genCommandCalls(commands, os.Args[3])
+	out, err := genCommandCalls(commands, os.Args[3], version)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -1,13 +1,21 @@
 package wsfs
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 	internalerror "wsfs-core/internal/server/internalError"
 	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/share/wsfsprotocol"
 	"wsfs-core/internal/util"
+	"wsfs-core/version"
 
+	"github.com/coder/websocket"
 	"github.com/rs/zerolog/log"
 )
 
@@ -18,6 +26,10 @@ var (
 type FeatureOptions struct {
 	EnableLink         bool
 	AllowedXAttrPrefix []string
+
+	// permessage-deflate, used when the client offers it
+	Compression          websocket.CompressionMode
+	CompressionThreshold int // bytes; smaller messages are not compressed
 }
 
 type Handler struct {
@@ -57,10 +69,6 @@ func (h *Handler) TryServerHTTP(rsp http.ResponseWriter, req *http.Request, user
 		}
 		return false
 	}
-	if user.ReadOnly {
-		h.errorHandler.ServeErrorMessage(rsp, req, http.StatusForbidden, "Bad WSFS handshake: Access Denied")
-		return true
-	}
 	h.ServeHTTP(rsp, req, user)
 	return true
 }
@@ -81,11 +89,62 @@ func headerContainsToken(header http.Header, key, want string) bool {
 	return false
 }
 
+// capabilities are advertised to the client in the upgrade response.
+func (h *Handler) capabilities(user *storage.User, msgSize int, maxConns int) wsfsprotocol.Capabilities {
+	caps := wsfsprotocol.Capabilities{
+		ServerVersion:      version.Version,
+		MaxMsgSize:         msgSize,
+		AllowedXAttrPrefix: h.featureOpts.AllowedXAttrPrefix,
+		ReadOnly:           user.ReadOnly,
+		Extensions: []string{
+			wsfsprotocol.ExtensionSessionResume,
+			wsfsprotocol.ExtensionResumeKey,
+			wsfsprotocol.ExtensionGoingAway,
+		},
+		Subprotocols: wsfsprotocol.WSSubprotocols,
+		MaxConns:     maxConns,
+	}
+	if maxConns > 1 {
+		caps.Extensions = append(caps.Extensions, wsfsprotocol.ExtensionMultiConn)
+	}
+	for _, cmd := range wsfsprotocol.AllCommands() {
+		if cmd == wsfsprotocol.CmdLink && !h.featureOpts.EnableLink {
+			continue
+		}
+		if user.ReadOnly && slices.Contains(wsfsprotocol.MutatingCommands, cmd) {
+			continue
+		}
+		caps.Commands = append(caps.Commands, cmd)
+	}
+	return caps
+}
+
 func (h *Handler) ServeHTTP(rsp http.ResponseWriter, req *http.Request, user *storage.User) {
+	if retryAfter, refused := h.registry.refusing(); refused {
+		rsp.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		rsp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var resumeKeyHash []byte
+	if key := req.Header.Get("X-Wsfs-Resume-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		resumeKeyHash = sum[:]
+	} else if h.registry.resumeKeyRequired() {
+		h.errorHandler.ServeErrorMessage(rsp, req, http.StatusBadRequest, "Bad WSFS handshake: Resume key required")
+		return
+	}
+
+	if joinId := req.Header.Get(wsfsprotocol.HeaderJoin); joinId != "" {
+		h.serveJoin(rsp, req, user, joinId, resumeKeyHash)
+		return
+	}
+
 	id := req.Header.Get("X-Wsfs-Resume")
-	if id == "" {
+	resuming := id != ""
+	if !resuming {
 		var err error
-		id, err = h.registry.newSession(user.Name, user.Storage, h.fsIds, h.featureOpts)
+		id, err = h.registry.newSession(user, h.fsIds, h.featureOpts, resumeKeyHash)
 		if err != nil {
 			log.Error().Err(err).Msg("Generate session id failed")
 			rsp.WriteHeader(http.StatusInternalServerError)
@@ -94,6 +153,15 @@ func (h *Handler) ServeHTTP(rsp http.ResponseWriter, req *http.Request, user *st
 	}
 
 	session := h.registry.getSession(id)
+	if session == nil {
+		h.registry.lock.Lock()
+		onUnknownSession := h.registry.onUnknownSession
+		h.registry.lock.Unlock()
+		if onUnknownSession != nil {
+			onUnknownSession()
+			session = h.registry.getSession(id)
+		}
+	}
 	if session == nil {
 		rsp.WriteHeader(http.StatusBadRequest)
 		return
@@ -103,6 +171,21 @@ func (h *Handler) ServeHTTP(rsp http.ResponseWriter, req *http.Request, user *st
 		rsp.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	// rules may have changed since the session was created, and a resumed
+	// session may come from another network
+	if !user.Access.Permits(req.RemoteAddr) {
+		log.Info().Str("From", req.RemoteAddr).Str("Id", id).Msg("Session denied from address")
+		rsp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// checked before the lock, so that whether the session is connected is
+	// not told without the key
+	if session.resumeKeyHash != nil && subtle.ConstantTimeCompare(session.resumeKeyHash, resumeKeyHash) != 1 {
+		// lie as session not found
+		log.Info().Str("From", req.RemoteAddr).Str("Id", id).Msg("Session denied for bad resume key")
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if !session.Lock.TryLock() {
 		rsp.WriteHeader(http.StatusPreconditionFailed)
 		return
@@ -116,13 +199,96 @@ func (h *Handler) ServeHTTP(rsp http.ResponseWriter, req *http.Request, user *st
 		}
 	}()
 
-	conn, err := h.upgrade(rsp, req, id)
+	// the user may have changed on reload
+	session.lifetime = user.SessionLifetime
+	session.readOnly = user.ReadOnly
+	session.msgSize = wsfsprotocol.NegotiateMsgSize(req.Header.Get(wsfsprotocol.HeaderMaxMsgSize), h.registry.maxMsgSizeLimit())
+	if err := session.reopen(user.Storage, h.fsIds, h.featureOpts); err != nil {
+		log.Warn().Err(err).Str("Id", id).Msg("Restored session refused")
+		h.registry.delSession(id)
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the old id stays valid until the new one is delivered, so a client
+	// failing the upgrade can still resume
+	newId := id
+	if resuming {
+		var err error
+		if newId, err = h.registry.reserveId(); err != nil {
+			log.Error().Err(err).Msg("Generate session id failed")
+			rsp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	maxConns := h.registry.maxConnsLimit()
+	conn, err := h.upgrade(rsp, req, newId, h.capabilities(user, session.msgSize, maxConns), wsfsprotocol.WSSubprotocols)
 	if err != nil {
+		if resuming {
+			h.registry.releaseId(newId)
+		}
 		log.Error().Err(err).Msg("Upgrade websocket connection failed")
 		return
 	}
+	if resuming {
+		h.registry.rotateId(session, newId)
+		id = newId
+	}
 	succeeded = true
 
-	log.Info().Str("From", req.RemoteAddr).Str("User", user.Name).Str("Id", id).Msg("Session running")
-	session.takeConn(conn, req.RemoteAddr)
+	log.Info().Str("From", req.RemoteAddr).Str("User", user.Name).Str("Id", id).Str("Subprotocol", conn.Subprotocol()).Int("MaxMsgSize", session.msgSize).Bool("Compressed", compressed(rsp.Header())).Msg("Session running")
+	session.applyTrace(id)
+	session.takeConn(conn, req.RemoteAddr, maxConns)
+}
+
+// serveJoin adds a connection to a running session. Only a session bound to
+// a resume key can be joined, with the same key.
+func (h *Handler) serveJoin(rsp http.ResponseWriter, req *http.Request, user *storage.User, id string, resumeKeyHash []byte) {
+	index, err := strconv.Atoi(req.Header.Get(wsfsprotocol.HeaderConnIndex))
+	if err != nil {
+		h.errorHandler.ServeErrorMessage(rsp, req, http.StatusBadRequest, "Bad WSFS handshake: Bad connection index")
+		return
+	}
+
+	session := h.registry.getSession(id)
+	if session == nil && h.registry.reserved(id) {
+		// the id of a resume, delivered before the session is moved to it
+		rsp.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if session == nil || session.Username != user.Name {
+		// lie as session not found
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !user.Access.Permits(req.RemoteAddr) {
+		log.Info().Str("From", req.RemoteAddr).Str("Id", id).Msg("Session denied from address")
+		rsp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// checked before the state of the session, which a caller without the
+	// key must not learn; the key is set when the session is created
+	if resumeKeyHash == nil || subtle.ConstantTimeCompare(session.resumeKeyHash, resumeKeyHash) != 1 {
+		// lie as session not found
+		log.Info().Str("From", req.RemoteAddr).Str("Id", id).Msg("Session join denied for bad resume key")
+		rsp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the session is running, so its message size is set
+	if status := session.joinable(index); status != 0 {
+		rsp.WriteHeader(status)
+		return
+	}
+
+	conn, err := h.upgrade(rsp, req, "", h.capabilities(user, session.msgSize, h.registry.maxConnsLimit()), []string{session.subprotocol})
+	if err != nil {
+		log.Error().Err(err).Msg("Upgrade websocket connection failed")
+		return
+	}
+	if !session.joinConn(index, conn, req.RemoteAddr) {
+		_ = conn.Close(websocket.StatusTryAgainLater, "session not joinable")
+		return
+	}
+	log.Info().Str("From", req.RemoteAddr).Str("User", user.Name).Str("Id", id).Int("Conn", index).Bool("Compressed", compressed(rsp.Header())).Msg("Session connection joined")
 }
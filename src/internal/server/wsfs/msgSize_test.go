@@ -0,0 +1,48 @@
+package wsfs
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wsfs-core/internal/share/wsfsprotocol"
+
+	"github.com/coder/websocket"
+)
+
+func TestMsgSizeNegotiation(t *testing.T) {
+	server := newTestServer(t)
+	for _, tc := range []struct {
+		requested string
+		want      int
+	}{
+		{"", wsfsprotocol.MaxMsgSize}, // old client
+		{"bad", wsfsprotocol.MaxMsgSize},
+		{"1024", wsfsprotocol.MaxMsgSize},
+		{"65536", 65536},
+		{strconv.Itoa(1 << 30), wsfsprotocol.MaxNegotiableMsgSize},
+	} {
+		header := http.Header{}
+		if tc.requested != "" {
+			header.Set(wsfsprotocol.HeaderMaxMsgSize, tc.requested)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, rsp, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{
+			Subprotocols: wsfsprotocol.WSSubprotocols,
+			HTTPHeader:   header,
+		})
+		cancel()
+		if err != nil {
+			t.Fatalf("%q: dial: %v", tc.requested, err)
+		}
+		if got := wsfsprotocol.ReadCapabilities(rsp.Header).MaxMsgSize; got != tc.want {
+			t.Errorf("%q: negotiated %d, want %d", tc.requested, got, tc.want)
+		}
+		if code := getAttrRoot(t, conn); code != wsfsprotocol.ErrorOK {
+			t.Fatalf("%q: get attr = %d, want OK", tc.requested, code)
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
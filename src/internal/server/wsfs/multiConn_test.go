@@ -0,0 +1,120 @@
+package wsfs
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wsfs-core/internal/share/wsfsprotocol"
+
+	"github.com/coder/websocket"
+)
+
+func dialJoin(t *testing.T, url string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return websocket.Dial(ctx, url, &websocket.DialOptions{
+		Subprotocols: wsfsprotocol.WSSubprotocols,
+		HTTPHeader:   header,
+	})
+}
+
+func TestJoinConn(t *testing.T) {
+	server := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	header := http.Header{}
+	header.Set("X-Wsfs-Resume-Key", "secret")
+	first, rsp, err := dialJoin(t, url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer first.Close(websocket.StatusNormalClosure, "")
+	caps := wsfsprotocol.ReadCapabilities(rsp.Header)
+	if caps.MaxConns < 2 {
+		t.Fatalf("max conns = %d, want at least 2", caps.MaxConns)
+	}
+
+	join := func(key string, index int) (*websocket.Conn, int) {
+		h := http.Header{}
+		h.Set("X-Wsfs-Resume-Key", key)
+		h.Set(wsfsprotocol.HeaderJoin, rsp.Header.Get("X-Wsfs-Resume"))
+		h.Set(wsfsprotocol.HeaderConnIndex, strconv.Itoa(index))
+		conn, joinRsp, err := dialJoin(t, url, h)
+		if err != nil {
+			if joinRsp == nil {
+				t.Fatalf("join %d: %v", index, err)
+			}
+			return nil, joinRsp.StatusCode
+		}
+		return conn, joinRsp.StatusCode
+	}
+
+	// the state of the session is not told without the key
+	for _, index := range []int{0, 1} {
+		if _, status := join("other", index); status != http.StatusBadRequest {
+			t.Fatalf("join %d with bad key: status %d, want %d", index, status, http.StatusBadRequest)
+		}
+	}
+	if _, status := join("secret", caps.MaxConns); status != http.StatusBadRequest {
+		t.Fatalf("join out of range: status %d, want %d", status, http.StatusBadRequest)
+	}
+	if _, status := join("secret", 0); status != http.StatusPreconditionFailed {
+		t.Fatalf("join of a taken index: status %d, want %d", status, http.StatusPreconditionFailed)
+	}
+
+	second, status := join("secret", 1)
+	if second == nil {
+		t.Fatalf("join: status %d", status)
+	}
+	if second.Subprotocol() != first.Subprotocol() {
+		t.Fatalf("joined with %q, session speaks %q", second.Subprotocol(), first.Subprotocol())
+	}
+	// the same client mark is used on both connections
+	for _, conn := range []*websocket.Conn{first, second, first} {
+		if code := getAttrRoot(t, conn); code != wsfsprotocol.ErrorOK {
+			t.Fatalf("get attr = %d, want OK", code)
+		}
+	}
+
+	// a joined connection closing leaves the session running
+	second.Close(websocket.StatusNormalClosure, "")
+	if code := getAttrRoot(t, first); code != wsfsprotocol.ErrorOK {
+		t.Fatalf("get attr after a connection closed = %d, want OK", code)
+	}
+}
+
+// TestResumeConnectedSession resumes a session which is still connected; the
+// key is checked before the session is found busy.
+func TestResumeConnectedSession(t *testing.T) {
+	server := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	header := http.Header{}
+	header.Set("X-Wsfs-Resume-Key", "secret")
+	conn, rsp, err := dialJoin(t, url, header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	for key, want := range map[string]int{
+		"other":  http.StatusBadRequest,
+		"secret": http.StatusPreconditionFailed,
+	} {
+		h := http.Header{}
+		h.Set("X-Wsfs-Resume-Key", key)
+		h.Set("X-Wsfs-Resume", rsp.Header.Get("X-Wsfs-Resume"))
+		resumed, resumeRsp, err := dialJoin(t, url, h)
+		if err == nil {
+			resumed.CloseNow()
+			t.Fatalf("resume with key %q succeeded", key)
+		}
+		if resumeRsp == nil || resumeRsp.StatusCode != want {
+			t.Fatalf("resume with key %q: %v, want status %d", key, err, want)
+		}
+	}
+}
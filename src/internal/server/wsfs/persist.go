@@ -0,0 +1,152 @@
+package wsfs
+
+import (
+	"errors"
+	"time"
+	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrRestoredStorageChanged = errors.New("wsfs: storage of restored session changed")
+
+// openedFile is how a fd was opened, so that it can be opened again after a
+// restart.
+type openedFile struct {
+	path  string
+	oflag uint32 // protocol open flags
+}
+
+// SavedSession is a hibernated session written to the state file.
+type SavedSession struct {
+	Id          string
+	Username    string
+	StoragePath string
+	FdLast      uint32
+	Files       []SavedFile
+
+	Lifetime      time.Duration
+	HibernatedAt  time.Time
+	ResumeKeyHash []byte
+}
+
+type SavedFile struct {
+	FD     uint32
+	Path   string // relative to the storage, as opened
+	OFlag  uint32
+	Offset int64
+}
+
+// Save returns the hibernated sessions with their open files. Sessions
+// still connected are skipped.
+func (r *SessionRegistry) Save() []SavedSession {
+	var saved []SavedSession
+	r.sessions.Range(func(_, value any) bool {
+		s := value.(*session)
+		if s == nil {
+			return true
+		}
+		if !s.Lock.TryLock() {
+			log.Warn().Str("Id", s.Id()).Msg("Session still connected, not saved")
+			return true
+		}
+		defer s.Lock.Unlock()
+
+		if s.restored != nil {
+			// never resumed since the last restart
+			saved = append(saved, *s.restored)
+			return true
+		}
+
+		ss := SavedSession{
+			Id:          s.Id(),
+			Username:    s.Username,
+			StoragePath: s.storage.Path,
+			FdLast:      s.fdLast.Load(),
+
+			Lifetime:      s.lifetime,
+			HibernatedAt:  s.hibernatedAt,
+			ResumeKeyHash: s.resumeKeyHash,
+		}
+		s.fds.Range(func(key, value any) bool {
+			fd := key.(uint32)
+			opened, ok := s.openedFiles.Load(fd)
+			if !ok {
+				return true
+			}
+			offset, err := sfdOffset(value.(sfd_t))
+			if err != nil {
+				log.Warn().Err(err).Str("Id", s.Id()).Msg("Unable to get file offset, not saved")
+				return true
+			}
+			ss.Files = append(ss.Files, SavedFile{
+				FD:     fd,
+				Path:   opened.(openedFile).path,
+				OFlag:  opened.(openedFile).oflag,
+				Offset: offset,
+			})
+			return true
+		})
+		saved = append(saved, ss)
+		return true
+	})
+	return saved
+}
+
+// Restore adds sessions read from the state file. Their files are opened
+// again when they are resumed, by a user allowed to.
+func (r *SessionRegistry) Restore(saved []SavedSession) {
+	for _, ss := range saved {
+		s := newSession(r, ss.Id, ss.Username, nil, util.FsIds{}, FeatureOptions{})
+		s.fdLast.Store(ss.FdLast)
+		s.lifetime = ss.Lifetime
+		s.hibernatedAt = ss.HibernatedAt
+		s.resumeKeyHash = ss.ResumeKeyHash
+		s.restored = &ss
+		if _, loaded := r.sessions.LoadOrStore(ss.Id, s); loaded {
+			continue
+		}
+		log.Info().Str("Id", ss.Id).Int("Files", len(ss.Files)).Msg("Session restored")
+	}
+}
+
+// reopen opens the files of a restored session for the user resuming it.
+// Files opened for writing are not reopened for a read-only user. It must be
+// called with Lock held, after readOnly is set.
+func (s *session) reopen(st *storage.Storage, fsIds util.FsIds, featureOpts FeatureOptions) error {
+	if s.restored == nil {
+		return nil
+	}
+	if st.Path != s.restored.StoragePath {
+		return ErrRestoredStorageChanged
+	}
+	s.storage = st
+	s.fsIds = fsIds
+	s.featureOpts = featureOpts
+
+	for _, f := range s.restored.Files {
+		// the file was created or truncated when it was first opened
+		oflag := f.OFlag &^ (wsfsprotocol.O_CREAT | wsfsprotocol.O_EXCL | wsfsprotocol.O_TRUNC)
+		if s.readOnly && wsfsprotocol.OpenFlagWrites(oflag) {
+			// the user may have become read-only since the session was saved
+			log.Warn().Str("Id", s.Id()).Str("Path", f.Path).Msg("File opened for writing is not reopened for a read-only user")
+			continue
+		}
+		sfd, _, _, ok := s.openFile(f.Path, oflag, 0)
+		if !ok {
+			log.Warn().Str("Id", s.Id()).Str("Path", f.Path).Msg("Unable to reopen file")
+			continue
+		}
+		if f.Offset != 0 && f.OFlag&wsfsprotocol.O_APPEND == 0 {
+			if err := seekSFD(sfd, f.Offset); err != nil {
+				log.Warn().Err(err).Str("Id", s.Id()).Str("Path", f.Path).Msg("Unable to restore file offset")
+			}
+		}
+		s.fds.Store(f.FD, sfd)
+		s.openedFiles.Store(f.FD, openedFile{path: f.Path, oflag: f.OFlag})
+	}
+	s.restored = nil
+	return nil
+}
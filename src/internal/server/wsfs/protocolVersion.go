@@ -0,0 +1,44 @@
+package wsfs
+
+import (
+	"fmt"
+	"io"
+	"wsfs-core/internal/share/wsfsprotocol"
+)
+
+// protocolVersion is how a session speaks one of wsfsprotocol.WSSubprotocols.
+// Each version has its own command table, generated by genCommandCalls.go
+// from the commands of that version; other commands are refused as unknown.
+type protocolVersion struct {
+	commandCall func(s *session, clientMark uint32, cmd uint8, r io.Reader)
+	markSize    int // bytes of a client mark
+	// commands run at once, scaled to the requests a client may have in
+	// flight
+	cmdLimit int
+}
+
+// by subprotocol
+var protocolVersions = map[string]*protocolVersion{
+	wsfsprotocol.WSSubprotocol: {
+		commandCall: (*session).doCommandCall,
+		markSize:    2,
+		cmdLimit:    512,
+	},
+	wsfsprotocol.WSSubprotocolDraft6: {
+		commandCall: (*session).doCommandCallDraft6,
+		markSize:    1,
+		cmdLimit:    64,
+	},
+}
+
+func init() {
+	for _, subprotocol := range wsfsprotocol.WSSubprotocols {
+		version, ok := protocolVersions[subprotocol]
+		if !ok {
+			panic(fmt.Sprintf("wsfs: no command table for subprotocol %q", subprotocol))
+		}
+		if version.markSize != wsfsprotocol.ClientMarkSize(subprotocol) {
+			panic(fmt.Sprintf("wsfs: bad client mark size of subprotocol %q", subprotocol))
+		}
+	}
+}
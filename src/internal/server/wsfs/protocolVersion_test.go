@@ -0,0 +1,216 @@
+package wsfs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wsfs-core/internal/server/config"
+	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/share/wsfsprotocol"
+	"wsfs-core/internal/util"
+
+	"github.com/coder/websocket"
+)
+
+type testErrorHandler struct{}
+
+func (testErrorHandler) ServeError(rsp http.ResponseWriter, _ *http.Request, err error) {
+	http.Error(rsp, err.Error(), http.StatusInternalServerError)
+}
+
+func (testErrorHandler) ServeErrorMessage(rsp http.ResponseWriter, _ *http.Request, code int, msg string) {
+	http.Error(rsp, msg, code)
+}
+
+func (testErrorHandler) ServeErrorPage(rsp http.ResponseWriter, _ *http.Request, code int, msg string) {
+	http.Error(rsp, msg, code)
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestServerAt(t, t.TempDir())
+}
+
+func newTestServerAt(t *testing.T, storagePath string) *httptest.Server {
+	t.Helper()
+	registry := NewSessionRegistry(config.Default.WSFS)
+	t.Cleanup(registry.Stop)
+	handler := NewHandler(testErrorHandler{}, util.FsIds{}, FeatureOptions{}, registry)
+	user := &storage.User{Name: "test", Storage: &storage.Storage{Path: storagePath}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(rsp, req, user)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTestServer(t *testing.T, server *httptest.Server, subprotocols []string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{
+		Subprotocols: subprotocols,
+	})
+}
+
+// commandHeader returns the header of a command with the client marks of
+// the subprotocol of conn.
+func commandHeader(conn *websocket.Conn, clientMark uint16, cmd uint8) []byte {
+	if wsfsprotocol.ClientMarkSize(conn.Subprotocol()) == 1 {
+		return []byte{uint8(clientMark), cmd}
+	}
+	return []byte{uint8(clientMark), uint8(clientMark >> 8), cmd}
+}
+
+// roundTrip sends a command and returns the error code and body of its
+// response, checking its client mark.
+func roundTrip(t *testing.T, conn *websocket.Conn, clientMark uint16, cmd uint8, encode func(w *bytes.Buffer) error) (uint8, []byte) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var msg bytes.Buffer
+	msg.Write(commandHeader(conn, clientMark, cmd))
+	if err := encode(&msg); err != nil {
+		t.Fatalf("encode command: %v", err)
+	}
+	if err := conn.Write(ctx, websocket.MessageBinary, msg.Bytes()); err != nil {
+		t.Fatalf("write command: %v", err)
+	}
+	_, rsp, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	header := commandHeader(conn, clientMark, 0)
+	markSize := len(header) - 1
+	if len(rsp) < len(header) || !bytes.Equal(rsp[:markSize], header[:markSize]) {
+		t.Fatalf("bad response header % x", rsp[:min(len(rsp), len(header))])
+	}
+	return rsp[markSize], rsp[len(header):]
+}
+
+// getAttrRoot sends a get attr of the storage root, as every client version
+// does first, and returns the error code of the response.
+func getAttrRoot(t *testing.T, conn *websocket.Conn) uint8 {
+	t.Helper()
+	code, _ := roundTrip(t, conn, 7, wsfsprotocol.CmdGetAttr, func(w *bytes.Buffer) error {
+		return wsfsprotocol.WriteCmdGetAttrStructToWriter(wsfsprotocol.CmdGetAttrStruct{Path: "/"}, w)
+	})
+	return code
+}
+
+func TestEverySubprotocolIsServed(t *testing.T) {
+	server := newTestServer(t)
+	for _, subprotocol := range wsfsprotocol.WSSubprotocols {
+		conn, _, err := dialTestServer(t, server, []string{subprotocol})
+		if err != nil {
+			t.Fatalf("%s: dial: %v", subprotocol, err)
+		}
+		if conn.Subprotocol() != subprotocol {
+			t.Fatalf("%s: negotiated %q", subprotocol, conn.Subprotocol())
+		}
+		if code := getAttrRoot(t, conn); code != wsfsprotocol.ErrorOK {
+			t.Fatalf("%s: get attr = %d, want OK", subprotocol, code)
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
+
+// TestOldClientDraft6 is a client released with only WSFS/draft.6.
+func TestOldClientDraft6(t *testing.T) {
+	server := newTestServer(t)
+	conn, rsp, err := dialTestServer(t, server, []string{"WSFS/draft.6"})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	if conn.Subprotocol() != "WSFS/draft.6" {
+		t.Fatalf("negotiated %q", conn.Subprotocol())
+	}
+	if rsp.Header.Get("X-Wsfs-Resume") == "" {
+		t.Fatal("no session id for resume")
+	}
+	if code := getAttrRoot(t, conn); code != wsfsprotocol.ErrorOK {
+		t.Fatalf("get attr = %d, want OK", code)
+	}
+}
+
+func TestDraft6RefusesLaterCommands(t *testing.T) {
+	server := newTestServer(t)
+	conn, rsp, err := dialTestServer(t, server, []string{wsfsprotocol.WSSubprotocolDraft6})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	if wsfsprotocol.ReadCapabilities(rsp.Header).HasCommand(wsfsprotocol.CmdCompound) {
+		t.Fatal("compound advertised on WSFS/draft.6")
+	}
+	code, _ := roundTrip(t, conn, 3, wsfsprotocol.CmdCompound, func(w *bytes.Buffer) error {
+		w.WriteByte(wsfsprotocol.CmdGetAttr)
+		return wsfsprotocol.WriteCmdGetAttrStructToWriter(wsfsprotocol.CmdGetAttrStruct{Path: "/"}, w)
+	})
+	if code != wsfsprotocol.ErrorInvalid {
+		t.Fatalf("compound = %d, want %d", code, wsfsprotocol.ErrorInvalid)
+	}
+	if code := getAttrRoot(t, conn); code != wsfsprotocol.ErrorOK {
+		t.Fatalf("get attr = %d, want OK", code)
+	}
+}
+
+func TestWideClientMarks(t *testing.T) {
+	server := newTestServer(t)
+	conn, _, err := dialTestServer(t, server, wsfsprotocol.WSSubprotocols)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	if wsfsprotocol.ClientMarkSize(conn.Subprotocol()) != 2 {
+		t.Fatalf("negotiated %q with 1 byte client marks", conn.Subprotocol())
+	}
+	code, _ := roundTrip(t, conn, 0x1234, wsfsprotocol.CmdGetAttr, func(w *bytes.Buffer) error {
+		return wsfsprotocol.WriteCmdGetAttrStructToWriter(wsfsprotocol.CmdGetAttrStruct{Path: "/"}, w)
+	})
+	if code != wsfsprotocol.ErrorOK {
+		t.Fatalf("get attr = %d, want OK", code)
+	}
+}
+
+func TestNewerClientGetsPreferredSubprotocol(t *testing.T) {
+	server := newTestServer(t)
+	conn, rsp, err := dialTestServer(t, server, append([]string{"WSFS/draft.999"}, wsfsprotocol.WSSubprotocols...))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	if conn.Subprotocol() != wsfsprotocol.WSSubprotocols[0] {
+		t.Fatalf("negotiated %q, want %q", conn.Subprotocol(), wsfsprotocol.WSSubprotocols[0])
+	}
+	caps := wsfsprotocol.ReadCapabilities(rsp.Header)
+	if !caps.Advertised || len(caps.Subprotocols) != len(wsfsprotocol.WSSubprotocols) {
+		t.Fatalf("subprotocols advertised = %v", caps.Subprotocols)
+	}
+}
+
+func TestUnknownSubprotocolRefused(t *testing.T) {
+	server := newTestServer(t)
+	conn, _, err := dialTestServer(t, server, []string{"WSFS/draft.999"})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := conn.Read(ctx); err == nil {
+		t.Fatal("connection with unknown subprotocol kept open")
+	}
+}
@@ -0,0 +1,180 @@
+package wsfs
+
+import (
+	"sync"
+	"wsfs-core/internal/share/wsfsprotocol"
+)
+
+// maxReadStreams is the number of read streams a session may have open.
+// Each stream waits for credit outside of cmdGroup, so that idle streams do
+// not hold the command slots, but is counted by the connection it came on.
+const maxReadStreams = 16
+
+// readStream pushes the data of a file to the client, as long as the client
+// gives credit for it. The stream ends at EOF, on error, or when the client
+// cancels it; the stream is removed before its final response is sent, so
+// that the client mark can be reused as soon as the client gets it.
+type readStream struct {
+	session    *session
+	clientMark uint32
+	fd         uint32
+	done       chan struct{} // closed once run returned
+
+	lock      sync.Mutex
+	credit    uint64 // bytes the stream may send
+	cancelled bool
+	wake      chan struct{}
+}
+
+func (s *session) cmdReadStreamOpen(clientMark uint32, req wsfsprotocol.CmdReadStreamOpenStruct) {
+	rsfd, ok := s.fds.Load(req.FD)
+	if !ok {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
+		return
+	}
+	if req.Window == 0 {
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "empty read stream window")
+		return
+	}
+	if s.readStreamCount.Add(1) > maxReadStreams {
+		s.readStreamCount.Add(-1)
+		s.writeRspError(clientMark, wsfsprotocol.ErrorBusy, "too many read streams")
+		return
+	}
+
+	stream := &readStream{
+		session:    s,
+		clientMark: clientMark,
+		fd:         req.FD,
+		credit:     uint64(req.Window),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	if _, loaded := s.readStreams.LoadOrStore(clientMark, stream); loaded {
+		s.readStreamCount.Add(-1)
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalid, "read stream already open")
+		return
+	}
+	// closeFD removes the fd before stopping its streams, so either this
+	// stream is stopped by it, or the fd is seen gone here
+	if now, ok := s.fds.Load(req.FD); !ok || now != rsfd {
+		stream.end()
+		close(stream.done)
+		s.writeRspError(clientMark, wsfsprotocol.ErrorInvalidFD, "bad fd")
+		return
+	}
+
+	// the command itself is counted, so the connection is still there
+	s.conns[markConnIndex(clientMark)].Load().cmds.Go(func() {
+		defer close(stream.done)
+		stream.run(rsfd.(sfd_t), req.Offset)
+	})
+}
+
+// cmdReadStreamCredit has no response. Credit for a stream already ended is
+// ignored, since the client may send it before getting the final response.
+func (s *session) cmdReadStreamCredit(clientMark uint32, req wsfsprotocol.CmdReadStreamCreditStruct) {
+	v, ok := s.readStreams.Load(clientMark)
+	if !ok {
+		return
+	}
+	v.(*readStream).addCredit(uint64(req.Credit), req.Cancel != 0)
+}
+
+func (rs *readStream) addCredit(credit uint64, cancel bool) {
+	rs.lock.Lock()
+	rs.credit += credit
+	if cancel {
+		rs.cancelled = true
+	}
+	rs.lock.Unlock()
+
+	select {
+	case rs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take waits for credit, and returns the bytes to send next.
+func (rs *readStream) take(maxSize uint64) (size uint64, cancelled bool) {
+	for {
+		rs.lock.Lock()
+		if rs.cancelled {
+			rs.lock.Unlock()
+			return 0, true
+		}
+		if rs.credit > 0 {
+			size = min(rs.credit, maxSize)
+			rs.credit -= size
+			rs.lock.Unlock()
+			return size, false
+		}
+		rs.lock.Unlock()
+		<-rs.wake
+	}
+}
+
+func (rs *readStream) end() {
+	rs.session.readStreams.Delete(rs.clientMark)
+	rs.session.readStreamCount.Add(-1)
+}
+
+func (rs *readStream) run(fd sfd_t, offset uint64) {
+	s := rs.session
+	for {
+		size, cancelled := rs.take(s.maxReadPayload())
+		if cancelled {
+			rs.end()
+			s.writeRspOK(rs.clientMark)
+			return
+		}
+
+		buf := s.getBuf()
+		buf.Write(rspHeader(rs.clientMark, wsfsprotocol.ErrorPartialResponse))
+		readed, errCode, errDesc, ok := readChunkAt(fd, offset, buf.Bytes[buf.Written():][:size])
+		if !ok {
+			putBuf(buf)
+			rs.end()
+			s.writeRspError(rs.clientMark, errCode, errDesc)
+			return
+		}
+		if readed == 0 {
+			putBuf(buf)
+			rs.end()
+			s.writeRspOK(rs.clientMark)
+			return
+		}
+		buf.Grow(readed)
+		s.write(buf.Done())
+		putBuf(buf)
+		offset += uint64(readed)
+	}
+}
+
+// stopReadStreams cancels the streams of a fd being closed, and waits for
+// them to stop, so that they do not read a file opened later under the
+// same fd number.
+func (s *session) stopReadStreams(fd uint32) {
+	var stopping []*readStream
+	s.readStreams.Range(func(_, value any) bool {
+		if rs := value.(*readStream); rs.fd == fd {
+			rs.addCredit(0, true)
+			stopping = append(stopping, rs)
+		}
+		return true
+	})
+	for _, rs := range stopping {
+		<-rs.done
+	}
+}
+
+// clearReadStreams cancels the streams of a connection going down.
+func (s *session) clearReadStreams(index int) {
+	s.readStreams.Range(func(key, value any) bool {
+		if markConnIndex(key.(uint32)) != index {
+			return true
+		}
+		value.(*readStream).addCredit(0, true)
+		return true
+	})
+}
@@ -0,0 +1,75 @@
+package wsfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wsfs-core/internal/share/wsfsprotocol"
+
+	"github.com/coder/websocket"
+)
+
+// TestCloseStopsReadStream closes a fd with a stream waiting for credit; the
+// stream must end before the close is answered, so that it can not read a
+// file opened later under the same fd number.
+func TestCloseStopsReadStream(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerAt(t, dir)
+	conn, _, err := dialTestServer(t, server, wsfsprotocol.WSSubprotocols)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	code, body := roundTrip(t, conn, 1, wsfsprotocol.CmdOpen, func(w *bytes.Buffer) error {
+		return wsfsprotocol.WriteCmdOpenStructToWriter(wsfsprotocol.CmdOpenStruct{Path: "/file", OFlag: wsfsprotocol.O_RDONLY}, w)
+	})
+	if code != wsfsprotocol.ErrorOK {
+		t.Fatalf("open = %d", code)
+	}
+	var opened wsfsprotocol.RspOpen
+	if err := wsfsprotocol.ReadRspOpenFromReader(&opened, bytes.NewReader(body)); err != nil {
+		t.Fatalf("decode open: %v", err)
+	}
+
+	// the window is used up by the first response
+	code, _ = roundTrip(t, conn, 2, wsfsprotocol.CmdReadStreamOpen, func(w *bytes.Buffer) error {
+		return wsfsprotocol.WriteCmdReadStreamOpenStructToWriter(wsfsprotocol.CmdReadStreamOpenStruct{FD: opened.FD, Window: 4}, w)
+	})
+	if code != wsfsprotocol.ErrorPartialResponse {
+		t.Fatalf("read stream = %d, want partial", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var msg bytes.Buffer
+	msg.Write(commandHeader(conn, 3, wsfsprotocol.CmdClose))
+	if err := wsfsprotocol.WriteCmdCloseStructToWriter(wsfsprotocol.CmdCloseStruct{FD: opened.FD}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Write(ctx, websocket.MessageBinary, msg.Bytes()); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+
+	markSize := wsfsprotocol.ClientMarkSize(conn.Subprotocol())
+	var marks []uint8
+	for len(marks) < 2 {
+		_, rsp, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		if rsp[markSize] != wsfsprotocol.ErrorOK {
+			t.Fatalf("response of mark %d = %d, want OK", rsp[0], rsp[markSize])
+		}
+		marks = append(marks, rsp[0])
+	}
+	if marks[0] != 2 || marks[1] != 3 {
+		t.Fatalf("responses of marks %v, want the stream ended before the close", marks)
+	}
+}
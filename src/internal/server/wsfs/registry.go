@@ -3,32 +3,44 @@ package wsfs
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 	"wsfs-core/internal/server/config"
 	"wsfs-core/internal/server/storage"
+	"wsfs-core/internal/share/wsfsprotocol"
 	"wsfs-core/internal/util"
 
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	sessionInactiveScanPeriod = 3 * time.Minute
-	sessionInactiveMaxCount   = 5
+	sessionCollectPeriod   = 30 * time.Second
+	defaultSessionLifetime = 15 * time.Minute
 )
 
 type SessionRegistry struct {
-	lock     sync.Mutex
-	idSource sessionIdSource
-	sessions sync.Map
-	ctx      context.Context
+	lock             sync.Mutex
+	idSource         sessionIdSource
+	lifetime         time.Duration // of sessions whose user sets none
+	requireResumeKey bool
+	maxMsgSize       int // negotiated with clients at most
+	maxConns         int // of a session
+	sessions         sync.Map
+	ctx              context.Context
 
 	stop context.CancelFunc
+
+	// set once the server is shutting down
+	goingAway  atomic.Bool
+	retryAfter time.Duration
+
+	onUnknownSession func()
+	tracer           atomic.Pointer[Tracer]
 }
 
 func NewSessionRegistry(c config.WSFS) *SessionRegistry {
-	r := &SessionRegistry{
-		idSource: setupSessionIdSource(c),
-	}
+	r := &SessionRegistry{}
+	r.Reconfigure(c)
 	r.ctx, r.stop = context.WithCancel(context.Background())
 	return r
 }
@@ -36,16 +48,87 @@ func NewSessionRegistry(c config.WSFS) *SessionRegistry {
 func (r *SessionRegistry) Reconfigure(c config.WSFS) {
 	r.lock.Lock()
 	r.idSource = setupSessionIdSource(c)
+	r.lifetime = time.Duration(c.SessionLifetime) * time.Second
+	if r.lifetime <= 0 {
+		r.lifetime = defaultSessionLifetime
+	}
+	r.requireResumeKey = c.RequireResumeKey
+	r.maxMsgSize = wsfsprotocol.ClampMsgSize(c.MaxMsgSize)
+	r.maxConns = min(max(c.MaxSessionConns, 1), wsfsprotocol.MaxSessionConns)
+	r.lock.Unlock()
+}
+
+// OnUnknownSession sets a function called before a resume of an unknown
+// session is refused, which may Restore it.
+func (r *SessionRegistry) OnUnknownSession(f func()) {
+	r.lock.Lock()
+	r.onUnknownSession = f
 	r.lock.Unlock()
 }
 
+// SetTracer sets the tracer whose traces apply to the sessions.
+func (r *SessionRegistry) SetTracer(t *Tracer) {
+	r.tracer.Store(t)
+}
+
+// ApplyTrace starts the traces of the tracer on running sessions, and
+// returns how many sessions are traced.
+func (r *SessionRegistry) ApplyTrace() int {
+	traced := 0
+	r.sessions.Range(func(key, value any) bool {
+		if s := value.(*session); s != nil && s.applyTrace(key.(string)) {
+			traced++
+		}
+		return true
+	})
+	return traced
+}
+
 func (r *SessionRegistry) Stop() {
 	r.stop()
 }
 
+// GoAway refuses new connections and closes the connected sessions, telling
+// clients to resume them after retryAfter. It returns once all connections
+// are closed, or closed forcibly when ctx is done.
+func (r *SessionRegistry) GoAway(ctx context.Context, retryAfter time.Duration) {
+	r.lock.Lock()
+	r.retryAfter = retryAfter
+	r.lock.Unlock()
+	r.goingAway.Store(true)
+
+	reason := wsfsprotocol.GoingAwayReason(retryAfter)
+	var wg sync.WaitGroup
+	r.sessions.Range(func(_, value any) bool {
+		if s := value.(*session); s != nil {
+			wg.Go(func() { s.goAway(ctx, reason) })
+		}
+		return true
+	})
+	wg.Wait()
+}
+
+// refusing reports whether new connections are refused, and after how long
+// the client should try again.
+func (r *SessionRegistry) refusing() (retryAfter time.Duration, refused bool) {
+	if !r.goingAway.Load() {
+		return 0, false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.retryAfter, true
+}
+
+// CollectInactiveSessions destroys sessions disconnected for longer than
+// their lifetime.
 func (r *SessionRegistry) CollectInactiveSessions() {
 	for {
-		time.Sleep(sessionInactiveScanPeriod)
+		time.Sleep(sessionCollectPeriod)
+		r.lock.Lock()
+		defaultLifetime := r.lifetime
+		r.lock.Unlock()
+
+		now := time.Now()
 		existsSession := false
 		r.sessions.Range(func(key, value any) bool {
 			existsSession = true
@@ -56,8 +139,11 @@ func (r *SessionRegistry) CollectInactiveSessions() {
 			}
 
 			if s.Lock.TryLock() {
-				s.inactiveCount += 1
-				if s.inactiveCount >= sessionInactiveMaxCount {
+				lifetime := s.lifetime
+				if lifetime == 0 {
+					lifetime = defaultLifetime
+				}
+				if now.Sub(s.hibernatedAt) >= lifetime {
 					r.delSession(key.(string))
 					return true
 				}
@@ -71,6 +157,43 @@ func (r *SessionRegistry) CollectInactiveSessions() {
 	}
 }
 
+type SessionInfo struct {
+	Id         string
+	Username   string
+	RemoteAddr string // of the last connection
+	Connected  bool
+	OpenFiles  int
+}
+
+func (r *SessionRegistry) Sessions() []SessionInfo {
+	var infos []SessionInfo
+	r.sessions.Range(func(key, value any) bool {
+		s := value.(*session)
+		if s == nil {
+			return true
+		}
+
+		// the id of the session may be changed by a resume meanwhile
+		info := SessionInfo{Id: key.(string), Username: s.Username}
+		if s.Lock.TryLock() {
+			s.Lock.Unlock()
+		} else {
+			info.Connected = true
+		}
+		s.remoteAddrLock.Lock()
+		info.RemoteAddr = s.remoteAddr
+		s.remoteAddrLock.Unlock()
+		s.fds.Range(func(_, _ any) bool {
+			info.OpenFiles++
+			return true
+		})
+
+		infos = append(infos, info)
+		return true
+	})
+	return infos
+}
+
 func (r *SessionRegistry) getSession(id string) *session {
 	v, ok := r.sessions.Load(id)
 	if !ok {
@@ -79,7 +202,15 @@ func (r *SessionRegistry) getSession(id string) *session {
 	return v.(*session)
 }
 
-func (r *SessionRegistry) newSession(username string, storage *storage.Storage, fsIds util.FsIds, featureOpts FeatureOptions) (string, error) {
+// reserved reports whether id is taken by a session not yet stored, such
+// as the new id of a session being resumed.
+func (r *SessionRegistry) reserved(id string) bool {
+	v, ok := r.sessions.Load(id)
+	return ok && v.(*session) == nil
+}
+
+// reserveId takes a new id, mapped to nil until the session is stored.
+func (r *SessionRegistry) reserveId() (string, error) {
 	for {
 		r.lock.Lock()
 		idSource := r.idSource
@@ -89,15 +220,61 @@ func (r *SessionRegistry) newSession(username string, storage *storage.Storage,
 		if err != nil {
 			return "", err
 		}
-		if _, loaded := r.sessions.LoadOrStore(id, (*session)(nil)); loaded {
-			continue
+		if _, loaded := r.sessions.LoadOrStore(id, (*session)(nil)); !loaded {
+			return id, nil
 		}
-		r.sessions.Store(id, newSession(r, id, username, storage, fsIds, featureOpts))
-		log.Info().Str("Id", id).Msg("Session created")
-		return id, nil
 	}
 }
 
+func (r *SessionRegistry) newSession(user *storage.User, fsIds util.FsIds, featureOpts FeatureOptions, resumeKeyHash []byte) (string, error) {
+	id, err := r.reserveId()
+	if err != nil {
+		return "", err
+	}
+	s := newSession(r, id, user.Name, user.Storage, fsIds, featureOpts)
+	s.lifetime = user.SessionLifetime
+	s.resumeKeyHash = resumeKeyHash
+	r.sessions.Store(id, s)
+	log.Info().Str("Id", id).Msg("Session created")
+	return id, nil
+}
+
+// rotateId moves a session being resumed to id, reserved by reserveId, once
+// the new id is delivered to the client, so that the old one can not be used
+// again. It must be called with the Lock of the session held.
+func (r *SessionRegistry) rotateId(s *session, id string) {
+	oldId := s.Id()
+	r.sessions.Store(id, s)
+	r.sessions.Delete(oldId)
+	log.Info().Str("Id", id).Str("OldId", oldId).Msg("Session id rotated")
+	s.idLock.Lock()
+	s.id = id
+	s.idLock.Unlock()
+}
+
+// releaseId frees an id reserved by reserveId but not used.
+func (r *SessionRegistry) releaseId(id string) {
+	r.sessions.CompareAndDelete(id, (*session)(nil))
+}
+
+func (r *SessionRegistry) resumeKeyRequired() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.requireResumeKey
+}
+
+func (r *SessionRegistry) maxMsgSizeLimit() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.maxMsgSize
+}
+
+func (r *SessionRegistry) maxConnsLimit() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.maxConns
+}
+
 func (r *SessionRegistry) delSession(id string) {
 	if session := r.getSession(id); session != nil {
 		session.clearFDs()
@@ -3,9 +3,10 @@ package wsfs
 import (
 	"context"
 	"errors"
-	"io"
+	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 	"wsfs-core/internal/server/storage"
 	"wsfs-core/internal/share/wsfsprotocol"
 	"wsfs-core/internal/util"
@@ -26,7 +27,8 @@ type session struct {
 	// Lock is an external indicator of whether a session is running.
 	Lock sync.Mutex
 
-	Id       string
+	id       string     // changes on resume
+	idLock   sync.Mutex // for readers not holding Lock
 	Username string
 	registry *SessionRegistry
 	storage  *storage.Storage
@@ -34,56 +36,100 @@ type session struct {
 
 	featureOpts FeatureOptions
 
-	inactiveCount uint32
+	// written with Lock held
+	lifetime      time.Duration // 0 means the default of the registry
+	hibernatedAt  time.Time
+	resumeKeyHash []byte // nil if the session is not bound to a resume key
+	readOnly      bool   // mutating commands are refused
+	msgSize       int    // negotiated by the connection
 
-	// this should only be read by write caller
-	// read caller take another copy of conn to make sure independent
-	// so writeLock's holder can set conn to nil to stop incoming write
-	conn      *websocket.Conn
-	writer    io.WriteCloser
-	writeLock sync.Mutex
+	protocol    *protocolVersion // negotiated by the first connection
+	subprotocol string           // of protocol, spoken by joined connections
 
-	remoteAddr string
+	// connections by index; a connection keeps its index until the commands
+	// that came on it are done, so that a connection joining at the same
+	// index does not get their responses
+	conns     [wsfsprotocol.MaxSessionConns]atomic.Pointer[sessionConn]
+	connsLock sync.Mutex     // for joining
+	liveConns int            // connections still reading, under connsLock
+	maxConns  int            // under connsLock
+	connGroup sync.WaitGroup // connections not yet stopped
 
-	connCtx       context.Context
-	connCtxCancel context.CancelFunc
-	connErr       error
-	connErrLock   sync.Mutex
+	remoteAddr     string     // of the last connection
+	remoteAddrLock sync.Mutex // for readers not holding Lock
+
+	goingAway atomic.Bool // closed by the server, hibernate rather than destroy
 
 	fds          sync.Map
 	fdLast       atomic.Uint32
+	openedFiles  sync.Map // fd to openedFile, for the state file
 	writeStreams sync.Map
 
+	readStreams     sync.Map // client mark to *readStream
+	readStreamCount atomic.Int32
+
+	// files of a session restored from the state file, opened on resume
+	restored *SavedSession
+
+	traceUntil atomic.Int64 // unix nano; 0 if not traced
+	traceCalls sync.Map     // client mark to *traceCall
+
 	cmdGroup    errgroup.Group
 	fastBuffers chan []byte
 }
 
 func newSession(registry *SessionRegistry, id string, username string, storage *storage.Storage, fsIds util.FsIds, featureOpts FeatureOptions) *session {
 	s := &session{
-		Id:          id,
+		id:          id,
 		Username:    username,
 		registry:    registry,
 		storage:     storage,
 		fsIds:       fsIds,
 		featureOpts: featureOpts,
 		fastBuffers: make(chan []byte, sessionFastBuffer),
+		// in case the first connection fails
+		hibernatedAt: time.Now(),
 	}
-	s.cmdGroup.SetLimit(64)
 	for range cap(s.fastBuffers) {
-		s.fastBuffers <- make([]byte, wsfsprotocol.MaxCommandLength)
+		s.fastBuffers <- nil
 	}
 	return s
 }
 
+func (s *session) Id() string {
+	s.idLock.Lock()
+	defer s.idLock.Unlock()
+	return s.id
+}
+
+// acquireFastBuffer returns a buffer of a command, allocated on first use at
+// the message size of the connection.
 func (s *session) acquireFastBuffer() []byte {
-	return <-s.fastBuffers
+	buf := <-s.fastBuffers
+	if cap(buf) < s.msgSize {
+		buf = make([]byte, s.msgSize)
+	}
+	return buf[:s.msgSize]
 }
 
 func (s *session) releaseFastBuffer(buf []byte) {
 	s.fastBuffers <- buf[:cap(buf)]
 }
 
-func (s *session) newFD(sfd sfd_t) uint32 {
+// dropFastBuffers frees the buffers of a session without connection, as
+// they may be large.
+func (s *session) dropFastBuffers() {
+	for range len(s.fastBuffers) {
+		<-s.fastBuffers
+		s.fastBuffers <- nil
+	}
+}
+
+func (s *session) getBuf() *util.Buffer {
+	return bufPool.Get(s.msgSize)
+}
+
+func (s *session) newFD(sfd sfd_t, opened openedFile) uint32 {
 	var fd uint32
 	for {
 		fd = s.fdLast.Add(1)
@@ -91,51 +137,183 @@ func (s *session) newFD(sfd sfd_t) uint32 {
 			break
 		}
 	}
+	s.openedFiles.Store(fd, opened)
 	return fd
 }
 
-func (s *session) takeConn(conn *websocket.Conn, remoteAddr string) {
-	conn.SetReadLimit(int64(wsfsprotocol.MaxCommandLength))
-	s.conn = conn
+// takeConn runs the session on its first connection, with Lock held.
+func (s *session) takeConn(conn *websocket.Conn, remoteAddr string, maxConns int) {
+	s.subprotocol = conn.Subprotocol()
+	s.protocol = protocolVersions[s.subprotocol]
+	// no command is running before the session is resumed
+	s.cmdGroup.SetLimit(s.protocol.cmdLimit)
+	s.goingAway.Store(false)
+	s.connsLock.Lock()
+	s.maxConns = maxConns
+	s.addConn(0, conn, remoteAddr)
+	s.connsLock.Unlock()
+}
+
+// joinable returns the HTTP status refusing a connection joining at index,
+// or 0 if it may join.
+func (s *session) joinable(index int) int {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	return s.joinableLocked(index)
+}
+
+func (s *session) joinableLocked(index int) int {
+	if s.liveConns == 0 {
+		if !s.Lock.TryLock() {
+			// connecting or resuming, joins sent right after it have to wait
+			return http.StatusPreconditionFailed
+		}
+		s.Lock.Unlock()
+		// lie as session not found
+		return http.StatusBadRequest
+	}
+	if index < 0 || index >= s.maxConns {
+		// lie as session not found
+		return http.StatusBadRequest
+	}
+	if s.conns[index].Load() != nil {
+		// still running, or its commands are not done yet
+		return http.StatusPreconditionFailed
+	}
+	return 0
+}
+
+// joinConn adds a connection to the running session.
+func (s *session) joinConn(index int, conn *websocket.Conn, remoteAddr string) bool {
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+	if s.joinableLocked(index) != 0 {
+		return false
+	}
+	s.addConn(index, conn, remoteAddr)
+	return true
+}
+
+// addConn must be called with connsLock held.
+func (s *session) addConn(index int, conn *websocket.Conn, remoteAddr string) {
+	conn.SetReadLimit(int64(s.msgSize))
+	c := &sessionConn{index: index, conn: conn, remoteAddr: remoteAddr}
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	s.conns[index].Store(c)
+	s.liveConns++
+	s.connGroup.Add(1)
+	s.remoteAddrLock.Lock()
 	s.remoteAddr = remoteAddr
-	s.connCtx, s.connCtxCancel = context.WithCancel(context.Background())
-	go s.readLoop(conn)
+	s.remoteAddrLock.Unlock()
+	go s.readLoop(c, conn)
+}
+
+// runningConns returns the connections not closed yet.
+func (s *session) runningConns() []*sessionConn {
+	var conns []*sessionConn
+	for i := range s.conns {
+		if c := s.conns[i].Load(); c != nil && c.running() {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// goAway closes the connections with the going away status and reason,
+// then waits for the session to hibernate. When ctx is done first, the
+// connections are closed without waiting for the client.
+func (s *session) goAway(ctx context.Context, reason string) {
+	conns := s.runningConns()
+	if len(conns) == 0 {
+		return
+	}
+	s.goingAway.Store(true)
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, c := range conns {
+			wg.Go(func() { c.close(websocket.StatusGoingAway, reason) })
+		}
+		wg.Wait()
+		s.Lock.Lock()
+		s.Lock.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// a canceled read closes the connection, even during the handshake
+		for _, c := range conns {
+			c.ctxCancel()
+		}
+	}
 }
 
-func (s *session) stopConn() {
-	s.connCtxCancel()
+// stopConn stops a connection whose read loop ended. The session stops
+// with its last connection, and is closed or hibernated as that connection
+// was.
+func (s *session) stopConn(c *sessionConn) {
+	s.connsLock.Lock()
+	s.liveConns--
+	last := s.liveConns == 0
+	s.connsLock.Unlock()
 
-	s.writeLock.Lock()
-	conn := s.conn
-	s.conn = nil
-	s.writeLock.Unlock()
+	c.ctxCancel()
 
-	connErr := s.connErr
+	c.writeLock.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.writeLock.Unlock()
+
+	connErr := c.loadErr()
 	closeStatus := websocket.CloseStatus(connErr)
-	gracefulClose := closeStatus == websocket.StatusNormalClosure || closeStatus == websocket.StatusGoingAway
 	if conn != nil && closeStatus == -1 {
 		_ = conn.CloseNow()
 	}
-	s.clearWriteStreams()
+	s.clearWriteStreams(c.index)
+	s.clearReadStreams(c.index)
+	c.cmds.Wait()
+	s.conns[c.index].Store(nil)
+	s.connGroup.Done()
+
+	if !last {
+		log.Info().Str("From", c.remoteAddr).Str("Id", s.Id()).Int("Conn", c.index).Int("CloseStatus", int(closeStatus)).Msg("Session connection closed")
+		return
+	}
+
+	s.connGroup.Wait()
 	_ = s.cmdGroup.Wait()
-	s.connErrLock.Unlock()
+	s.traceCalls.Clear()
+	s.dropFastBuffers()
 
+	gracefulClose := closeStatus == websocket.StatusNormalClosure || closeStatus == websocket.StatusGoingAway
+	goingAway := s.goingAway.Load()
+	if goingAway {
+		gracefulClose = false
+	}
 	if gracefulClose {
-		log.Info().Str("From", s.remoteAddr).Str("Id", s.Id).Msg("Session closed")
-		s.registry.delSession(s.Id)
+		log.Info().Str("From", c.remoteAddr).Str("Id", s.Id()).Msg("Session closed")
+		s.registry.delSession(s.Id())
 		s.Lock.Unlock()
 		return
 	}
 
-	log.Error().Str("From", s.remoteAddr).Str("Id", s.Id).Err(connErr).Msg("Failed to read/write message")
-	log.Info().Str("From", s.remoteAddr).Str("Id", s.Id).Msg("Session hibernated")
+	if !goingAway {
+		log.Error().Str("From", c.remoteAddr).Str("Id", s.Id()).Err(connErr).Msg("Failed to read/write message")
+	}
+	log.Info().Str("From", c.remoteAddr).Str("Id", s.Id()).Msg("Session hibernated")
 
-	s.inactiveCount = 0
+	s.hibernatedAt = time.Now()
 	s.Lock.Unlock()
 }
 
-func (s *session) clearWriteStreams() {
+// clearWriteStreams closes the streams of a connection going down.
+func (s *session) clearWriteStreams(index int) {
 	s.writeStreams.Range(func(key, value any) bool {
+		if markConnIndex(key.(uint32)) != index {
+			return true
+		}
 		value.(*writeStream).closeInput()
 		s.writeStreams.Delete(key)
 		return true
@@ -145,6 +323,7 @@ func (s *session) clearWriteStreams() {
 func (s *session) clearFDs() {
 	s.fds.Range(func(key, value any) bool {
 		s.fds.Delete(key)
+		s.openedFiles.Delete(key)
 		closeSFD(value.(sfd_t))
 		return true
 	})
@@ -158,7 +337,7 @@ type writeStreamInput struct {
 
 type writeStream struct {
 	session    *session
-	clientMark uint8
+	clientMark uint32
 	input      chan writeStreamInput
 	closeOnce  sync.Once
 }
@@ -204,7 +383,7 @@ func (ws *writeStream) run(fd sfd_t, offset uint64) {
 	}
 }
 
-func (s *session) loadWriteStream(clientMark uint8) (*writeStream, bool) {
+func (s *session) loadWriteStream(clientMark uint32) (*writeStream, bool) {
 	v, ok := s.writeStreams.Load(clientMark)
 	if !ok {
 		return nil, false
@@ -212,117 +391,133 @@ func (s *session) loadWriteStream(clientMark uint8) (*writeStream, bool) {
 	return v.(*writeStream), true
 }
 
-func (s *session) readLoop(conn *websocket.Conn) {
+func (s *session) readLoop(c *sessionConn, conn *websocket.Conn) {
 	defer func() {
-		var err error
-		if err = util.RecoverValue(recover()); err != nil {
+		if err := util.RecoverValue(recover()); err != nil {
 			log.Error().Err(err).Msg("Read loop panic")
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 		}
-		s.stopConn()
-
-		_ = s.cmdGroup.Wait()
+		s.stopConn(c)
 	}()
 
 	for {
-		msgType, reader, err := conn.Reader(s.connCtx)
+		msgType, reader, err := conn.Reader(c.ctx)
 
 		if err != nil {
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 			return
 		}
 		if msgType != websocket.MessageBinary {
-			log.Warn().Str("From", s.remoteAddr).Msg("Message type is not binary")
+			log.Warn().Str("From", c.remoteAddr).Msg("Message type is not binary")
 		}
 
-		err = s.dispatchCommand(reader)
+		err = s.dispatchCommand(c, reader)
 		if err != nil {
-			if s.connErrLock.TryLock() {
-				s.connErr = err
-			}
+			c.setErr(err)
 			return
 		}
 	}
 }
 
-func (s *session) requireWrite() (ok bool) {
-	var err error
+// goCommand runs a command in cmdGroup, counted by the connection it came
+// on.
+func (s *session) goCommand(clientMark uint32, f func()) {
+	c := s.conns[markConnIndex(clientMark)].Load()
+	c.cmds.Add(1)
+	s.cmdGroup.Go(func() error {
+		defer c.cmds.Done()
+		f()
+		return nil
+	})
+}
 
-	s.writeLock.Lock()
-	if s.conn == nil {
-		s.writeLock.Unlock()
-		return false
-	}
+// Responses are built with a header of rspHeaderSize, holding the index of
+// the connection to send them on; the header is cut to the client mark size
+// of the connection when written.
+const (
+	rspHeaderSize = 4 // client mark(2) + connection index(1) + error code(1)
+	rspConnIndex  = 2
+	rspCodeIndex  = 3
+)
 
-	s.writer, err = s.conn.Writer(s.connCtx, websocket.MessageBinary)
+func rspHeader(clientMark uint32, ec uint8) []byte {
+	return []byte{uint8(clientMark), uint8(clientMark >> 8), uint8(markConnIndex(clientMark)), ec}
+}
 
-	if err != nil {
-		if s.connErrLock.TryLock() {
-			s.connErr = err
-		}
-		s.conn = nil
-		s.connCtxCancel()
-		s.writeLock.Unlock()
-		return false
+// wireRspHeader returns the header of a response as sent on the connection.
+func (s *session) wireRspHeader(header []byte) []byte {
+	if s.protocol.markSize == 1 {
+		return []byte{header[0], header[rspCodeIndex]}
 	}
-	return true
+	return []byte{header[0], header[1], header[rspCodeIndex]}
 }
 
-func (s *session) writeDone(err error) {
-	if s.writer != nil {
-		closeErr := s.writer.Close()
-		if err == nil {
-			err = closeErr
-		}
-		s.writer = nil
-	}
-	if err != nil {
-		if s.connErrLock.TryLock() {
-			s.connErr = err
-		}
-		s.conn = nil
-		s.connCtxCancel()
+// requireWrite opens a message on the connection of clientMark, which is
+// nil if it is closed.
+func (s *session) requireWrite(clientMark uint32) *sessionConn {
+	c := s.conns[markConnIndex(clientMark)].Load()
+	if c == nil || !c.requireWrite() {
+		return nil
 	}
-	s.writeLock.Unlock()
+	return c
 }
 
 func (s *session) write(d []byte) {
-	if !s.requireWrite() {
+	if len(d) < rspHeaderSize {
 		return
 	}
-	_, err := s.writer.Write(d)
-	s.writeDone(err)
+	clientMark := connMark(int(d[rspConnIndex]), uint16(d[0])|uint16(d[1])<<8)
+	if d[rspCodeIndex] != wsfsprotocol.ErrorPartialResponse && s.tracing() {
+		s.traceResult(clientMark, d[rspCodeIndex], "")
+	}
+	c := s.requireWrite(clientMark)
+	if c == nil {
+		return
+	}
+	_, err := c.writer.Write(s.wireRspHeader(d[:rspHeaderSize]))
+	if err == nil {
+		_, err = c.writer.Write(d[rspHeaderSize:])
+	}
+	c.writeDone(err)
 }
 
-func (s *session) beginRsp(clientMark uint8, ec uint8) bool {
-	if !s.requireWrite() {
-		return false
+// beginRsp opens a response, whose body is written to the writer of the
+// returned connection before calling its writeDone. It returns nil if the
+// connection is closed.
+func (s *session) beginRsp(clientMark uint32, ec uint8) *sessionConn {
+	// errors are traced with their desc by writeRspError
+	if ec == wsfsprotocol.ErrorOK && s.tracing() {
+		s.traceResult(clientMark, ec, "")
 	}
-	_, err := s.writer.Write([]byte{clientMark, ec})
+	c := s.requireWrite(clientMark)
+	if c == nil {
+		return nil
+	}
+	_, err := c.writer.Write(s.wireRspHeader(rspHeader(clientMark, ec)))
 	if err != nil {
-		s.writeDone(err)
-		return false
+		c.writeDone(err)
+		return nil
 	}
-	return true
+	return c
 }
 
-func (s *session) writeRspOK(clientMark uint8) {
-	if s.beginRsp(clientMark, wsfsprotocol.ErrorOK) {
-		s.writeDone(nil)
+func (s *session) writeRspOK(clientMark uint32) {
+	if c := s.beginRsp(clientMark, wsfsprotocol.ErrorOK); c != nil {
+		c.writeDone(nil)
 	}
 }
 
-func (s *session) writeRspError(clientMark uint8, ec uint8, desc string) {
-	if !s.beginRsp(clientMark, ec) {
+func (s *session) writeRspError(clientMark uint32, ec uint8, desc string) {
+	if s.tracing() {
+		s.traceResult(clientMark, ec, desc)
+	}
+	c := s.beginRsp(clientMark, ec)
+	if c == nil {
 		return
 	}
 	if len(desc) > wsfsprotocol.MaxErrorDescLength {
 		desc = desc[:wsfsprotocol.MaxErrorDescLength]
 	}
-	err := wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: desc}, s.writer)
-	s.writeDone(err)
+	err := wsfsprotocol.WriteRspErrorToWriter(wsfsprotocol.RspError{Desc: desc}, c.writer)
+	c.writeDone(err)
 }
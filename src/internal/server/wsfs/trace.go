@@ -0,0 +1,308 @@
+package wsfs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"wsfs-core/internal/server/config"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrTraceDisabled = errors.New("wsfs: no trace file configured")
+	ErrBadTrace      = errors.New("wsfs: trace duration and max data can not be negative")
+)
+
+const defaultTraceDuration = 10 * time.Minute
+
+// Tracer writes the commands of traced sessions to the trace file, one JSON
+// line per command with its result. A trace targets a session id or all the
+// sessions of a user, and expires on its own.
+type Tracer struct {
+	lock     sync.Mutex
+	path     string
+	file     *os.File
+	logger   zerolog.Logger
+	duration time.Duration
+	maxData  int
+
+	targets    map[traceTarget]time.Time // to the expiry
+	configured map[traceTarget]bool      // started by the config
+}
+
+// traceTarget has either a username or a session id.
+type traceTarget struct {
+	username string
+	id       string
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{
+		targets:    make(map[traceTarget]time.Time),
+		configured: make(map[traceTarget]bool),
+	}
+}
+
+// TraceConfig is a trace config checked by Tracer.Prepare, holding its trace
+// file if the path changed. It is applied by Tracer.Reconfigure, or dropped
+// by Close.
+type TraceConfig struct {
+	c    config.Trace
+	file *os.File
+}
+
+// Prepare checks c and opens its trace file if the path changed, without
+// changing the tracer.
+func (t *Tracer) Prepare(c config.Trace) (*TraceConfig, error) {
+	if c.Duration < 0 || c.MaxData < 0 {
+		return nil, ErrBadTrace
+	}
+
+	t.lock.Lock()
+	path := t.path
+	t.lock.Unlock()
+
+	p := &TraceConfig{c: c}
+	if c.File != "" && c.File != path {
+		file, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		p.file = file
+	}
+	return p, nil
+}
+
+// Close closes the trace file of a config which is not applied.
+func (p *TraceConfig) Close() {
+	if p.file != nil {
+		_ = p.file.Close()
+		p.file = nil
+	}
+}
+
+// Reconfigure switches to the trace file of p if its path changed, and
+// starts the traces of the config which were not in the previous one.
+func (t *Tracer) Reconfigure(p *TraceConfig) {
+	defer p.Close()
+	c := p.c
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if c.File != t.path {
+		if t.file != nil {
+			_ = t.file.Close()
+		}
+		t.path = c.File
+		t.file = p.file
+		if t.file != nil {
+			t.logger = zerolog.New(t.file).With().Timestamp().Logger()
+		}
+		p.file = nil
+	}
+	t.duration = time.Duration(c.Duration) * time.Second
+	if t.duration == 0 {
+		t.duration = defaultTraceDuration
+	}
+	t.maxData = c.MaxData
+
+	configured := make(map[traceTarget]bool)
+	if t.file != nil {
+		for _, username := range c.Users {
+			configured[traceTarget{username: username}] = true
+		}
+		for _, id := range c.Sessions {
+			configured[traceTarget{id: id}] = true
+		}
+	}
+	for target := range configured {
+		if !t.configured[target] {
+			t.start(target, t.duration)
+		}
+	}
+	t.configured = configured
+}
+
+func (t *Tracer) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file = nil
+		t.path = ""
+	}
+}
+
+// Start traces the sessions of username, or the session id, for d, or for
+// the configured duration if d is 0. The trace applies to sessions on their
+// next connection; see SessionRegistry.ApplyTrace for the running ones.
+func (t *Tracer) Start(username, id string, d time.Duration) (until time.Time, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.file == nil {
+		return time.Time{}, ErrTraceDisabled
+	}
+	if d <= 0 {
+		d = t.duration
+	}
+	return t.start(traceTarget{username: username, id: id}, d), nil
+}
+
+func (t *Tracer) start(target traceTarget, d time.Duration) time.Time {
+	until := time.Now().Add(d)
+	t.targets[target] = until
+	event := log.Warn().Time("Until", until)
+	if target.username != "" {
+		event = event.Str("User", target.username)
+	} else {
+		event = event.Str("Id", target.id)
+	}
+	event.Msg("Trace started")
+	return until
+}
+
+// until returns when the trace of a session ends, or zero if it is not
+// traced. Expired targets are removed.
+func (t *Tracer) until(username, id string) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var until time.Time
+	now := time.Now()
+	for target, expiry := range t.targets {
+		if !now.Before(expiry) {
+			delete(t.targets, target)
+			continue
+		}
+		matched := (target.username != "" && target.username == username) || (target.id != "" && target.id == id)
+		if matched && expiry.After(until) {
+			until = expiry
+		}
+	}
+	return until
+}
+
+func (t *Tracer) loggerAndMaxData() (zerolog.Logger, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.file == nil {
+		return zerolog.Nop(), 0
+	}
+	return t.logger, t.maxData
+}
+
+// traceCall is a traced command waiting for its response.
+type traceCall struct {
+	cmd   string
+	req   map[string]any
+	start time.Time
+}
+
+// tracing reports whether the commands of the session are traced. It ends
+// an expired trace.
+func (s *session) tracing() bool {
+	until := s.traceUntil.Load()
+	if until == 0 {
+		return false
+	}
+	if time.Now().UnixNano() < until {
+		return true
+	}
+	if s.traceUntil.CompareAndSwap(until, 0) {
+		s.traceCalls.Clear()
+		log.Info().Str("Id", s.Id()).Msg("Session trace expired")
+	}
+	return false
+}
+
+// applyTrace starts or extends the trace of the session if the tracer
+// targets it. id is the current id of the session.
+func (s *session) applyTrace(id string) bool {
+	tracer := s.registry.tracer.Load()
+	if tracer == nil {
+		return false
+	}
+	until := tracer.until(s.Username, id)
+	if until.IsZero() {
+		return s.tracing()
+	}
+	for {
+		current := s.traceUntil.Load()
+		if until.UnixNano() <= current {
+			return true
+		}
+		if s.traceUntil.CompareAndSwap(current, until.UnixNano()) {
+			return true
+		}
+	}
+}
+
+func (s *session) traceCommand(clientMark uint32, req any) {
+	tracer := s.registry.tracer.Load()
+	if tracer == nil {
+		return
+	}
+	logger, maxData := tracer.loggerAndMaxData()
+	call := &traceCall{
+		cmd:   traceCmdName(req),
+		req:   traceFields(req, maxData),
+		start: time.Now(),
+	}
+	if _, loaded := s.traceCalls.LoadOrStore(clientMark, call); loaded {
+		// no response of its own, e.g. write stream data
+		logger.Info().Str("Id", s.Id()).Str("User", s.Username).Uint16("ClientMark", uint16(clientMark)).
+			Int("Conn", markConnIndex(clientMark)).Str("Cmd", call.cmd).Any("Req", call.req).Msg("Command")
+	}
+}
+
+func (s *session) traceResult(clientMark uint32, ec uint8, desc string) {
+	tracer := s.registry.tracer.Load()
+	if tracer == nil {
+		return
+	}
+	logger, _ := tracer.loggerAndMaxData()
+	event := logger.Info().Str("Id", s.Id()).Str("User", s.Username).Uint16("ClientMark", uint16(clientMark)).
+		Int("Conn", markConnIndex(clientMark))
+	if v, ok := s.traceCalls.LoadAndDelete(clientMark); ok {
+		call := v.(*traceCall)
+		event = event.Str("Cmd", call.cmd).Any("Req", call.req).Dur("Latency", time.Since(call.start))
+	}
+	event = event.Uint8("Result", ec)
+	if desc != "" {
+		event = event.Str("Desc", desc)
+	}
+	event.Msg("Command")
+}
+
+// traceCmdName turns CmdReadStruct into Read.
+func traceCmdName(req any) string {
+	name := reflect.TypeOf(req).Name()
+	return strings.TrimSuffix(strings.TrimPrefix(name, "Cmd"), "Struct")
+}
+
+// traceFields returns the fields of a command, with data payloads in hex
+// truncated to maxData bytes.
+func traceFields(req any, maxData int) map[string]any {
+	v := reflect.ValueOf(req)
+	fields := make(map[string]any, v.NumField())
+	for i := range v.NumField() {
+		value := v.Field(i).Interface()
+		if data, ok := value.([]byte); ok {
+			if len(data) > maxData {
+				value = fmt.Sprintf("%s... (%d bytes)", hex.EncodeToString(data[:maxData]), len(data))
+			} else {
+				value = hex.EncodeToString(data)
+			}
+		}
+		fields[v.Type().Field(i).Name] = value
+	}
+	return fields
+}
@@ -1,24 +1,86 @@
 package wsfs
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"strings"
 	"wsfs-core/internal/share/wsfsprotocol"
 
 	"github.com/coder/websocket"
 )
 
-func (h *Handler) upgrade(rsp http.ResponseWriter, req *http.Request, resumeId string) (*websocket.Conn, error) {
+var (
+	ErrBadCompression          = errors.New(`wsfs: compression must be "off", "on" or "context-takeover"`)
+	ErrBadCompressionThreshold = errors.New("wsfs: compression threshold can not be negative")
+)
+
+// ParseCompression returns the permessage-deflate mode of a config value.
+// "on" compresses each message on its own, and "context-takeover" keeps the
+// window between messages, compressing better with more memory.
+func ParseCompression(value string) (websocket.CompressionMode, error) {
+	switch value {
+	case "", "off":
+		return websocket.CompressionDisabled, nil
+	case "on":
+		return websocket.CompressionNoContextTakeover, nil
+	case "context-takeover":
+		return websocket.CompressionContextTakeover, nil
+	}
+	return websocket.CompressionDisabled, ErrBadCompression
+}
+
+// upgrade accepts a connection speaking one of subprotocols, advertising
+// the commands of the one chosen.
+func (h *Handler) upgrade(rsp http.ResponseWriter, req *http.Request, resumeId string, caps wsfsprotocol.Capabilities, subprotocols []string) (*websocket.Conn, error) {
+	// chosen here rather than by Accept, since the headers are written first
+	subprotocol := selectSubprotocol(req, subprotocols)
+	if subprotocol != "" {
+		subprotocols = []string{subprotocol}
+		versionCommands := wsfsprotocol.SubprotocolCommands(subprotocol)
+		caps.Commands = slices.DeleteFunc(slices.Clone(caps.Commands), func(cmd uint8) bool {
+			return !slices.Contains(versionCommands, cmd)
+		})
+	}
+
 	if len(resumeId) != 0 {
 		rsp.Header().Set("X-Wsfs-Resume", resumeId)
 	}
+	caps.WriteHeader(rsp.Header())
 	conn, err := websocket.Accept(rsp, req, &websocket.AcceptOptions{
-		Subprotocols: []string{wsfsprotocol.WSSubprotocol},
+		Subprotocols:         subprotocols,
+		CompressionMode:      h.featureOpts.Compression,
+		CompressionThreshold: h.featureOpts.CompressionThreshold,
 	})
 	if err != nil {
 		return nil, err
 	}
-	if conn.Subprotocol() != wsfsprotocol.WSSubprotocol {
+	if _, ok := protocolVersions[conn.Subprotocol()]; !ok || !slices.Contains(subprotocols, conn.Subprotocol()) {
+		_ = conn.Close(websocket.StatusProtocolError, "unsupported subprotocol")
 		return nil, ErrBadSubprotocol
 	}
 	return conn, nil
 }
+
+// selectSubprotocol returns the first of subprotocols offered by the client,
+// or "" if none is.
+func selectSubprotocol(req *http.Request, subprotocols []string) string {
+	var offered []string
+	for _, value := range req.Header.Values("Sec-WebSocket-Protocol") {
+		for token := range strings.SplitSeq(value, ",") {
+			offered = append(offered, strings.TrimSpace(token))
+		}
+	}
+	for _, subprotocol := range subprotocols {
+		if slices.Contains(offered, subprotocol) {
+			return subprotocol
+		}
+	}
+	return ""
+}
+
+// compressed reports whether the upgrade response negotiated
+// permessage-deflate.
+func compressed(h http.Header) bool {
+	return strings.Contains(h.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
@@ -0,0 +1,201 @@
+package wsfsprotocol
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The server advertises its capabilities in these headers of the upgrade
+// response. A server sending none of them predates the negotiation, and is
+// assumed to support everything of WSSubprotocol.
+//
+// The client also sends HeaderMaxMsgSize in the upgrade request, with the
+// largest message size it accepts; the server answers with the size used by
+// the connection, see NegotiateMsgSize.
+const (
+	HeaderServerVersion = "X-Wsfs-Server-Version"
+	HeaderMaxMsgSize    = "X-Wsfs-Max-Msg-Size"
+	HeaderCommands      = "X-Wsfs-Commands"     // comma separated numbers
+	HeaderXAttrPrefix   = "X-Wsfs-Xattr-Prefix" // comma separated, query escaped
+	HeaderReadOnly      = "X-Wsfs-Read-Only"    // "1" if read-only
+	HeaderExtensions    = "X-Wsfs-Extensions"   // comma separated names
+	HeaderSubprotocols  = "X-Wsfs-Subprotocols" // comma separated, preferred first
+	HeaderMaxConns      = "X-Wsfs-Max-Conns"    // connections of a session
+)
+
+// A client adds a connection to a running session by sending HeaderJoin with
+// the id of the session, and HeaderConnIndex with the index of the
+// connection, below Capabilities.MaxConns. The first connection of a session
+// has index 0. A joined connection speaks the subprotocol and message size of
+// the session.
+const (
+	HeaderJoin      = "X-Wsfs-Join"
+	HeaderConnIndex = "X-Wsfs-Conn-Index"
+)
+
+// MaxSessionConns bounds the connections a session may have at once.
+const MaxSessionConns = 16
+
+// Optional extensions of the protocol.
+const (
+	ExtensionSessionResume = "session-resume" // X-Wsfs-Resume
+	ExtensionResumeKey     = "resume-key"     // X-Wsfs-Resume-Key
+	ExtensionGoingAway     = "going-away"     // see GoingAwayReason
+	ExtensionMultiConn     = "multi-conn"     // X-Wsfs-Join
+)
+
+const lastCommand = CmdGetAttrByFD
+
+// lastLegacyCommand is the last command of servers predating the
+// capabilities, which are assumed to support the commands up to it.
+const lastLegacyCommand = CmdRemoveXAttr
+
+// MutatingCommands change the storage, and are refused in a read-only
+// session. Write stream data is not listed, since it needs a write stream
+// opened first.
+var MutatingCommands = []uint8{
+	CmdWrite, CmdAllocate, CmdSetAttr, CmdMkdir, CmdSymLink, CmdRemove,
+	CmdRmDir, CmdWriteAt, CmdCopyFileRange, CmdRename, CmdSetAttrByFD,
+	CmdWriteStreamOpen, CmdCloneFileRange, CmdLink, CmdSetXAttr,
+	CmdRemoveXAttr,
+}
+
+type Capabilities struct {
+	Advertised         bool // false if the server sent no capabilities
+	ServerVersion      string
+	MaxMsgSize         int
+	Commands           []uint8 // enabled commands, sorted
+	AllowedXAttrPrefix []string
+	ReadOnly           bool
+	Extensions         []string
+	Subprotocols       []string // accepted by the server
+	MaxConns           int      // connections a session may have, at least 1
+}
+
+// AllCommands returns the commands of WSSubprotocol.
+func AllCommands() []uint8 {
+	return commandsUpTo(lastCommand)
+}
+
+func commandsUpTo(last uint8) []uint8 {
+	commands := make([]uint8, 0, last)
+	for cmd := CmdOpen; cmd <= last; cmd++ {
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// OpenFlagWrites reports whether opening with these flags may change the
+// file.
+func OpenFlagWrites(oflag uint32) bool {
+	return oflag&O_ACCMODE != O_RDONLY || oflag&(O_CREAT|O_TRUNC|O_APPEND) != 0
+}
+
+func (c Capabilities) HasCommand(cmd uint8) bool {
+	_, found := slices.BinarySearch(c.Commands, cmd)
+	return found
+}
+
+func (c Capabilities) HasExtension(name string) bool {
+	return slices.Contains(c.Extensions, name)
+}
+
+func (c Capabilities) WriteHeader(h http.Header) {
+	h.Set(HeaderServerVersion, c.ServerVersion)
+	h.Set(HeaderMaxMsgSize, strconv.Itoa(c.MaxMsgSize))
+
+	commands := make([]string, len(c.Commands))
+	for i, cmd := range c.Commands {
+		commands[i] = strconv.Itoa(int(cmd))
+	}
+	h.Set(HeaderCommands, strings.Join(commands, ","))
+
+	prefixes := make([]string, len(c.AllowedXAttrPrefix))
+	for i, prefix := range c.AllowedXAttrPrefix {
+		prefixes[i] = url.QueryEscape(prefix)
+	}
+	h.Set(HeaderXAttrPrefix, strings.Join(prefixes, ","))
+
+	if c.ReadOnly {
+		h.Set(HeaderReadOnly, "1")
+	} else {
+		h.Set(HeaderReadOnly, "0")
+	}
+	h.Set(HeaderExtensions, strings.Join(c.Extensions, ","))
+	h.Set(HeaderSubprotocols, strings.Join(c.Subprotocols, ","))
+	h.Set(HeaderMaxConns, strconv.Itoa(c.MaxConns))
+}
+
+// ReadCapabilities parses the capabilities of an upgrade response. Values
+// that can not be parsed fall back to what a server without negotiation
+// supports.
+func ReadCapabilities(h http.Header) Capabilities {
+	c := Capabilities{
+		MaxMsgSize: MaxMsgSize,
+		Commands:   commandsUpTo(lastLegacyCommand),
+		MaxConns:   1,
+	}
+	if h.Get(HeaderCommands) == "" && h.Get(HeaderMaxMsgSize) == "" {
+		return c
+	}
+	c.Advertised = true
+	c.ServerVersion = h.Get(HeaderServerVersion)
+
+	if n, err := strconv.Atoi(h.Get(HeaderMaxMsgSize)); err == nil && n > 0 {
+		c.MaxMsgSize = ClampMsgSize(n)
+	}
+
+	if commands := splitList(h.Get(HeaderCommands)); commands != nil {
+		c.Commands = c.Commands[:0]
+		for _, s := range commands {
+			if cmd, err := strconv.ParseUint(s, 10, 8); err == nil {
+				c.Commands = append(c.Commands, uint8(cmd))
+			}
+		}
+		slices.Sort(c.Commands)
+	}
+
+	c.AllowedXAttrPrefix = []string{}
+	for _, s := range splitList(h.Get(HeaderXAttrPrefix)) {
+		if prefix, err := url.QueryUnescape(s); err == nil && prefix != "" {
+			c.AllowedXAttrPrefix = append(c.AllowedXAttrPrefix, prefix)
+		}
+	}
+
+	c.ReadOnly = h.Get(HeaderReadOnly) == "1"
+	c.Extensions = splitList(h.Get(HeaderExtensions))
+	c.Subprotocols = splitList(h.Get(HeaderSubprotocols))
+	if n, err := strconv.Atoi(h.Get(HeaderMaxConns)); err == nil && n > 0 && c.HasExtension(ExtensionMultiConn) {
+		c.MaxConns = min(n, MaxSessionConns)
+	}
+	return c
+}
+
+// NegotiateMsgSize returns the message size of a connection whose client
+// requested the X-Wsfs-Max-Msg-Size header value, with the server allowing
+// at most limit. A client requesting none gets MaxMsgSize.
+func NegotiateMsgSize(requested string, limit int) int {
+	n, err := strconv.Atoi(requested)
+	if err != nil {
+		return MaxMsgSize
+	}
+	return ClampMsgSize(min(n, limit))
+}
+
+// ClampMsgSize bounds a message size to what every peer supports.
+func ClampMsgSize(n int) int {
+	return min(max(n, MaxMsgSize), MaxNegotiableMsgSize)
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,82 @@
+package wsfsprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+)
+
+// A compound command runs steps, each the command number followed by the
+// struct of that command, in order on the server. Only CompoundStepCommands
+// may be steps. The response carries the result of each step run: its
+// error code, the length of its body as an uint32, and its body, which is
+// the response struct of the step, or an RspError if it failed. The steps
+// stop at the first failed one, whose error code is also the code of the
+// response.
+//
+// An FD of a step may be CompoundFD(i), standing for the fd opened by the
+// step i of the same compound command. Files opened by the steps stay open
+// when a later step fails.
+
+const MaxCompoundSteps = 32
+
+// compoundFDBase is above any fd a session opens in practice.
+const compoundFDBase uint32 = 0xFFFFFF00
+
+var CompoundStepCommands = []uint8{CmdOpen, CmdClose, CmdGetAttr, CmdMkdir, CmdReadAt, CmdGetAttrByFD}
+
+var ErrBadCompound = errors.New("wsfsprotocol: bad compound response")
+
+func CompoundFD(step int) uint32 {
+	return compoundFDBase | uint32(step)
+}
+
+// CompoundFDStep returns the step referred by fd, if fd is a CompoundFD.
+func CompoundFDStep(fd uint32) (step int, ok bool) {
+	if fd&compoundFDBase != compoundFDBase {
+		return 0, false
+	}
+	return int(fd &^ compoundFDBase), true
+}
+
+func IsCompoundStepCommand(cmd uint8) bool {
+	return slices.Contains(CompoundStepCommands, cmd)
+}
+
+// CompoundResultHeaderSize is the size of the code and length of a result.
+const CompoundResultHeaderSize = 5
+
+type CompoundResult struct {
+	Code uint8
+	Body []byte
+}
+
+func WriteCompoundResultHeader(code uint8, size int, w io.Writer) error {
+	var header [CompoundResultHeaderSize]byte
+	header[0] = code
+	binary.LittleEndian.PutUint32(header[1:], uint32(size))
+	_, err := w.Write(header[:])
+	return err
+}
+
+// ReadCompoundResults splits the data of a compound response. The bodies
+// point into data.
+func ReadCompoundResults(data []byte) ([]CompoundResult, error) {
+	var results []CompoundResult
+	for len(data) > 0 {
+		if len(data) < CompoundResultHeaderSize {
+			return results, ErrBadCompound
+		}
+		size := binary.LittleEndian.Uint32(data[1:])
+		if uint64(len(data)-CompoundResultHeaderSize) < uint64(size) {
+			return results, ErrBadCompound
+		}
+		results = append(results, CompoundResult{
+			Code: data[0],
+			Body: data[CompoundResultHeaderSize:][:size],
+		})
+		data = data[CompoundResultHeaderSize+int(size):]
+	}
+	return results, nil
+}
@@ -6,45 +6,53 @@ const (
 	MaxMsgSize        int = 8192
 	MaxCommandLength  int = MaxMsgSize
 	MaxResponseLength int = MaxMsgSize
-	WSSubprotocol         = "WSFS/draft.6"
+	WSSubprotocol         = "WSFS/draft.7"
 )
 
-const MaxErrorDescLength int = MaxResponseLength - 4 // header(2) + string length prefix(2)
+// A connection negotiating X-Wsfs-Max-Msg-Size may use messages larger than
+// MaxMsgSize, up to MaxNegotiableMsgSize.
+const MaxNegotiableMsgSize int = 1 << 20
+
+const MaxErrorDescLength int = MaxResponseLength - 5 // header(3) + string length prefix(2)
 
 const (
-	CmdOpen            uint8 = 1
-	CmdClose           uint8 = 2
-	CmdRead            uint8 = 3
-	CmdReadDir         uint8 = 4
-	CmdReadLink        uint8 = 5
-	CmdWrite           uint8 = 6
-	CmdSeek            uint8 = 7
-	CmdAllocate        uint8 = 8
-	CmdGetAttr         uint8 = 9
-	CmdSetAttr         uint8 = 10
-	CmdSync            uint8 = 11
-	CmdMkdir           uint8 = 12
-	CmdSymLink         uint8 = 13
-	CmdRemove          uint8 = 14
-	CmdRmDir           uint8 = 15
-	CmdFsStat          uint8 = 16
-	CmdReadAt          uint8 = 17
-	CmdWriteAt         uint8 = 18
-	CmdCopyFileRange   uint8 = 19
-	CmdRename          uint8 = 20
-	CmdSetAttrByFD     uint8 = 21
-	CmdReadDirPlus     uint8 = 22
-	CmdWriteStreamOpen uint8 = 23
-	CmdWriteStreamData uint8 = 24
-	CmdCloneFileRange  uint8 = 25
-	CmdGetFileLock     uint8 = 26
-	CmdSetFileLock     uint8 = 27
-	CmdSetFileLockWait uint8 = 28
-	CmdLink            uint8 = 29
-	CmdSetXAttr        uint8 = 30
-	CmdGetXAttr        uint8 = 31
-	CmdListXAttr       uint8 = 32
-	CmdRemoveXAttr     uint8 = 33
+	CmdOpen             uint8 = 1
+	CmdClose            uint8 = 2
+	CmdRead             uint8 = 3
+	CmdReadDir          uint8 = 4
+	CmdReadLink         uint8 = 5
+	CmdWrite            uint8 = 6
+	CmdSeek             uint8 = 7
+	CmdAllocate         uint8 = 8
+	CmdGetAttr          uint8 = 9
+	CmdSetAttr          uint8 = 10
+	CmdSync             uint8 = 11
+	CmdMkdir            uint8 = 12
+	CmdSymLink          uint8 = 13
+	CmdRemove           uint8 = 14
+	CmdRmDir            uint8 = 15
+	CmdFsStat           uint8 = 16
+	CmdReadAt           uint8 = 17
+	CmdWriteAt          uint8 = 18
+	CmdCopyFileRange    uint8 = 19
+	CmdRename           uint8 = 20
+	CmdSetAttrByFD      uint8 = 21
+	CmdReadDirPlus      uint8 = 22
+	CmdWriteStreamOpen  uint8 = 23
+	CmdWriteStreamData  uint8 = 24
+	CmdCloneFileRange   uint8 = 25
+	CmdGetFileLock      uint8 = 26
+	CmdSetFileLock      uint8 = 27
+	CmdSetFileLockWait  uint8 = 28
+	CmdLink             uint8 = 29
+	CmdSetXAttr         uint8 = 30
+	CmdGetXAttr         uint8 = 31
+	CmdListXAttr        uint8 = 32
+	CmdRemoveXAttr      uint8 = 33
+	CmdReadStreamOpen   uint8 = 34
+	CmdReadStreamCredit uint8 = 35
+	CmdCompound         uint8 = 36
+	CmdGetAttrByFD      uint8 = 37
 )
 
 const (
@@ -47,6 +47,10 @@ var (
 	inputFile  = "struct.go"
 	outputFile = "structHelper"
 	buildTag   string
+	// structs of base files can be used as fields, and get no helper; a
+	// protocol version puts its structs in its own file, with struct.go as
+	// a base file
+	baseFiles []string
 
 	packageName string
 	unsafeMode  bool // aka amd64 mode
@@ -422,7 +426,8 @@ func genBytesCommentAndHelper(buf *bytes.Buffer) {
 	}
 	buf.WriteString("*/\n\n")
 
-	if len(bytesStructs) == 0 {
+	if len(bytesStructs) == 0 || len(baseFiles) != 0 {
+		// generated with the base files
 		return
 	}
 
@@ -527,23 +532,38 @@ func genHelper() error {
 }
 
 func main() {
-	if len(os.Args) != 5 || (os.Args[1] != "0" && os.Args[1] != "1") {
+	if len(os.Args) < 5 || (os.Args[1] != "0" && os.Args[1] != "1") {
 		fmt.Println("Bad usage")
-		fmt.Printf("Usage: %s UNSAFE_MODE(0/1) BUILD_TAG INPUT_FILE OUTPUT_FILE\n", os.Args[0])
+		fmt.Printf("Usage: %s UNSAFE_MODE(0/1) BUILD_TAG INPUT_FILE OUTPUT_FILE [BASE_FILE...]\n", os.Args[0])
 		os.Exit(1)
 	}
 	inputFile = os.Args[3]
 	outputFile = os.Args[4]
 	buildTag = os.Args[2]
+	baseFiles = os.Args[5:]
 	if os.Args[1] == "1" {
 		unsafeMode = true
 	}
 
+	for _, baseFile := range baseFiles {
+		if err := parseStructInfos(baseFile); err != nil {
+			fmt.Println("Unable to parse base file:", err)
+			os.Exit(1)
+		}
+	}
+	baseStructs := map[string]bool{}
+	for name := range structInfos {
+		baseStructs[name] = true
+	}
+
 	err := parseStructInfos(inputFile)
 	if err != nil {
 		fmt.Println("Unable to parse file:", err)
 		os.Exit(1)
 	}
+	sortedStructNames = slices.DeleteFunc(sortedStructNames, func(name string) bool {
+		return baseStructs[name]
+	})
 
 	for {
 		if analyzeStructInfoPODSize() == 0 {
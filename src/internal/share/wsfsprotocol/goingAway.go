@@ -0,0 +1,28 @@
+package wsfsprotocol
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A server shutting down closes WSFS connections with the WebSocket status
+// 1001 (going away) and this reason, telling clients to wait before resuming
+// their sessions.
+const goingAwayReasonPrefix = "retry-after="
+
+func GoingAwayReason(retryAfter time.Duration) string {
+	return goingAwayReasonPrefix + strconv.Itoa(int(retryAfter/time.Second))
+}
+
+func ParseGoingAwayReason(reason string) (retryAfter time.Duration, ok bool) {
+	seconds, ok := strings.CutPrefix(reason, goingAwayReasonPrefix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(seconds)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
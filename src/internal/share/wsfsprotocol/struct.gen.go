@@ -10,6 +10,7 @@ import (
 
 /*
 Command structs with []byte payload fields:
+  - CmdCompoundStruct
   - CmdSetXAttrStruct
   - CmdWriteAtStruct
   - CmdWriteStreamDataStruct
@@ -53,6 +54,10 @@ func GetCmdCloseStructRequiredSize(d CmdCloseStruct) int {
 	return 4
 }
 
+func GetCmdCompoundStructRequiredSize(d CmdCompoundStruct) int {
+	return len(d.Steps) + 0
+}
+
 func GetCmdCopyFileRangeStructRequiredSize(d CmdCopyFileRangeStruct) int {
 	return 32
 }
@@ -61,6 +66,10 @@ func GetCmdFsStatStructRequiredSize(d CmdFsStatStruct) int {
 	return len(d.Path) + 2
 }
 
+func GetCmdGetAttrByFDStructRequiredSize(d CmdGetAttrByFDStruct) int {
+	return 4
+}
+
 func GetCmdGetAttrStructRequiredSize(d CmdGetAttrStruct) int {
 	return len(d.Path) + 2
 }
@@ -105,6 +114,14 @@ func GetCmdReadLinkStructRequiredSize(d CmdReadLinkStruct) int {
 	return len(d.Path) + 2
 }
 
+func GetCmdReadStreamCreditStructRequiredSize(d CmdReadStreamCreditStruct) int {
+	return 5
+}
+
+func GetCmdReadStreamOpenStructRequiredSize(d CmdReadStreamOpenStruct) int {
+	return 16
+}
+
 func GetCmdReadStructRequiredSize(d CmdReadStruct) int {
 	return 12
 }
@@ -361,6 +378,14 @@ func WriteCmdCloseStructToWriter(d CmdCloseStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdCompoundStructToWriter(d CmdCompoundStruct, w io.Writer) error {
+	// Steps []byte
+	if _, err := w.Write(d.Steps); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdCopyFileRangeStructToWriter(d CmdCopyFileRangeStruct, w io.Writer) error {
 	var buf [32]byte
 	// SrcFD uint32
@@ -387,6 +412,16 @@ func WriteCmdFsStatStructToWriter(d CmdFsStatStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdGetAttrByFDStructToWriter(d CmdGetAttrByFDStruct, w io.Writer) error {
+	var buf [4]byte
+	// FD uint32
+	binary.LittleEndian.PutUint32(buf[0:], uint32(d.FD))
+	if _, err := w.Write(buf[:4]); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdGetAttrStructToWriter(d CmdGetAttrStruct, w io.Writer) error {
 	// Path string
 	if err := WriteStrToWriter(d.Path, w); err != nil {
@@ -521,6 +556,32 @@ func WriteCmdReadLinkStructToWriter(d CmdReadLinkStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdReadStreamCreditStructToWriter(d CmdReadStreamCreditStruct, w io.Writer) error {
+	var buf [5]byte
+	// Credit uint32
+	binary.LittleEndian.PutUint32(buf[0:], uint32(d.Credit))
+	// Cancel uint8
+	buf[4] = byte(d.Cancel)
+	if _, err := w.Write(buf[:5]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func WriteCmdReadStreamOpenStructToWriter(d CmdReadStreamOpenStruct, w io.Writer) error {
+	var buf [16]byte
+	// FD uint32
+	binary.LittleEndian.PutUint32(buf[0:], uint32(d.FD))
+	// Offset uint64
+	binary.LittleEndian.PutUint64(buf[4:], uint64(d.Offset))
+	// Window uint32
+	binary.LittleEndian.PutUint32(buf[12:], uint32(d.Window))
+	if _, err := w.Write(buf[:16]); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdReadStructToWriter(d CmdReadStruct, w io.Writer) error {
 	var buf [12]byte
 	// FD uint32
@@ -1093,6 +1154,16 @@ func ReadCmdCloseStructFromReader(d *CmdCloseStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdCompoundStructFromReader(d *CmdCompoundStruct, r io.Reader) error {
+	// Steps []byte
+	var err error
+	d.Steps, err = io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdCopyFileRangeStructFromReader(d *CmdCopyFileRangeStruct, r io.Reader) error {
 	var buf [32]byte
 	// SrcFD uint32
@@ -1119,6 +1190,16 @@ func ReadCmdFsStatStructFromReader(d *CmdFsStatStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdGetAttrByFDStructFromReader(d *CmdGetAttrByFDStruct, r io.Reader) error {
+	var buf [4]byte
+	// FD uint32
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return err
+	}
+	d.FD = (uint32)(binary.LittleEndian.Uint32(buf[0:]))
+	return nil
+}
+
 func ReadCmdGetAttrStructFromReader(d *CmdGetAttrStruct, r io.Reader) error {
 	// Path string
 	if err := ReadStrFromReader(r, &d.Path); err != nil {
@@ -1253,6 +1334,32 @@ func ReadCmdReadLinkStructFromReader(d *CmdReadLinkStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdReadStreamCreditStructFromReader(d *CmdReadStreamCreditStruct, r io.Reader) error {
+	var buf [5]byte
+	// Credit uint32
+	// Cancel uint8
+	if _, err := io.ReadFull(r, buf[:5]); err != nil {
+		return err
+	}
+	d.Credit = (uint32)(binary.LittleEndian.Uint32(buf[0:]))
+	d.Cancel = (uint8)(buf[4])
+	return nil
+}
+
+func ReadCmdReadStreamOpenStructFromReader(d *CmdReadStreamOpenStruct, r io.Reader) error {
+	var buf [16]byte
+	// FD uint32
+	// Offset uint64
+	// Window uint32
+	if _, err := io.ReadFull(r, buf[:16]); err != nil {
+		return err
+	}
+	d.FD = (uint32)(binary.LittleEndian.Uint32(buf[0:]))
+	d.Offset = (uint64)(binary.LittleEndian.Uint64(buf[4:]))
+	d.Window = (uint32)(binary.LittleEndian.Uint32(buf[12:]))
+	return nil
+}
+
 func ReadCmdReadStructFromReader(d *CmdReadStruct, r io.Reader) error {
 	var buf [12]byte
 	// FD uint32
@@ -1801,6 +1908,16 @@ func ReadTimespecFromReader(d *Timespec, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdCompoundStructFromReaderWithBuffer(d *CmdCompoundStruct, r io.Reader, dataBuf []byte) error {
+	// Steps []byte
+	var err error
+	d.Steps, err = readAllToBuffer(r, dataBuf)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdSetXAttrStructFromReaderWithBuffer(d *CmdSetXAttrStruct, r io.Reader, dataBuf []byte) error {
 	var buf [4]byte
 	// Path string
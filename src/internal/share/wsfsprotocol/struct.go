@@ -162,6 +162,25 @@ type CmdWriteStreamDataStruct struct {
 	Data  []byte
 }
 
+type CmdReadStreamOpenStruct struct {
+	FD     uint32
+	Offset uint64
+	Window uint32 // bytes the server may send before more credit
+}
+
+type CmdReadStreamCreditStruct struct {
+	Credit uint32 // bytes
+	Cancel uint8
+}
+
+type CmdCompoundStruct struct {
+	Steps []byte // see compound.go
+}
+
+type CmdGetAttrByFDStruct struct {
+	FD uint32
+}
+
 type CmdGetFileLockStruct struct {
 	FD       uint32
 	FileLock FileLockInfo
@@ -15,6 +15,7 @@ import (
 
 /*
 Command structs with []byte payload fields:
+  - CmdCompoundStruct
   - CmdSetXAttrStruct
   - CmdWriteAtStruct
   - CmdWriteStreamDataStruct
@@ -58,6 +59,10 @@ func GetCmdCloseStructRequiredSize(d CmdCloseStruct) int {
 	return 4
 }
 
+func GetCmdCompoundStructRequiredSize(d CmdCompoundStruct) int {
+	return len(d.Steps) + 0
+}
+
 func GetCmdCopyFileRangeStructRequiredSize(d CmdCopyFileRangeStruct) int {
 	return 32
 }
@@ -66,6 +71,10 @@ func GetCmdFsStatStructRequiredSize(d CmdFsStatStruct) int {
 	return len(d.Path) + 2
 }
 
+func GetCmdGetAttrByFDStructRequiredSize(d CmdGetAttrByFDStruct) int {
+	return 4
+}
+
 func GetCmdGetAttrStructRequiredSize(d CmdGetAttrStruct) int {
 	return len(d.Path) + 2
 }
@@ -110,6 +119,14 @@ func GetCmdReadLinkStructRequiredSize(d CmdReadLinkStruct) int {
 	return len(d.Path) + 2
 }
 
+func GetCmdReadStreamCreditStructRequiredSize(d CmdReadStreamCreditStruct) int {
+	return 5
+}
+
+func GetCmdReadStreamOpenStructRequiredSize(d CmdReadStreamOpenStruct) int {
+	return 16
+}
+
 func GetCmdReadStructRequiredSize(d CmdReadStruct) int {
 	return 12
 }
@@ -374,6 +391,14 @@ func WriteCmdCloseStructToWriter(d CmdCloseStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdCompoundStructToWriter(d CmdCompoundStruct, w io.Writer) error {
+	// Steps []byte
+	if _, err := w.Write(d.Steps); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdCopyFileRangeStructToWriter(d CmdCopyFileRangeStruct, w io.Writer) error {
 	// SrcFD uint32
 	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.SrcFD)), 4)); err != nil {
@@ -406,6 +431,14 @@ func WriteCmdFsStatStructToWriter(d CmdFsStatStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdGetAttrByFDStructToWriter(d CmdGetAttrByFDStruct, w io.Writer) error {
+	// FD uint32
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdGetAttrStructToWriter(d CmdGetAttrStruct, w io.Writer) error {
 	// Path string
 	if err := WriteStrToWriter(d.Path, w); err != nil {
@@ -534,6 +567,34 @@ func WriteCmdReadLinkStructToWriter(d CmdReadLinkStruct, w io.Writer) error {
 	return nil
 }
 
+func WriteCmdReadStreamCreditStructToWriter(d CmdReadStreamCreditStruct, w io.Writer) error {
+	// Credit uint32
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.Credit)), 4)); err != nil {
+		return err
+	}
+	// Cancel uint8
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.Cancel)), 1)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func WriteCmdReadStreamOpenStructToWriter(d CmdReadStreamOpenStruct, w io.Writer) error {
+	// FD uint32
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
+		return err
+	}
+	// Offset uint64
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.Offset)), 8)); err != nil {
+		return err
+	}
+	// Window uint32
+	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.Window)), 4)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func WriteCmdReadStructToWriter(d CmdReadStruct, w io.Writer) error {
 	// FD uint32
 	if _, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
@@ -1090,6 +1151,16 @@ func ReadCmdCloseStructFromReader(d *CmdCloseStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdCompoundStructFromReader(d *CmdCompoundStruct, r io.Reader) error {
+	// Steps []byte
+	var err error
+	d.Steps, err = io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdCopyFileRangeStructFromReader(d *CmdCopyFileRangeStruct, r io.Reader) error {
 	// SrcFD uint32
 	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.SrcFD)), 4)); err != nil {
@@ -1122,6 +1193,14 @@ func ReadCmdFsStatStructFromReader(d *CmdFsStatStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdGetAttrByFDStructFromReader(d *CmdGetAttrByFDStruct, r io.Reader) error {
+	// FD uint32
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdGetAttrStructFromReader(d *CmdGetAttrStruct, r io.Reader) error {
 	// Path string
 	if err := ReadStrFromReader(r, &d.Path); err != nil {
@@ -1250,6 +1329,34 @@ func ReadCmdReadLinkStructFromReader(d *CmdReadLinkStruct, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdReadStreamCreditStructFromReader(d *CmdReadStreamCreditStruct, r io.Reader) error {
+	// Credit uint32
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.Credit)), 4)); err != nil {
+		return err
+	}
+	// Cancel uint8
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.Cancel)), 1)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ReadCmdReadStreamOpenStructFromReader(d *CmdReadStreamOpenStruct, r io.Reader) error {
+	// FD uint32
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
+		return err
+	}
+	// Offset uint64
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.Offset)), 8)); err != nil {
+		return err
+	}
+	// Window uint32
+	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.Window)), 4)); err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdReadStructFromReader(d *CmdReadStruct, r io.Reader) error {
 	// FD uint32
 	if _, err := io.ReadFull(r, unsafe.Slice((*byte)(unsafe.Pointer(&d.FD)), 4)); err != nil {
@@ -1774,6 +1881,16 @@ func ReadTimespecFromReader(d *Timespec, r io.Reader) error {
 	return nil
 }
 
+func ReadCmdCompoundStructFromReaderWithBuffer(d *CmdCompoundStruct, r io.Reader, dataBuf []byte) error {
+	// Steps []byte
+	var err error
+	d.Steps, err = readAllToBuffer(r, dataBuf)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func ReadCmdSetXAttrStructFromReaderWithBuffer(d *CmdSetXAttrStruct, r io.Reader, dataBuf []byte) error {
 	// Path string
 	if err := ReadStrFromReader(r, &d.Path); err != nil {
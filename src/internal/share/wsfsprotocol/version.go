@@ -0,0 +1,42 @@
+package wsfsprotocol
+
+import "slices"
+
+// WSSubprotocols are the versions of the protocol spoken by this build, the
+// preferred first. A server accepts any of them, and a client offers all of
+// them, so that old clients keep working with a newer server.
+var WSSubprotocols = []string{WSSubprotocol, WSSubprotocolDraft6}
+
+// WSSubprotocolDraft6 has client marks of 1 byte, limiting a client to 256
+// requests in flight; from WSFS/draft.7 on, they are 2 bytes, little endian.
+const WSSubprotocolDraft6 = "WSFS/draft.6"
+
+// lastCommandDraft6 is the last command of WSFS/draft.6; the later ones
+// need WSFS/draft.7.
+const lastCommandDraft6 = CmdRemoveXAttr
+
+const (
+	MaxNarrowClientMarks = 1 << 8
+	MaxClientMarks       = 1 << 16
+)
+
+// ClientMarkSize returns the bytes of a client mark in the command and
+// response headers of a subprotocol.
+func ClientMarkSize(subprotocol string) int {
+	if subprotocol == WSSubprotocolDraft6 {
+		return 1
+	}
+	return 2
+}
+
+// SubprotocolCommands returns the commands of a subprotocol.
+func SubprotocolCommands(subprotocol string) []uint8 {
+	if subprotocol == WSSubprotocolDraft6 {
+		return commandsUpTo(lastCommandDraft6)
+	}
+	return AllCommands()
+}
+
+func IsSupportedSubprotocol(subprotocol string) bool {
+	return slices.Contains(WSSubprotocols, subprotocol)
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"io"
+	"sync"
 )
 
 //const stringBufferInitalSize int = 64
@@ -48,3 +49,46 @@ func (b *Buffer) Grow(n int) {
 func (b *Buffer) Reset() {
 	b.written = 0
 }
+
+// BufferPool holds buffers by size class, doubling from its smallest size,
+// so that connections of different message sizes can share it.
+type BufferPool struct {
+	minSize int
+	classes []sync.Pool
+}
+
+func NewBufferPool(minSize, maxSize int) *BufferPool {
+	p := &BufferPool{minSize: minSize}
+	p.classes = make([]sync.Pool, p.class(maxSize)+1)
+	return p
+}
+
+func (p *BufferPool) class(size int) int {
+	class := 0
+	for p.minSize<<class < size {
+		class++
+	}
+	return class
+}
+
+// Get returns an empty buffer of at least size bytes.
+func (p *BufferPool) Get(size int) *Buffer {
+	class := p.class(size)
+	if class >= len(p.classes) {
+		return NewBuffer(size)
+	}
+	if buf, ok := p.classes[class].Get().(*Buffer); ok {
+		return buf
+	}
+	return NewBuffer(p.minSize << class)
+}
+
+func (p *BufferPool) Put(buf *Buffer) {
+	buf.Reset()
+	class := p.class(len(buf.Bytes))
+	if p.minSize<<class != len(buf.Bytes) || class >= len(p.classes) {
+		// not from this pool
+		return
+	}
+	p.classes[class].Put(buf)
+}
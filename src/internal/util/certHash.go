@@ -0,0 +1,13 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// X509CertHash formats a certificate hash as accepted by `mount --cert-hash`.
+func X509CertHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return "SHA256:" + hex.EncodeToString(hash[:])
+}
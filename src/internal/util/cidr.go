@@ -0,0 +1,85 @@
+package util
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseCIDRs parses CIDRs; a plain address is taken as a single host prefix.
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("bad CIDR %q: %w", cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("bad CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// PrefixesContain reports whether addr is in any of prefixes. IPv4-mapped
+// IPv6 addresses are matched as IPv4.
+func PrefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressRules permits an address if it is not denied and, when an allow
+// list is given, is allowed.
+type AddressRules struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+func NewAddressRules(allow, deny []string) (r AddressRules, err error) {
+	if r.Allow, err = ParseCIDRs(allow); err != nil {
+		return
+	}
+	r.Deny, err = ParseCIDRs(deny)
+	return
+}
+
+// Permits checks a remote address in "host:port" or "host" form. Addresses
+// without an IP, such as unix socket peers, are only permitted when there is
+// no allow list.
+func (r AddressRules) Permits(remoteAddr string) bool {
+	if len(r.Allow) == 0 && len(r.Deny) == 0 {
+		return true
+	}
+
+	addr, ok := ParseRemoteAddr(remoteAddr)
+	if !ok {
+		return len(r.Allow) == 0
+	}
+	if PrefixesContain(r.Deny, addr) {
+		return false
+	}
+	return len(r.Allow) == 0 || PrefixesContain(r.Allow, addr)
+}
+
+func ParseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(remoteAddr, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,29 @@
+//go:build darwin || freebsd
+
+package util
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// PeerUid returns the uid of the process on the other side of a unix socket.
+func PeerUid(conn *net.UnixConn) (uid uint32, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var cred *unix.Xucred
+	ctlErr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if ctlErr != nil {
+		return 0, ctlErr
+	}
+	if err != nil {
+		return
+	}
+	return cred.Uid, nil
+}
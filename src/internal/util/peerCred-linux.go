@@ -0,0 +1,29 @@
+//go:build linux
+
+package util
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// PeerUid returns the uid of the process on the other side of a unix socket.
+func PeerUid(conn *net.UnixConn) (uid uint32, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	var cred *unix.Ucred
+	ctlErr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if ctlErr != nil {
+		return 0, ctlErr
+	}
+	if err != nil {
+		return
+	}
+	return cred.Uid, nil
+}
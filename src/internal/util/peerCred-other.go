@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package util
+
+import "net"
+
+func PeerUid(conn *net.UnixConn) (uint32, error) {
+	return 0, ErrPeerCredUnsupported
+}
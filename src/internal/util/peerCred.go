@@ -0,0 +1,5 @@
+package util
+
+import "errors"
+
+var ErrPeerCredUnsupported = errors.New("peer credentials are not supported on this platform")
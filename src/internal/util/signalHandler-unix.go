@@ -20,6 +20,9 @@ func SetupSignalHandlers(h SignalHandlers) {
 	if h.Sigterm != nil {
 		sigs = append(sigs, syscall.SIGTERM)
 	}
+	if h.Sigusr2 != nil {
+		sigs = append(sigs, syscall.SIGUSR2)
+	}
 	signal.Notify(sigch, sigs...)
 
 	go func() {
@@ -31,6 +34,8 @@ func SetupSignalHandlers(h SignalHandlers) {
 				go tryCall(h.Sigint, h.OnHandlerPanic)
 			case syscall.SIGTERM:
 				go tryCall(h.Sigterm, h.OnHandlerPanic)
+			case syscall.SIGUSR2:
+				go tryCall(h.Sigusr2, h.OnHandlerPanic)
 			}
 		}
 	}()
@@ -4,6 +4,7 @@ type SignalHandlers struct {
 	Sighup  func()
 	Sigint  func()
 	Sigterm func()
+	Sigusr2 func() // Unix only
 
 	OnHandlerPanic func(any)
 }